@@ -87,6 +87,10 @@ func main() {
 					Name:  "wait",
 					Usage: "Waits for interrupt (Ctl-C) before releasing the stack.  Always true when --run is not given.",
 				},
+				&cli.BoolFlag{
+					Name:  "force_deploy",
+					Usage: "Applies the resources of the stack even when they did not change since they were last applied",
+				},
 			},
 			Action: func(c *cli.Context) (err error) {
 				t := commandInvoked(c)
@@ -102,6 +106,7 @@ func main() {
 					DumpEnv:      c.String("dump_env"),
 					PersistEnv:   c.Bool("persist_env"),
 					Wait:         c.Bool("wait"),
+					ForceDeploy:  c.Bool("force_deploy"),
 				})
 				return
 			},
@@ -111,6 +116,12 @@ func main() {
 			Usage:       "Deploys a stack",
 			ArgsUsage:   "<pipeline file>",
 			Description: "Deploys a stack created from a specific pipeline.",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "force_deploy",
+					Usage: "Applies the resources of the stack even when they did not change since they were last applied",
+				},
+			},
 			Action: func(c *cli.Context) (err error) {
 				t := commandInvoked(c)
 				defer t.completed(err)
@@ -118,6 +129,68 @@ func main() {
 					WorkingDir:   c.String("cwd"),
 					ConfigPath:   c.String("config"),
 					PipelinePath: c.Args().First(),
+					ForceDeploy:  c.Bool("force_deploy"),
+				})
+				return
+			},
+		},
+		{
+			Name:        "render",
+			Usage:       "Renders the resources of a stack",
+			ArgsUsage:   "<pipeline file>",
+			Description: "Renders the resources of a stack created from a specific pipeline, as they would be applied by the deploy steps, without applying them.  The images of the container steps are built, but only pushed with --push.  The Helm releases are not rendered.",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "stack",
+					Usage: "Name of the stack.  Required for the pipelines with a stack family, for which it is the short name.",
+				},
+				&cli.StringFlag{
+					Name:  "output_dir",
+					Usage: "Folder to write the resources to, one YAML file per deploy step.  By default, the resources are written to the standard output.",
+				},
+				&cli.BoolFlag{
+					Name:  "push",
+					Usage: "Push the images, load them into a local cluster, and resolve them to digests, as a deployment would.  By default, the images are only built.",
+				},
+			},
+			Action: func(c *cli.Context) (err error) {
+				t := commandInvoked(c)
+				defer t.completed(err)
+				err = warp.Render(context.Background(), &warp.RenderCfg{
+					WorkingDir:   c.String("cwd"),
+					ConfigPath:   c.String("config"),
+					PipelinePath: c.Args().First(),
+					Stack:        c.String("stack"),
+					OutputDir:    c.String("output_dir"),
+					Push:         c.Bool("push"),
+				})
+				return
+			},
+		},
+		{
+			Name:        "diff",
+			Usage:       "Compares the resources of a stack with the live resources",
+			ArgsUsage:   "<pipeline file>",
+			Description: "Compares the rendered resources of a stack created from a specific pipeline with the live resources of the stack: added resources are prefixed with '+', deleted resources with '-', and modified resources with '~'.  The images of the container steps are built, but only pushed with --push.  The Helm releases are not compared.",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "stack",
+					Usage: "Name of the stack.  Required for the pipelines with a stack family, for which it is the short name.",
+				},
+				&cli.BoolFlag{
+					Name:  "push",
+					Usage: "Push the images, load them into a local cluster, and resolve them to digests, as a deployment would.  By default, the images are only built.",
+				},
+			},
+			Action: func(c *cli.Context) (err error) {
+				t := commandInvoked(c)
+				defer t.completed(err)
+				err = warp.Diff(context.Background(), &warp.DiffCfg{
+					WorkingDir:   c.String("cwd"),
+					ConfigPath:   c.String("config"),
+					PipelinePath: c.Args().First(),
+					Stack:        c.String("stack"),
+					Push:         c.Bool("push"),
 				})
 				return
 			},
@@ -145,6 +218,18 @@ func main() {
 					Name:  "focus",
 					Usage: "Test focus",
 				},
+				&cli.StringFlag{
+					Name:  "changed_since",
+					Usage: "Only executes the commands affected by the files that changed since the given git ref, and the commands they depend on",
+				},
+				&cli.BoolFlag{
+					Name:  "watch",
+					Usage: "After execution, keep the stacks and rerun the commands affected by changes to their working dir and paths, until interrupted",
+				},
+				&cli.BoolFlag{
+					Name:  "force_deploy",
+					Usage: "Applies the resources of the stack even when they did not change since they were last applied",
+				},
 				&cli.BoolFlag{
 					Name:  "bail",
 					Usage: "Bail out on first error",
@@ -158,10 +243,18 @@ func main() {
 					Name:  "report",
 					Usage: "Output path to report folder",
 				},
+				&cli.StringSliceFlag{
+					Name:  "report_format",
+					Usage: "Report format ('fs', 'junit').  Can be repeated to produce multiple reports in the report folder.  Defaults to 'fs' when --report is given.",
+				},
 				&cli.BoolFlag{
 					Name:  "stream",
 					Usage: "Stream results instead of being in interactive mode",
 				},
+				&cli.StringFlag{
+					Name:  "serve",
+					Usage: "Serves the batch progress over HTTP at the given address (e.g., ':8080'): an HTML page at '/', a JSON status at '/status', and Server-Sent Events at '/events'",
+				},
 			},
 			Action: func(c *cli.Context) (err error) {
 				t := commandInvoked(c)
@@ -174,10 +267,15 @@ func main() {
 					MaxStacksPerPipeline: c.Int("max_stacks_per_pipeline"),
 					Tags:                 c.String("tags"),
 					Focus:                c.String("focus"),
+					ChangedSince:         c.String("changed_since"),
 					Bail:                 c.Bool("bail"),
 					Advisory:             c.Bool("advisory"),
 					Report:               c.String("report"),
+					ReportFormats:        c.StringSlice("report_format"),
 					Stream:               c.Bool("stream"),
+					Serve:                c.String("serve"),
+					Watch:                c.Bool("watch"),
+					ForceDeploy:          c.Bool("force_deploy"),
 				})
 				return err
 			},
@@ -22,3 +22,16 @@ func TestHelm(t *testing.T) {
 	})
 	assert.NoError(t, err)
 }
+
+func TestHelmRelease(t *testing.T) {
+	godotenv.Load("../.env")
+
+	err := warp.Hold(&warp.HoldConfig{
+		WorkingDir:   "../examples",
+		ConfigPath:   ".warprc.toml",
+		PipelinePath: "helm_release",
+		Tail:         true,
+		Run:          []string{"test"},
+	})
+	assert.NoError(t, err)
+}
@@ -19,6 +19,24 @@ type Batch struct {
 	// set elsewhere).  They can depend on each other, giving rise
 	// to an acyclic dependency graph.
 	Commands []BatchCommand `yaml:"commands"`
+
+	// Locks declares named locks that commands can take (see
+	// BatchCommand.Locks).  Declaring a lock is only necessary to
+	// give it a count other than one.
+	Locks []Lock `yaml:"locks,omitempty"`
+}
+
+// Lock is a named lock that can be held by a limited number
+// of commands at the same time.
+type Lock struct {
+	// Name is the name of the lock.  It is used to reference the
+	// lock in the batch commands.
+	Name string `yaml:"name" validate:"required,name"`
+
+	// Count is the maximum number of commands that can hold the lock
+	// at the same time.  It defaults to one, in which case the lock is
+	// a mutex.
+	Count int `yaml:"count,omitempty" validate:"min=0"`
 }
 
 // Pipeline defines a pipeline that the commands can use as
@@ -38,6 +56,13 @@ type Pipeline struct {
 	// Setup is the name of the setup to use.  Setups are defined
 	// in the pipeline config.
 	Setup string `yaml:"setup"`
+
+	// Paths is a list of glob patterns, relative to the workspace dir,
+	// matching files the pipeline depends on, in addition to the files
+	// that are automatically inferred from the pipeline definition
+	// (see pipelines.Pipeline.Sources).  It is used for change-based
+	// command selection (see Batch.FilterChanged).
+	Paths []string `yaml:"paths,omitempty"`
 }
 
 // BatchCommand is a command to execute in batch mode.
@@ -79,4 +104,39 @@ type BatchCommand struct {
 	// it fails intermittently.  Flaky tests are retried twice after they
 	// error.  Flakiness should be avoided by redesigning the test.
 	Flaky bool `yaml:"flaky"`
+
+	// Paths is a list of glob patterns, relative to the workspace dir,
+	// matching files the command depends on.  It is used for
+	// change-based command selection (see Batch.FilterChanged).  The
+	// "**" path component matches zero or more path components.
+	Paths []string `yaml:"paths,omitempty"`
+
+	// Locks is a slice of locks, referred to by name, that the
+	// command must hold while it executes.  Commands that share a
+	// lock do not execute concurrently, or, if the lock is declared
+	// with a count (see Batch.Locks), do not execute with more than
+	// this number of commands at the same time.  Locks that are not
+	// declared at the batch level are mutexes.
+	Locks []string `yaml:"locks,omitempty" validate:"name"`
+
+	// After is a slice of steps that are executed after the command,
+	// whether it succeeded or not, e.g. to download server-side coverage
+	// from the stacks.  A failing step fails the command.
+	After []AfterStep `yaml:"after,omitempty"`
+}
+
+// AfterStep is a step executed after a batch command.
+type AfterStep struct {
+	// Pipeline is the pipeline, referred to by name, whose stack the
+	// step is executed against.  The pipeline must be one of the
+	// pipelines of the batch command.
+	Pipeline string `yaml:"pipeline"`
+
+	// Copy downloads a file or a folder from the stack.  Only downloads
+	// are supported.  The local path is relative to the
+	// "files/<command path>" folder of the report folder, where the
+	// command path is the same as for the logs of the command.  It
+	// cannot be absolute or go up from this folder.  Steps are skipped
+	// when there is no report folder.
+	Copy *pipelines.CopyHook `yaml:"copy"`
 }
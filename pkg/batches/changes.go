@@ -0,0 +1,147 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package batches
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// FilterChanged removes from a Batch definition all the commands that are
+// not affected by a set of changed files.  The changed files, as well as
+// the paths, are given relative to the workspace dir.
+//
+// A command is affected when one of its paths (see BatchCommand.Paths)
+// matches a changed file, or when one of the paths of its pipelines matches
+// a changed file.  The paths of a pipeline are the paths given in the batch
+// definition (see Pipeline.Paths) and the paths given in pipelineSources,
+// keyed by pipeline name.  The commands for which no path is known are
+// conservatively always kept.
+//
+// The commands that the affected commands depend on are kept as well.
+func (batch *Batch) FilterChanged(changedFiles []string, pipelineSources map[string][]string) (*Batch, error) {
+	pipelinePaths := make(map[string][]string, len(batch.Pipelines))
+	for _, p := range batch.Pipelines {
+		pipelinePaths[p.Name] = append(append([]string(nil), p.Paths...), pipelineSources[p.Name]...)
+	}
+
+	commandsByName := make(map[string]*BatchCommand, len(batch.Commands))
+	for i := range batch.Commands {
+		commandsByName[batch.Commands[i].Name] = &batch.Commands[i]
+	}
+
+	selected := make(map[string]struct{})
+	var selectWithDependencies func(cmd *BatchCommand)
+	selectWithDependencies = func(cmd *BatchCommand) {
+		if _, ok := selected[cmd.Name]; ok {
+			return
+		}
+		selected[cmd.Name] = struct{}{}
+		for _, dep := range cmd.DependsOn {
+			if depCmd, ok := commandsByName[dep]; ok {
+				selectWithDependencies(depCmd)
+			}
+		}
+	}
+
+	for i := range batch.Commands {
+		cmd := &batch.Commands[i]
+		paths := append([]string(nil), cmd.Paths...)
+		for _, p := range cmd.Pipelines {
+			paths = append(paths, pipelinePaths[p]...)
+		}
+		if len(paths) == 0 {
+			selectWithDependencies(cmd)
+			continue
+		}
+		affected, err := anyPathMatches(paths, changedFiles)
+		if err != nil {
+			return nil, fmt.Errorf("command '%s': %v", cmd.Name, err)
+		}
+		if affected {
+			selectWithDependencies(cmd)
+		}
+	}
+
+	var commands []BatchCommand
+	for _, cmd := range batch.Commands {
+		if _, ok := selected[cmd.Name]; ok {
+			commands = append(commands, cmd)
+		}
+	}
+
+	return &Batch{
+		Pipelines: batch.Pipelines,
+		Commands:  commands,
+		Locks:     batch.Locks,
+	}, nil
+}
+
+func anyPathMatches(patterns []string, files []string) (bool, error) {
+	for _, pattern := range patterns {
+		for _, file := range files {
+			ok, err := MatchPath(pattern, file)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// MatchPath reports whether a file path matches a glob pattern.  The
+// pattern follows the syntax of path.Match, with the addition of the
+// "**" path component, which matches zero or more path components.  A
+// pattern that matches a folder matches all the files in this folder.
+func MatchPath(pattern, file string) (bool, error) {
+	patternParts := splitPath(pattern)
+	fileParts := splitPath(file)
+	for i := len(fileParts); i > 0; i-- {
+		ok, err := matchParts(patternParts, fileParts[:i])
+		if err != nil {
+			return false, fmt.Errorf("invalid path pattern '%s': %v", pattern, err)
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func splitPath(p string) []string {
+	p = path.Clean(filepath.ToSlash(p))
+	p = strings.TrimPrefix(p, "./")
+	if p == "." || p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+func matchParts(pattern, parts []string) (bool, error) {
+	if len(pattern) == 0 {
+		return len(parts) == 0, nil
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(parts); i++ {
+			ok, err := matchParts(pattern[1:], parts[i:])
+			if err != nil || ok {
+				return ok, err
+			}
+		}
+		return false, nil
+	}
+	if len(parts) == 0 {
+		return false, nil
+	}
+	ok, err := path.Match(pattern[0], parts[0])
+	if err != nil || !ok {
+		return false, err
+	}
+	return matchParts(pattern[1:], parts[1:])
+}
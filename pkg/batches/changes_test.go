@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package batches
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMatchPath(t *testing.T) {
+	cases := []struct {
+		pattern string
+		file    string
+		match   bool
+	}{
+		{"foo/bar.go", "foo/bar.go", true},
+		{"foo", "foo/bar/qux.go", true},
+		{"foo/", "foo/bar.go", true},
+		{"./foo", "foo/bar.go", true},
+		{"foo", "foobar/qux.go", false},
+		{"foo/*.go", "foo/bar.go", true},
+		{"foo/*.go", "foo/bar/qux.go", false},
+		{"**/*.go", "foo/bar/qux.go", true},
+		{"**/*.go", "qux.go", true},
+		{"foo/**/qux.go", "foo/qux.go", true},
+		{"foo/**/qux.go", "foo/a/b/qux.go", true},
+		{"foo/**/qux.go", "bar/a/qux.go", false},
+		{"docs/**", "docs/index.md", true},
+	}
+	for _, c := range cases {
+		ok, err := MatchPath(c.pattern, c.file)
+		assert.NoError(t, err)
+		assert.Equal(t, c.match, ok, "pattern '%s', file '%s'", c.pattern, c.file)
+	}
+
+	_, err := MatchPath("[", "foo")
+	assert.Error(t, err)
+}
+
+func TestFilterChanged(t *testing.T) {
+	batch := Batch{
+		Pipelines: []Pipeline{
+			{Name: "p", Paths: []string{"extra"}},
+		},
+		Commands: []BatchCommand{
+			{Name: "own", Paths: []string{"own/**"}},
+			{Name: "pipeline", Pipelines: []string{"p"}},
+			{Name: "dependency", Paths: []string{"unchanged"}},
+			{Name: "dependent", Paths: []string{"dependent"}, DependsOn: []string{"dependency"}},
+			{Name: "unaffected", Paths: []string{"unchanged"}},
+			{Name: "unknown"},
+		},
+	}
+
+	filtered, err := batch.FilterChanged(nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"unknown"}, commandNames(filtered))
+
+	filtered, err = batch.FilterChanged([]string{"own/a/b.go"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"own", "unknown"}, commandNames(filtered))
+
+	filtered, err = batch.FilterChanged([]string{"extra/file"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"pipeline", "unknown"}, commandNames(filtered))
+
+	filtered, err = batch.FilterChanged(
+		[]string{"kustomize/base/echo.yml"},
+		map[string][]string{"p": {"kustomize/base"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"pipeline", "unknown"}, commandNames(filtered))
+
+	filtered, err = batch.FilterChanged([]string{"dependent"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"dependency", "dependent", "unknown"}, commandNames(filtered))
+}
+
+func commandNames(batch *Batch) []string {
+	var names []string
+	for _, cmd := range batch.Commands {
+		names = append(names, cmd.Name)
+	}
+	return names
+}
@@ -39,5 +39,6 @@ func (batch *Batch) Filter(tagFilter string, focus string) (*Batch, error) {
 	return &Batch{
 		Pipelines: batch.Pipelines,
 		Commands:  commands,
+		Locks:     batch.Locks,
 	}, nil
 }
@@ -0,0 +1,52 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package batches
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/hchauvin/warp/pkg/config"
+	"github.com/hchauvin/warp/pkg/proc"
+	"strings"
+)
+
+// ChangedFiles uses git to list the files that changed since a given
+// git ref.  The list includes the uncommitted changes and the untracked
+// files.  The paths are given relative to the workspace dir, and
+// files outside of the workspace dir are omitted.
+func ChangedFiles(ctx context.Context, cfg *config.Config, ref string) ([]string, error) {
+	diff, err := git(ctx, cfg, "diff", "--name-only", "--relative", ref, "--")
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := git(ctx, cfg, "ls-files", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, err
+	}
+	return append(diff, untracked...), nil
+}
+
+func git(ctx context.Context, cfg *config.Config, args ...string) ([]string, error) {
+	gitPath, err := cfg.ToolPath(config.Git)
+	if err != nil {
+		return nil, err
+	}
+	cmd := proc.GracefulCommandContext(ctx, gitPath, args...)
+	cmd.Dir = cfg.WorkspaceDir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %v; stderr: <<< %s >>>", strings.Join(args, " "), err, stderr.String())
+	}
+	var files []string
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			files = append(files, line)
+		}
+	}
+	return files, nil
+}
@@ -37,6 +37,12 @@ func ReadFs(config *config.Config, path string, fs afero.Fs) (*Batch, error) {
 	if err := validate.Struct(batch); err != nil {
 		return nil, fmt.Errorf("%s: invalid batch config: %v", path, err)
 	}
+	if err := validateLocks(batch); err != nil {
+		return nil, fmt.Errorf("%s: invalid batch config: %v", path, err)
+	}
+	if err := validateAfterSteps(batch); err != nil {
+		return nil, fmt.Errorf("%s: invalid batch config: %v", path, err)
+	}
 
 	return batch, nil
 }
@@ -23,13 +23,60 @@ func TestRead(t *testing.T) {
 	assert.EqualValues(t, &batch, b)
 }
 
+func TestReadDuplicateLock(t *testing.T) {
+	cfg := &config.Config{WorkspaceDir: "/workspace"}
+
+	fs := afero.NewMemMapFs()
+	err := afero.WriteFile(fs, "/workspace/batch.yml", []byte(`
+locks:
+  - name: db
+  - name: db
+`), 0666)
+	assert.NoError(t, err)
+
+	_, err = ReadFs(cfg, "batch.yml", fs)
+	assert.EqualError(t, err, "batch.yml: invalid batch config: lock 'db' is declared more than once")
+}
+
+func TestReadLockCount(t *testing.T) {
+	cfg := &config.Config{WorkspaceDir: "/workspace"}
+
+	fs := afero.NewMemMapFs()
+	err := afero.WriteFile(fs, "/workspace/batch.yml", []byte(`
+locks:
+  - name: db
+    count: 0
+`), 0666)
+	assert.NoError(t, err)
+
+	// A zero count is the default count of one.
+	_, err = ReadFs(cfg, "batch.yml", fs)
+	assert.NoError(t, err)
+
+	err = afero.WriteFile(fs, "/workspace/batch.yml", []byte(`
+locks:
+  - name: db
+    count: -1
+`), 0666)
+	assert.NoError(t, err)
+
+	_, err = ReadFs(cfg, "batch.yml", fs)
+	assert.EqualError(t, err, "batch.yml: invalid batch config: lock 'db': count must not be negative")
+}
+
 var batchBytes = []byte(`
 pipelines:
   - name: foo
     path: bar
     setup: setup
+    paths: ['foo/**']
 commands:
   - name: cmd
+    paths: ['cmd/*.go']
+    locks: [db]
+locks:
+  - name: db
+    count: 2
 `)
 
 var batch = Batch{
@@ -38,11 +85,53 @@ var batch = Batch{
 			Name:  "foo",
 			Path:  "bar",
 			Setup: "setup",
+			Paths: []string{"foo/**"},
 		},
 	},
 	Commands: []BatchCommand{
 		{
-			Name: "cmd",
+			Name:  "cmd",
+			Paths: []string{"cmd/*.go"},
+			Locks: []string{"db"},
 		},
 	},
+	Locks: []Lock{
+		{Name: "db", Count: 2},
+	},
+}
+
+func TestReadAfterSteps(t *testing.T) {
+	cfg := &config.Config{WorkspaceDir: "/workspace"}
+
+	for _, tc := range []struct {
+		after string
+		err   string
+	}{
+		{"pipeline: foo\n      copy: {direction: download, serviceSelector: api, local: cover, remote: /cover}", ""},
+		{"pipeline: bar\n      copy: {direction: download, serviceSelector: api, local: cover, remote: /cover}", "pipeline 'bar' is not a pipeline of the command"},
+		{"pipeline: foo", "expected a copy"},
+		{"pipeline: foo\n      copy: {direction: upload, serviceSelector: api, local: cover, remote: /cover}", "only downloads are supported"},
+		{"pipeline: foo\n      copy: {direction: download, serviceSelector: api, local: ../cover, remote: /cover}", "the local path '../cover' must be inside the report folder"},
+		{"pipeline: foo\n      copy: {direction: download, serviceSelector: api, local: .., remote: /cover}", "the local path '..' must be inside the report folder"},
+		{"pipeline: foo\n      copy: {direction: download, serviceSelector: api, local: cover/../../cover, remote: /cover}", "the local path 'cover/../../cover' must be inside the report folder"},
+		{"pipeline: foo\n      copy: {direction: download, serviceSelector: api, local: /tmp/cover, remote: /cover}", "the local path '/tmp/cover' must be inside the report folder"},
+		{"pipeline: foo\n      copy: {direction: download, serviceSelector: api, local: cover/../other, remote: /cover}", ""},
+	} {
+		fs := afero.NewMemMapFs()
+		err := afero.WriteFile(fs, "/workspace/batch.yml", []byte(`
+commands:
+  - name: cmd
+    pipelines: [foo]
+    after:
+    - `+tc.after+`
+`), 0666)
+		assert.NoError(t, err)
+
+		_, err = ReadFs(cfg, "batch.yml", fs)
+		if tc.err == "" {
+			assert.NoError(t, err)
+		} else {
+			assert.EqualError(t, err, "batch.yml: invalid batch config: command 'cmd': after[0]: "+tc.err)
+		}
+	}
 }
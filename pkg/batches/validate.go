@@ -4,7 +4,11 @@
 package batches
 
 import (
+	"fmt"
 	"github.com/go-playground/validator"
+	"github.com/hchauvin/warp/pkg/pipelines"
+	"path/filepath"
+	"strings"
 )
 
 var validate *validator.Validate
@@ -12,3 +16,59 @@ var validate *validator.Validate
 func init() {
 	validate = validator.New()
 }
+
+func validateLocks(batch *Batch) error {
+	declared := make(map[string]struct{}, len(batch.Locks))
+	for _, lock := range batch.Locks {
+		if lock.Name == "" {
+			return fmt.Errorf("lock with no name")
+		}
+		if _, ok := declared[lock.Name]; ok {
+			return fmt.Errorf("lock '%s' is declared more than once", lock.Name)
+		}
+		if lock.Count < 0 {
+			return fmt.Errorf("lock '%s': count must not be negative", lock.Name)
+		}
+		declared[lock.Name] = struct{}{}
+	}
+	return nil
+}
+
+func validateAfterSteps(batch *Batch) error {
+	for _, cmd := range batch.Commands {
+		for i, step := range cmd.After {
+			if err := validateAfterStep(&cmd, &step); err != nil {
+				return fmt.Errorf("command '%s': after[%d]: %v", cmd.Name, i, err)
+			}
+		}
+	}
+	return nil
+}
+
+func validateAfterStep(cmd *BatchCommand, step *AfterStep) error {
+	found := false
+	for _, p := range cmd.Pipelines {
+		if p == step.Pipeline {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("pipeline '%s' is not a pipeline of the command", step.Pipeline)
+	}
+
+	if step.Copy == nil {
+		return fmt.Errorf("expected a copy")
+	}
+	if err := validate.Struct(step.Copy); err != nil {
+		return err
+	}
+	if step.Copy.Direction != pipelines.Download {
+		return fmt.Errorf("only downloads are supported")
+	}
+	local := filepath.ToSlash(filepath.Clean(step.Copy.Local))
+	if filepath.IsAbs(step.Copy.Local) || local == ".." || strings.HasPrefix(local, "../") {
+		return fmt.Errorf("the local path '%s' must be inside the report folder", step.Copy.Local)
+	}
+	return nil
+}
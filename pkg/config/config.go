@@ -37,12 +37,26 @@ type Config struct {
 	// Telemetry configures telemetry.
 	Telemetry Telemetry
 
+	// ContainerBuilder is the tool used to build, tag, and push container
+	// images: Docker (the default), Podman, or Buildah.  Podman and
+	// Buildah do not require a daemon, and can be used rootless.
+	ContainerBuilder Tool `validate:"omitempty,oneof=Docker Podman Buildah"`
+
+	// LocalCluster configures the local Kubernetes cluster into which
+	// container images are loaded when the container step is in "load"
+	// mode (see pipelines.Container.Load).
+	LocalCluster *LocalCluster
+
 	// WorkspaceDir is the workspace directory.
 	WorkspaceDir string `toml:"-"`
 
 	// RunID is a random ID specific to this run of "warp".
 	RunID string `toml:"-"`
 
+	// ForceDeploy forces the resources of the stacks to be applied even
+	// when they did not change since they were last applied.
+	ForceDeploy bool `toml:"-"`
+
 	logger log.Logger
 }
 
@@ -70,6 +84,8 @@ type Tool string
 
 // Names of the tools used by warp.
 const (
+	// Kustomize is not used anymore, as the kustomizations are built
+	// in-process.  It is kept for backward compatibility.
 	Kustomize   = Tool("Kustomize")
 	Helm        = Tool("Helm")
 	KubeScore   = Tool("KubeScore")
@@ -77,10 +93,16 @@ const (
 	Ksync       = Tool("Ksync")
 	BrowserSync = Tool("BrowserSync")
 	Docker      = Tool("Docker")
+	Git         = Tool("Git")
+	Podman      = Tool("Podman")
+	Buildah     = Tool("Buildah")
+	Kind        = Tool("Kind")
+	K3d         = Tool("K3d")
+	Minikube    = Tool("Minikube")
 )
 
 // ToolNames gives all the required tools.
-var ToolNames = []Tool{Kustomize, Helm, KubeScore, Kubectl, Ksync, BrowserSync, Docker}
+var ToolNames = []Tool{Kustomize, Helm, KubeScore, Kubectl, Ksync, BrowserSync, Docker, Git, Podman, Buildah, Kind, K3d, Minikube}
 
 // LogDomain gives the log domain for a tool.
 func (tool Tool) LogDomain() string {
@@ -101,6 +123,12 @@ var toolDefaultPaths = map[Tool]string{
 	Ksync:       "ksync",
 	BrowserSync: "browser-sync",
 	Docker:      "docker",
+	Git:         "git",
+	Podman:      "podman",
+	Buildah:     "buildah",
+	Kind:        "kind",
+	K3d:         "k3d",
+	Minikube:    "minikube",
 }
 
 // Kubernetes holds the configuration for a Kubernetes
@@ -131,6 +159,18 @@ type Kubernetes struct {
 	KubeconfigEnvVar string `toml:"-"`
 }
 
+// LocalCluster configures a local Kubernetes cluster, managed with
+// kind, k3d, or minikube.
+type LocalCluster struct {
+	// Flavor is the tool used to manage the cluster: Kind, K3d, or
+	// Minikube.
+	Flavor Tool `validate:"oneof=Kind K3d Minikube"`
+
+	// Name is the name of the cluster (for minikube, the name of the
+	// profile).  If omitted, the default cluster of the tool is used.
+	Name string
+}
+
 // Resource identifies custom resources by group, version, and kind.
 type Resource struct {
 	Group    string
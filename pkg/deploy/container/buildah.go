@@ -0,0 +1,132 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package container
+
+import (
+	"context"
+	"fmt"
+	"github.com/hchauvin/warp/pkg/config"
+	"github.com/hchauvin/warp/pkg/proc"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+// buildah is a Builder that uses Buildah.
+type buildah struct {
+	cfg  *config.Config
+	path string
+	// digests are the digests of the images pushed with Push, by image
+	// reference.
+	digests    map[string]string
+	digestsMut sync.Mutex
+}
+
+func (b *buildah) Build(ctx context.Context, opts *BuildOptions) (string, error) {
+	return withIIDFile(func(iidPath string) error {
+		args := append([]string{"bud"}, opts.args(iidPath)...)
+		cmd := proc.GracefulCommandContext(ctx, b.path, args...)
+		b.cfg.Logger().Pipe("container.build", cmd)
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("could not build image with Buildah: %v", err)
+		}
+		return nil
+	})
+}
+
+func (b *buildah) Tag(ctx context.Context, ref string, nextRef string) error {
+	cmd := proc.GracefulCommandContext(ctx, b.path, "tag", ref, nextRef)
+	b.cfg.Logger().Pipe("container.tag", cmd)
+	return cmd.Run()
+}
+
+func (b *buildah) Push(ctx context.Context, ref string) error {
+	digest, err := withDigestFile(func(digestPath string) error {
+		cmd := proc.GracefulCommandContext(ctx, b.path, "push", "--digestfile", digestPath, ref, "docker://"+ref)
+		b.cfg.Logger().Pipe("container.push", cmd)
+		return cmd.Run()
+	})
+	if err != nil {
+		return err
+	}
+	b.digestsMut.Lock()
+	defer b.digestsMut.Unlock()
+	if b.digests == nil {
+		b.digests = make(map[string]string)
+	}
+	b.digests[ref] = digest
+	return nil
+}
+
+func (b *buildah) Pull(ctx context.Context, ref string) error {
+	cmd := proc.GracefulCommandContext(ctx, b.path, "pull", ref)
+	b.cfg.Logger().Pipe("container.pull", cmd)
+	return cmd.Run()
+}
+
+func (b *buildah) Save(ctx context.Context, ref string, archivePath string) error {
+	cmd := proc.GracefulCommandContext(ctx, b.path, "push", ref, "docker-archive:"+archivePath+":"+ref)
+	b.cfg.Logger().Pipe("container.save", cmd)
+	return cmd.Run()
+}
+
+// Digest gives the digest of an image.  For the images pushed with
+// Push, this is the digest of the manifest that was pushed, as given by
+// "buildah push --digestfile".  Buildah can compress layers and convert
+// manifests on push, so that the digest of the local image is not the
+// digest in the registry.  For the images that were only pulled, the
+// local manifest is the manifest in the registry.
+func (b *buildah) Digest(ctx context.Context, ref string) (string, error) {
+	b.digestsMut.Lock()
+	digest, ok := b.digests[ref]
+	b.digestsMut.Unlock()
+	if ok {
+		return digest, nil
+	}
+
+	out, err := proc.GracefulCommandContext(
+		ctx,
+		b.path,
+		"images",
+		"--format", "{{ .Digest }}",
+		ref,
+	).Output()
+	if err != nil {
+		return "", fmt.Errorf("could not inspect image '%s' with Buildah: %v", ref, err)
+	}
+	digests := strings.Fields(string(out))
+	if len(digests) == 0 {
+		return "", fmt.Errorf("image '%s' has no digest", ref)
+	}
+	return digests[0], nil
+}
+
+// withDigestFile calls a function with the path to a temporary file,
+// then gives the digest written to this file by the function.
+func withDigestFile(f func(digestPath string) error) (string, error) {
+	digestFile, err := ioutil.TempFile("", "warp-digest")
+	if err != nil {
+		return "", err
+	}
+	digestPath := digestFile.Name()
+	defer os.Remove(digestPath)
+	if err := digestFile.Close(); err != nil {
+		return "", err
+	}
+
+	if err := f(digestPath); err != nil {
+		return "", err
+	}
+
+	b, err := ioutil.ReadFile(digestPath)
+	if err != nil {
+		return "", err
+	}
+	digest := strings.TrimSpace(string(b))
+	if digest == "" {
+		return "", fmt.Errorf("could not find the digest")
+	}
+	return digest, nil
+}
@@ -0,0 +1,147 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package container
+
+import (
+	"context"
+	"fmt"
+	"github.com/hchauvin/warp/pkg/config"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Builder builds, tags, pushes, pulls, and saves container images.
+type Builder interface {
+	// Build builds a container image, and gives the ID of the image.
+	Build(ctx context.Context, opts *BuildOptions) (imageID string, err error)
+
+	// Tag tags a container image.
+	Tag(ctx context.Context, ref string, nextRef string) error
+
+	// Push pushes a container image to a container registry.
+	Push(ctx context.Context, ref string) error
+
+	// Pull pulls a container image from a container registry.
+	Pull(ctx context.Context, ref string) error
+
+	// Save saves a container image to a Docker archive.
+	Save(ctx context.Context, ref string, archivePath string) error
+
+	// Digest gives the digest ("sha256:...") of a container image
+	// that was pushed to, or pulled from, a container registry.
+	Digest(ctx context.Context, ref string) (string, error)
+}
+
+// BuildOptions are options for Builder.Build.
+type BuildOptions struct {
+	// Context is the path to the build context.
+	Context string
+
+	// Dockerfile is the path to the Dockerfile.
+	Dockerfile string
+
+	// Target is the build stage to build, if any.
+	Target string
+
+	// Args are build arguments.
+	Args map[string]string
+
+	// Labels are labels to add to the image.
+	Labels map[string]string
+}
+
+// NewBuilder creates the Builder configured by config.Config.ContainerBuilder.
+func NewBuilder(cfg *config.Config) (Builder, error) {
+	tool := cfg.ContainerBuilder
+	if tool == "" {
+		tool = config.Docker
+	}
+
+	path, err := cfg.ToolPath(tool)
+	if err != nil {
+		return nil, err
+	}
+
+	switch tool {
+	case config.Docker, config.Podman:
+		return &dockerCLI{cfg: cfg, tool: tool, path: path}, nil
+	case config.Buildah:
+		return &buildah{cfg: cfg, path: path}, nil
+	default:
+		return nil, fmt.Errorf("unsupported container builder '%s'", tool)
+	}
+}
+
+// args gives the command-line arguments, common to Docker, Podman,
+// and Buildah, for a build.  The ID of the image is written to
+// iidPath.
+func (opts *BuildOptions) args(iidPath string) []string {
+	args := []string{"--iidfile", iidPath, "--file", opts.Dockerfile}
+	if opts.Target != "" {
+		args = append(args, "--target", opts.Target)
+	}
+	for _, k := range sortedKeys(opts.Args) {
+		args = append(args, "--build-arg", k+"="+opts.Args[k])
+	}
+	for _, k := range sortedKeys(opts.Labels) {
+		args = append(args, "--label", k+"="+opts.Labels[k])
+	}
+	return append(args, opts.Context)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// withIIDFile calls a function with the path to a temporary file, then
+// gives the image ID written to this file by the function.
+func withIIDFile(f func(iidPath string) error) (imageID string, err error) {
+	iidFile, err := ioutil.TempFile("", "warp-iid")
+	if err != nil {
+		return "", err
+	}
+	iidPath := iidFile.Name()
+	defer os.Remove(iidPath)
+	if err := iidFile.Close(); err != nil {
+		return "", err
+	}
+
+	if err := f(iidPath); err != nil {
+		return "", err
+	}
+
+	b, err := ioutil.ReadFile(iidPath)
+	if err != nil {
+		return "", err
+	}
+	imageID = strings.TrimSpace(string(b))
+	if imageID == "" {
+		return "", fmt.Errorf("could not find the image ID")
+	}
+	return imageID, nil
+}
+
+// repoDigest finds, among the repository digests of an image
+// ("<name>@sha256:..."), the digest for the repository of an image
+// reference.
+func repoDigest(ref string, repoDigests []string) (string, error) {
+	name, _, err := SplitImageRef(ref)
+	if err != nil {
+		return "", err
+	}
+	for _, repoDigest := range repoDigests {
+		parts := strings.SplitN(repoDigest, "@", 2)
+		if len(parts) == 2 && parts[0] == name {
+			return parts[1], nil
+		}
+	}
+	return "", fmt.Errorf("image '%s' has no digest for repository '%s'; was it pushed?", ref, name)
+}
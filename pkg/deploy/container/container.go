@@ -11,7 +11,9 @@ import (
 	"github.com/hchauvin/warp/pkg/pipelines"
 	"github.com/hchauvin/warp/pkg/stacks/names"
 	"golang.org/x/sync/errgroup"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 )
@@ -20,19 +22,102 @@ import (
 // that points to an image on a container registry.
 type ImageRefs map[string]string
 
-// Exec executes the deployment operations addressing the containers themselves.
-func Exec(ctx context.Context, cfg *config.Config, pipeline *pipelines.Pipeline, name names.Name) (ImageRefs, error) {
-	manifest := pipeline.Deploy.Container.ParsedManifest
+// SplitImageRef splits an image reference into an image name and
+// a tag.  The tag defaults to "latest".  The digest of image references
+// of the form "name[:tag]@digest" is ignored.
+func SplitImageRef(ref string) (name string, tag string, err error) {
+	name, tag, _, err = parseImageRef(ref)
+	if err != nil {
+		return "", "", err
+	}
+	if tag == "" {
+		tag = "latest"
+	}
+	return name, tag, nil
+}
+
+// SplitImageDigest splits an image reference of the form
+// "name[:tag]@digest" into an image name and a digest.  ok is false if
+// the image reference has no digest.
+func SplitImageDigest(ref string) (name string, digest string, ok bool) {
+	name, _, digest, err := parseImageRef(ref)
+	if err != nil || digest == "" {
+		return "", "", false
+	}
+	return name, digest, true
+}
+
+// parseImageRef parses an image reference of the form
+// "name[:tag][@digest]".  The tag and the digest are empty when they
+// are not given.  The name can contain a registry host with a port,
+// e.g., "localhost:5000/api", so the tag is only looked for after the
+// last "/".
+func parseImageRef(ref string) (name string, tag string, digest string, err error) {
+	name = ref
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, digest = name[:i], name[i+1:]
+		if digest == "" {
+			return "", "", "", fmt.Errorf("invalid image ref '%s'", ref)
+		}
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, tag = name[:i], name[i+1:]
+		if tag == "" {
+			return "", "", "", fmt.Errorf("invalid image ref '%s'", ref)
+		}
+	}
+	if name == "" || strings.LastIndex(name, ":") > strings.LastIndex(name, "/") || strings.Contains(digest, "@") {
+		return "", "", "", fmt.Errorf("invalid image ref '%s'", ref)
+	}
+	return name, tag, digest, nil
+}
+
+// Exec executes the deployment operations addressing the containers
+// themselves: the images are built, labeled, and pushed (or loaded into
+// a local cluster), in parallel.
+func Exec(ctx context.Context, cfg *config.Config, c *pipelines.Container, name names.Name) (ImageRefs, error) {
+	return execContainer(ctx, cfg, c, name, true)
+}
+
+// Build builds and labels the images like Exec, but neither pushes them
+// nor loads them into a local cluster.  The references are the ones Exec
+// gives, except that they are not resolved to digests, as this requires
+// the images to be pushed.
+func Build(ctx context.Context, cfg *config.Config, c *pipelines.Container, name names.Name) (ImageRefs, error) {
+	return execContainer(ctx, cfg, c, name, false)
+}
+
+// execContainer implements Exec and Build.  The images are only pushed,
+// loaded, and resolved to digests when push is true.
+func execContainer(ctx context.Context, cfg *config.Config, c *pipelines.Container, name names.Name, push bool) (ImageRefs, error) {
+	manifest := c.ParsedManifest
 	if manifest == nil {
 		return nil, nil
 	}
 
-	dockerPath, err := cfg.ToolPath(config.Docker)
+	if c.Load && c.Push != "" {
+		return nil, fmt.Errorf("images cannot be both loaded into a local cluster and pushed")
+	}
+	if c.Load && c.Digest {
+		return nil, fmt.Errorf("images loaded into a local cluster cannot be resolved to digests")
+	}
+
+	builder, err := NewBuilder(cfg)
 	if err != nil {
 		return nil, err
 	}
-	dk := docker{
-		path: dockerPath,
+
+	var ld *loader
+	if c.Load && push {
+		ld, err = newLoader(cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	labels, err := expandLabels(c.Label)
+	if err != nil {
+		return nil, err
 	}
 
 	refs := make(map[string]string, len(manifest))
@@ -45,47 +130,86 @@ func Exec(ctx context.Context, cfg *config.Config, pipeline *pipelines.Pipeline,
 			if ref == "" {
 				return nil
 			}
-			if len(pipeline.Deploy.Container.Label) > 0 {
-				expandedLabels := make(map[string]string, len(pipeline.Deploy.Container.Label))
-				for _, lbl := range pipeline.Deploy.Container.Label {
-					parts := strings.SplitN(lbl, "=", 2)
-					if len(parts) != 2 {
-						return fmt.Errorf("unexpected label spec '%s'", lbl)
+			// Images are built either from a Dockerfile, or from the
+			// image reference, to add labels.
+			var imageID string
+			var err error
+			if v.Build != nil {
+				imageID, err = builder.Build(gctx, buildOptions(cfg, v.Build, labels))
+			} else if len(labels) > 0 {
+				imageID, err = buildFrom(gctx, builder, ref, labels)
+			}
+			if err != nil {
+				return fmt.Errorf("image '%s': %v", k, err)
+			}
+
+			if c.Load {
+				// All the images are tagged with a content hash, even
+				// when they are not built, so that they are never tagged
+				// "latest" and not pulled by Kubernetes.
+				if imageID == "" {
+					imageID, err = buildFrom(gctx, builder, ref, nil)
+					if err != nil {
+						return fmt.Errorf("image '%s': %v", k, err)
 					}
-					expandedLabels[parts[0]] = os.ExpandEnv(parts[1])
 				}
-				nextRef, err := dk.build(gctx, cfg, ref, expandedLabels)
-				if err != nil {
+				ref = contentRef(v.Ref, imageID)
+				if err := builder.Tag(gctx, imageID, ref); err != nil {
 					return err
 				}
-				if pipeline.Deploy.Container.Push == "" {
-					parts := strings.SplitN(ref, ":", 2)
-					ref = parts[0] + ":" + strings.Replace(nextRef, "sha256:", "", 1)
-					if err := dk.tag(gctx, cfg, nextRef, ref); err != nil {
-						return err
+				if ld != nil {
+					if err := ld.load(gctx, builder, ref); err != nil {
+						return fmt.Errorf("image '%s': %v", k, err)
 					}
-					if err := dk.push(gctx, cfg, ref); err != nil {
+				}
+			} else if imageID != "" && c.Push == "" {
+				ref = contentRef(v.Ref, imageID)
+				if err := builder.Tag(gctx, imageID, ref); err != nil {
+					return err
+				}
+				// Images built from a Dockerfile are only pushed when
+				// c.Push is given.
+				if v.Build == nil && push {
+					if err := builder.Push(gctx, ref); err != nil {
 						return err
 					}
 				}
 			}
-			if pipeline.Deploy.Container.Push != "" {
-				parts := strings.SplitN(ref, "/", 2)
-				registry := os.ExpandEnv(pipeline.Deploy.Container.Push)
-				var nextRef string
-				if len(parts) == 2 {
-					nextRef = registry + "/" + parts[1]
-				} else {
-					nextRef = registry + "/" + ref
+			if c.Push != "" {
+				registry := os.ExpandEnv(c.Push)
+				nextRef := pushRef(registry, v.Ref)
+				source := v.Ref
+				if imageID != "" {
+					nextRef = contentRef(nextRef, imageID)
+					source = imageID
 				}
-				if err := dk.tag(gctx, cfg, ref, nextRef); err != nil {
+				if err := builder.Tag(gctx, source, nextRef); err != nil {
 					return err
 				}
-				if err := dk.push(gctx, cfg, nextRef); err != nil {
-					return err
+				if push {
+					if err := builder.Push(gctx, nextRef); err != nil {
+						return err
+					}
 				}
 				ref = nextRef
 			}
+			if c.Digest && push {
+				// The images that are neither built nor pushed might
+				// not be available locally.
+				if ref == v.Ref {
+					if err := builder.Pull(gctx, ref); err != nil {
+						return fmt.Errorf("image '%s': %v", k, err)
+					}
+				}
+				digest, err := builder.Digest(gctx, ref)
+				if err != nil {
+					return fmt.Errorf("image '%s': %v", k, err)
+				}
+				ref, err = digestRef(ref, digest)
+				if err != nil {
+					return fmt.Errorf("image '%s': %v", k, err)
+				}
+			}
 			mut.Lock()
 			refs[k] = ref
 			mut.Unlock()
@@ -99,3 +223,104 @@ func Exec(ctx context.Context, cfg *config.Config, pipeline *pipelines.Pipeline,
 
 	return refs, nil
 }
+
+// expandLabels parses labels, specified as "name=value" strings.  The
+// values are subject to environment variable expansion.
+func expandLabels(labels []string) (map[string]string, error) {
+	if len(labels) == 0 {
+		return nil, nil
+	}
+	expanded := make(map[string]string, len(labels))
+	for _, lbl := range labels {
+		parts := strings.SplitN(lbl, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("unexpected label spec '%s'", lbl)
+		}
+		expanded[parts[0]] = os.ExpandEnv(parts[1])
+	}
+	return expanded, nil
+}
+
+// buildOptions gives the options to build an image from a Dockerfile.
+func buildOptions(cfg *config.Config, build *pipelines.ContainerBuild, labels map[string]string) *BuildOptions {
+	dockerfile := build.Dockerfile
+	if dockerfile == "" {
+		dockerfile = "Dockerfile"
+	}
+	var args map[string]string
+	if len(build.Args) > 0 {
+		args = make(map[string]string, len(build.Args))
+		for k, v := range build.Args {
+			args[k] = os.ExpandEnv(v)
+		}
+	}
+	return &BuildOptions{
+		Context:    cfg.Path(build.Context),
+		Dockerfile: cfg.Path(filepath.Join(build.Context, dockerfile)),
+		Target:     build.Target,
+		Args:       args,
+		Labels:     labels,
+	}
+}
+
+// buildFrom builds an image that adds labels to another image.
+func buildFrom(ctx context.Context, builder Builder, ref string, labels map[string]string) (string, error) {
+	dir, err := ioutil.TempDir("", "warp-build")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	dockerfile := filepath.Join(dir, "Dockerfile")
+	if err := ioutil.WriteFile(dockerfile, []byte("FROM "+ref+"\n"), 0666); err != nil {
+		return "", err
+	}
+	return builder.Build(ctx, &BuildOptions{
+		Context:    dir,
+		Dockerfile: dockerfile,
+		Labels:     labels,
+	})
+}
+
+// contentRef replaces the tag and the digest of an image reference
+// with the content hash given by an image ID.
+func contentRef(ref string, imageID string) string {
+	name, _, _, err := parseImageRef(ref)
+	if err != nil {
+		name = ref
+	}
+	return name + ":" + strings.TrimPrefix(imageID, "sha256:")
+}
+
+// digestRef replaces the tag and the digest of an image reference with
+// a digest.
+func digestRef(ref string, digest string) (string, error) {
+	name, _, _, err := parseImageRef(ref)
+	if err != nil {
+		return "", err
+	}
+	return name + "@" + digest, nil
+}
+
+// pushRef gives the reference of an image in an alternative registry.
+// The image is pushed with a tag, so the digest of the reference is
+// dropped; when the reference has no tag, the digest is used as a tag.
+func pushRef(registry string, ref string) string {
+	if name, tag, digest, err := parseImageRef(ref); err == nil && digest != "" {
+		if tag == "" {
+			tag = digestTag(digest)
+		}
+		ref = name + ":" + tag
+	}
+	parts := strings.SplitN(ref, "/", 2)
+	if len(parts) == 2 {
+		return registry + "/" + parts[1]
+	}
+	return registry + "/" + ref
+}
+
+// digestTag gives a tag for a digest, e.g., "0123" for "sha256:0123".
+func digestTag(digest string) string {
+	parts := strings.SplitN(digest, ":", 2)
+	return parts[len(parts)-1]
+}
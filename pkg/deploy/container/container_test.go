@@ -0,0 +1,452 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package container
+
+import (
+	"context"
+	"github.com/hchauvin/warp/pkg/config"
+	"github.com/hchauvin/warp/pkg/pipelines"
+	"github.com/hchauvin/warp/pkg/stacks/names"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestBuildOptions(t *testing.T) {
+	cfg := &config.Config{WorkspaceDir: "/workspace"}
+	os.Setenv("WARP_TEST_VERSION", "1.0")
+	defer os.Unsetenv("WARP_TEST_VERSION")
+
+	opts := buildOptions(cfg, &pipelines.ContainerBuild{
+		Context: "api",
+		Args: map[string]string{
+			"VERSION": "$WARP_TEST_VERSION",
+			"BASE":    "alpine",
+		},
+		Target: "release",
+	}, map[string]string{"a": "b"})
+	assert.Equal(t, []string{
+		"--iidfile", "iid",
+		"--file", "/workspace/api/Dockerfile",
+		"--target", "release",
+		"--build-arg", "BASE=alpine",
+		"--build-arg", "VERSION=1.0",
+		"--label", "a=b",
+		"/workspace/api",
+	}, opts.args("iid"))
+
+	opts = buildOptions(cfg, &pipelines.ContainerBuild{
+		Context:    "api",
+		Dockerfile: "build/Dockerfile.prod",
+	}, nil)
+	assert.Equal(t, []string{
+		"--iidfile", "iid",
+		"--file", "/workspace/api/build/Dockerfile.prod",
+		"/workspace/api",
+	}, opts.args("iid"))
+}
+
+func TestExpandLabels(t *testing.T) {
+	labels, err := expandLabels([]string{"a=b", "c=d=e"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "b", "c": "d=e"}, labels)
+
+	_, err = expandLabels([]string{"invalid"})
+	assert.Error(t, err)
+}
+
+func TestNewBuilder(t *testing.T) {
+	cfg := &config.Config{
+		Tools: map[config.Tool]config.ToolInfo{
+			config.Docker:  {Path: "/bin/docker"},
+			config.Podman:  {Path: "/bin/podman"},
+			config.Buildah: {Path: "/bin/buildah"},
+		},
+	}
+
+	builder, err := NewBuilder(cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, &dockerCLI{cfg: cfg, tool: config.Docker, path: "/bin/docker"}, builder)
+
+	cfg.ContainerBuilder = config.Podman
+	builder, err = NewBuilder(cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, &dockerCLI{cfg: cfg, tool: config.Podman, path: "/bin/podman"}, builder)
+
+	cfg.ContainerBuilder = config.Buildah
+	builder, err = NewBuilder(cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, &buildah{cfg: cfg, path: "/bin/buildah"}, builder)
+}
+
+func TestRepoDigest(t *testing.T) {
+	repoDigests := []string{
+		"registry/api@sha256:0123",
+		"other/api@sha256:4567",
+	}
+	digest, err := repoDigest("other/api:1.0", repoDigests)
+	assert.NoError(t, err)
+	assert.Equal(t, "sha256:4567", digest)
+
+	_, err = repoDigest("api:1.0", repoDigests)
+	assert.Error(t, err)
+}
+
+func TestContentRef(t *testing.T) {
+	assert.Equal(t, "api:0123", contentRef("api", "sha256:0123"))
+	assert.Equal(t, "registry/api:0123", contentRef("registry/api:dev", "sha256:0123"))
+	assert.Equal(t, "localhost:5000/api:0123", contentRef("localhost:5000/api", "sha256:0123"))
+	assert.Equal(t, "registry/api:0123", contentRef("registry/api:dev@sha256:4567", "sha256:0123"))
+}
+
+func TestSplitImageRef(t *testing.T) {
+	for ref, expected := range map[string][2]string{
+		"api":                               {"api", "latest"},
+		"registry/api:1.0":                  {"registry/api", "1.0"},
+		"localhost:5000/api":                {"localhost:5000/api", "latest"},
+		"localhost:5000/api:1.0":            {"localhost:5000/api", "1.0"},
+		"registry/api@sha256:0123":          {"registry/api", "latest"},
+		"localhost:5000/api:1.0@sha256:012": {"localhost:5000/api", "1.0"},
+	} {
+		name, tag, err := SplitImageRef(ref)
+		assert.NoError(t, err, ref)
+		assert.Equal(t, expected, [2]string{name, tag}, ref)
+	}
+
+	for _, ref := range []string{"", "a:b:c", "api:", "api@", "api@sha256:0@1"} {
+		_, _, err := SplitImageRef(ref)
+		assert.EqualError(t, err, "invalid image ref '"+ref+"'")
+	}
+}
+
+func TestSplitImageDigest(t *testing.T) {
+	name, digest, ok := SplitImageDigest("registry/api@sha256:0123")
+	assert.True(t, ok)
+	assert.Equal(t, "registry/api", name)
+	assert.Equal(t, "sha256:0123", digest)
+
+	name, digest, ok = SplitImageDigest("localhost:5000/api:1.0@sha256:0123")
+	assert.True(t, ok)
+	assert.Equal(t, "localhost:5000/api", name)
+	assert.Equal(t, "sha256:0123", digest)
+
+	_, _, ok = SplitImageDigest("registry/api:1.0")
+	assert.False(t, ok)
+}
+
+func TestDigestRef(t *testing.T) {
+	ref, err := digestRef("registry/api:1.0", "sha256:0123")
+	assert.NoError(t, err)
+	assert.Equal(t, "registry/api@sha256:0123", ref)
+
+	ref, err = digestRef("localhost:5000/api:1.0@sha256:4567", "sha256:0123")
+	assert.NoError(t, err)
+	assert.Equal(t, "localhost:5000/api@sha256:0123", ref)
+}
+
+func TestPushRef(t *testing.T) {
+	assert.Equal(t, "prod/api", pushRef("prod", "api"))
+	assert.Equal(t, "prod/api:1.0", pushRef("prod", "registry/api:1.0"))
+	assert.Equal(t, "prod/api:1.0", pushRef("prod", "registry/api:1.0@sha256:0123"))
+	assert.Equal(t, "prod/api:0123", pushRef("prod", "registry/api@sha256:0123"))
+}
+
+func TestExecBuild(t *testing.T) {
+	dir, err := ioutil.TempDir("", "container")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	dockerPath, err := filepath.Abs("testdata/docker.sh")
+	assert.NoError(t, err)
+	logPath := filepath.Join(dir, "log")
+	os.Setenv("DOCKER_LOG", logPath)
+	defer os.Unsetenv("DOCKER_LOG")
+
+	cfg := &config.Config{
+		WorkspaceDir: dir,
+		Tools:        map[config.Tool]config.ToolInfo{config.Docker: {Path: dockerPath}},
+	}
+	c := &pipelines.Container{
+		ParsedManifest: pipelines.ContainerManifest{
+			"api": {Ref: "api", Build: &pipelines.ContainerBuild{Context: "api"}},
+			"db":  {Ref: "registry/db:1.0"},
+		},
+	}
+
+	refs, err := Exec(context.Background(), cfg, c, names.Name{ShortName: "stack"})
+	assert.NoError(t, err)
+	assert.Equal(t, ImageRefs{"api": "api:0123abcd", "db": "registry/db:1.0"}, refs)
+	assert.Equal(t, []string{
+		"build --iidfile",
+		"tag sha256:0123abcd api:0123abcd",
+	}, readDockerLog(t, logPath))
+
+	assert.NoError(t, os.Remove(logPath))
+	c.Push = "prod"
+	refs, err = Exec(context.Background(), cfg, c, names.Name{ShortName: "stack"})
+	assert.NoError(t, err)
+	assert.Equal(t, ImageRefs{"api": "prod/api:0123abcd", "db": "prod/db:1.0"}, refs)
+	assert.Equal(t, []string{
+		"build --iidfile",
+		"push prod/api:0123abcd",
+		"push prod/db:1.0",
+		"tag registry/db:1.0 prod/db:1.0",
+		"tag sha256:0123abcd prod/api:0123abcd",
+	}, readDockerLog(t, logPath))
+
+	assert.NoError(t, os.Remove(logPath))
+	c.Push = ""
+	c.Label = []string{"stack=stack"}
+	cfg.ContainerBuilder = config.Buildah
+	cfg.Tools[config.Buildah] = config.ToolInfo{Path: dockerPath}
+	refs, err = Exec(context.Background(), cfg, c, names.Name{ShortName: "stack"})
+	assert.NoError(t, err)
+	assert.Equal(t, ImageRefs{"api": "api:0123abcd", "db": "registry/db:0123abcd"}, refs)
+	assert.Equal(t, []string{
+		"bud --iidfile",
+		"bud --iidfile",
+		"push --digestfile registry/db:0123abcd docker://registry/db:0123abcd",
+		"tag sha256:0123abcd api:0123abcd",
+		"tag sha256:0123abcd registry/db:0123abcd",
+	}, readDockerLog(t, logPath))
+}
+
+func TestExecDigest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "container")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	dockerPath, err := filepath.Abs("testdata/docker.sh")
+	assert.NoError(t, err)
+	logPath := filepath.Join(dir, "log")
+	os.Setenv("DOCKER_LOG", logPath)
+	defer os.Unsetenv("DOCKER_LOG")
+
+	cfg := &config.Config{
+		WorkspaceDir: dir,
+		Tools:        map[config.Tool]config.ToolInfo{config.Docker: {Path: dockerPath}},
+	}
+	c := &pipelines.Container{
+		ParsedManifest: pipelines.ContainerManifest{
+			"api": {Ref: "api", Build: &pipelines.ContainerBuild{Context: "api"}},
+			"db":  {Ref: "registry/db:1.0"},
+		},
+		Push:   "prod",
+		Digest: true,
+	}
+
+	refs, err := Exec(context.Background(), cfg, c, names.Name{ShortName: "stack"})
+	assert.NoError(t, err)
+	assert.Equal(t, ImageRefs{"api": "prod/api@sha256:4567", "db": "prod/db@sha256:4567"}, refs)
+	assert.Equal(t, []string{
+		"build --iidfile",
+		"image inspect prod/api:0123abcd",
+		"image inspect prod/db:1.0",
+		"push prod/api:0123abcd",
+		"push prod/db:1.0",
+		"tag registry/db:1.0 prod/db:1.0",
+		"tag sha256:0123abcd prod/api:0123abcd",
+	}, readDockerLog(t, logPath))
+
+	// The images that are neither built nor pushed are pulled.
+	assert.NoError(t, os.Remove(logPath))
+	c.ParsedManifest = pipelines.ContainerManifest{"db": {Ref: "registry/db:1.0"}}
+	c.Push = ""
+	refs, err = Exec(context.Background(), cfg, c, names.Name{ShortName: "stack"})
+	assert.NoError(t, err)
+	assert.Equal(t, ImageRefs{"db": "registry/db@sha256:4567"}, refs)
+	assert.Equal(t, []string{
+		"image inspect registry/db:1.0",
+		"pull registry/db:1.0",
+	}, readDockerLog(t, logPath))
+
+	// With Buildah, the digests of pushed images are given by the push
+	// itself.
+	assert.NoError(t, os.Remove(logPath))
+	cfg.ContainerBuilder = config.Buildah
+	cfg.Tools[config.Buildah] = config.ToolInfo{Path: dockerPath}
+	c.ParsedManifest = pipelines.ContainerManifest{
+		"api": {Ref: "api", Build: &pipelines.ContainerBuild{Context: "api"}},
+		"db":  {Ref: "registry/db:1.0"},
+	}
+	c.Push = "prod"
+	refs, err = Exec(context.Background(), cfg, c, names.Name{ShortName: "stack"})
+	assert.NoError(t, err)
+	assert.Equal(t, ImageRefs{"api": "prod/api@sha256:89ab", "db": "prod/db@sha256:89ab"}, refs)
+	assert.Equal(t, []string{
+		"bud --iidfile",
+		"push --digestfile prod/api:0123abcd docker://prod/api:0123abcd",
+		"push --digestfile prod/db:1.0 docker://prod/db:1.0",
+		"tag registry/db:1.0 prod/db:1.0",
+		"tag sha256:0123abcd prod/api:0123abcd",
+	}, readDockerLog(t, logPath))
+
+	assert.NoError(t, os.Remove(logPath))
+	c.ParsedManifest = pipelines.ContainerManifest{"db": {Ref: "registry/db:1.0"}}
+	c.Push = ""
+	refs, err = Exec(context.Background(), cfg, c, names.Name{ShortName: "stack"})
+	assert.NoError(t, err)
+	assert.Equal(t, ImageRefs{"db": "registry/db@sha256:cdef"}, refs)
+	assert.Equal(t, []string{
+		"images --format registry/db:1.0",
+		"pull registry/db:1.0",
+	}, readDockerLog(t, logPath))
+
+	c.Load = true
+	_, err = Exec(context.Background(), cfg, c, names.Name{ShortName: "stack"})
+	assert.Error(t, err)
+}
+
+func TestExecDigestRefs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "container")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	dockerPath, err := filepath.Abs("testdata/docker.sh")
+	assert.NoError(t, err)
+	logPath := filepath.Join(dir, "log")
+	os.Setenv("DOCKER_LOG", logPath)
+	defer os.Unsetenv("DOCKER_LOG")
+
+	cfg := &config.Config{
+		WorkspaceDir: dir,
+		Tools:        map[config.Tool]config.ToolInfo{config.Docker: {Path: dockerPath}},
+	}
+	// References with a digest, e.g., from "skaffold build --file-output".
+	c := &pipelines.Container{
+		ParsedManifest: pipelines.ContainerManifest{
+			"api": {Ref: "localhost:5000/api:1.0@sha256:0123"},
+			"db":  {Ref: "registry/db@sha256:4567"},
+		},
+	}
+
+	refs, err := Exec(context.Background(), cfg, c, names.Name{ShortName: "stack"})
+	assert.NoError(t, err)
+	assert.Equal(t, ImageRefs{
+		"api": "localhost:5000/api:1.0@sha256:0123",
+		"db":  "registry/db@sha256:4567",
+	}, refs)
+
+	c.Label = []string{"stack=stack"}
+	refs, err = Exec(context.Background(), cfg, c, names.Name{ShortName: "stack"})
+	assert.NoError(t, err)
+	assert.Equal(t, ImageRefs{
+		"api": "localhost:5000/api:0123abcd",
+		"db":  "registry/db:0123abcd",
+	}, refs)
+
+	assert.NoError(t, os.Remove(logPath))
+	c.Label = nil
+	c.Push = "prod"
+	c.Digest = true
+	refs, err = Exec(context.Background(), cfg, c, names.Name{ShortName: "stack"})
+	assert.NoError(t, err)
+	assert.Equal(t, ImageRefs{"api": "prod/api@sha256:4567", "db": "prod/db@sha256:4567"}, refs)
+	assert.Equal(t, []string{
+		"image inspect prod/api:1.0",
+		"image inspect prod/db:4567",
+		"push prod/api:1.0",
+		"push prod/db:4567",
+		"tag localhost:5000/api:1.0@sha256:0123 prod/api:1.0",
+		"tag registry/db@sha256:4567 prod/db:4567",
+	}, readDockerLog(t, logPath))
+}
+
+func TestExecLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "container")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	dockerPath, err := filepath.Abs("testdata/docker.sh")
+	assert.NoError(t, err)
+	logPath := filepath.Join(dir, "log")
+	os.Setenv("DOCKER_LOG", logPath)
+	defer os.Unsetenv("DOCKER_LOG")
+
+	cfg := &config.Config{
+		WorkspaceDir: dir,
+		Tools: map[config.Tool]config.ToolInfo{
+			config.Docker: {Path: dockerPath},
+			config.Kind:   {Path: dockerPath},
+		},
+	}
+	c := &pipelines.Container{
+		ParsedManifest: pipelines.ContainerManifest{
+			"api": {Ref: "api", Build: &pipelines.ContainerBuild{Context: "api"}},
+			"db":  {Ref: "registry/db:1.0"},
+		},
+		Load: true,
+	}
+
+	_, err = Exec(context.Background(), cfg, c, names.Name{ShortName: "stack"})
+	assert.Error(t, err)
+
+	cfg.LocalCluster = &config.LocalCluster{Flavor: config.Kind, Name: "dev"}
+	refs, err := Exec(context.Background(), cfg, c, names.Name{ShortName: "stack"})
+	assert.NoError(t, err)
+	assert.Equal(t, ImageRefs{"api": "api:0123abcd", "db": "registry/db:0123abcd"}, refs)
+	assert.Equal(t, []string{
+		"build --iidfile",
+		"build --iidfile",
+		"load image-archive",
+		"load image-archive",
+		"save --output",
+		"save --output",
+		"tag sha256:0123abcd api:0123abcd",
+		"tag sha256:0123abcd registry/db:0123abcd",
+	}, readDockerLog(t, logPath))
+
+	c.Push = "prod"
+	_, err = Exec(context.Background(), cfg, c, names.Name{ShortName: "stack"})
+	assert.Error(t, err)
+}
+
+func TestLoaderArgs(t *testing.T) {
+	ld := &loader{cluster: &config.LocalCluster{Flavor: config.Kind}}
+	args, err := ld.args("image.tar")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"load", "image-archive", "image.tar"}, args)
+
+	ld.cluster = &config.LocalCluster{Flavor: config.K3d, Name: "dev"}
+	args, err = ld.args("image.tar")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"image", "import", "image.tar", "--cluster", "dev"}, args)
+
+	ld.cluster = &config.LocalCluster{Flavor: config.Minikube, Name: "dev"}
+	args, err = ld.args("image.tar")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"image", "load", "image.tar", "--profile", "dev"}, args)
+
+	ld.cluster = &config.LocalCluster{Flavor: config.Docker}
+	_, err = ld.args("image.tar")
+	assert.Error(t, err)
+}
+
+// readDockerLog reads the invocations of the fake Docker CLI, sorted,
+// as the images are processed in parallel.  The arguments of the
+// invocations that contain temporary paths or formats are truncated.
+func readDockerLog(t *testing.T, logPath string) []string {
+	b, err := ioutil.ReadFile(logPath)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "build", "bud", "save", "load":
+			lines[i] = strings.Join(fields[:2], " ")
+		case "image", "images":
+			lines[i] = strings.Join(append(fields[:2], fields[len(fields)-1]), " ")
+		case "push":
+			if fields[1] == "--digestfile" {
+				lines[i] = strings.Join(append(fields[:2], fields[3:]...), " ")
+			}
+		}
+	}
+	sort.Strings(lines)
+	return lines
+}
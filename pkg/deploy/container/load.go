@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package container
+
+import (
+	"context"
+	"fmt"
+	"github.com/hchauvin/warp/pkg/config"
+	"github.com/hchauvin/warp/pkg/proc"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// loader loads container images into a local Kubernetes cluster.
+type loader struct {
+	cfg     *config.Config
+	cluster *config.LocalCluster
+	path    string
+}
+
+// newLoader creates a loader for the local Kubernetes cluster configured
+// in config.Config.LocalCluster.
+func newLoader(cfg *config.Config) (*loader, error) {
+	if cfg.LocalCluster == nil {
+		return nil, fmt.Errorf("images can only be loaded when a local cluster is configured (LocalCluster in .warprc.toml)")
+	}
+	path, err := cfg.ToolPath(cfg.LocalCluster.Flavor)
+	if err != nil {
+		return nil, err
+	}
+	return &loader{cfg: cfg, cluster: cfg.LocalCluster, path: path}, nil
+}
+
+// load saves an image to a Docker archive, then loads this archive into
+// the nodes of the local cluster.  Going through an archive means that
+// the images do not have to be in the image store of a Docker daemon.
+func (ld *loader) load(ctx context.Context, builder Builder, ref string) error {
+	dir, err := ioutil.TempDir("", "warp-load")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	archivePath := filepath.Join(dir, "image.tar")
+	if err := builder.Save(ctx, ref, archivePath); err != nil {
+		return fmt.Errorf("could not save image '%s': %v", ref, err)
+	}
+
+	args, err := ld.args(archivePath)
+	if err != nil {
+		return err
+	}
+	cmd := proc.GracefulCommandContext(ctx, ld.path, args...)
+	ld.cfg.Logger().Pipe("container.load", cmd)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("could not load image '%s' with %s: %v", ref, ld.cluster.Flavor, err)
+	}
+	return nil
+}
+
+// args gives the command-line arguments to load an archive.
+func (ld *loader) args(archivePath string) ([]string, error) {
+	var args []string
+	var nameFlag string
+	switch ld.cluster.Flavor {
+	case config.Kind:
+		args = []string{"load", "image-archive", archivePath}
+		nameFlag = "--name"
+	case config.K3d:
+		args = []string{"image", "import", archivePath}
+		nameFlag = "--cluster"
+	case config.Minikube:
+		args = []string{"image", "load", archivePath}
+		nameFlag = "--profile"
+	default:
+		return nil, fmt.Errorf("unsupported local cluster flavor '%s'", ld.cluster.Flavor)
+	}
+	if ld.cluster.Name != "" {
+		args = append(args, nameFlag, ld.cluster.Name)
+	}
+	return args, nil
+}
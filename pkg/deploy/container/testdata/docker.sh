@@ -0,0 +1,16 @@
+#!/bin/sh
+# Fake Docker/Buildah CLI: records the invocations, writes a fixed image ID,
+# and gives a fixed digest.
+echo "$@" >> "$DOCKER_LOG"
+if [ "$1" = "build" ] || [ "$1" = "bud" ]; then
+  echo "sha256:0123abcd" > "$3"
+fi
+if [ "$1" = "image" ] && [ "$2" = "inspect" ]; then
+  echo "${5%:*}@sha256:4567"
+fi
+if [ "$1" = "push" ] && [ "$2" = "--digestfile" ]; then
+  echo "sha256:89ab" > "$3"
+fi
+if [ "$1" = "images" ]; then
+  echo "sha256:cdef"
+fi
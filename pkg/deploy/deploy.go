@@ -10,34 +10,198 @@ import (
 	"github.com/hchauvin/warp/pkg/config"
 	"github.com/hchauvin/warp/pkg/deploy/container"
 	"github.com/hchauvin/warp/pkg/deploy/helm"
+	"github.com/hchauvin/warp/pkg/deploy/jsonnet"
 	"github.com/hchauvin/warp/pkg/deploy/kustomize"
+	"github.com/hchauvin/warp/pkg/deploy/manifests"
 	"github.com/hchauvin/warp/pkg/k8s"
 	"github.com/hchauvin/warp/pkg/pipelines"
+	"github.com/hchauvin/warp/pkg/run"
 	"github.com/hchauvin/warp/pkg/stacks/names"
+	"golang.org/x/sync/errgroup"
+	"sync"
 )
 
-// Exec executes the "deploy" steps.
+const logDomain = "deploy"
+
+// Exec executes the "deploy" steps (see pipelines.Deploy.AllSteps).
+// A step is executed as soon as all the steps it depends on complete
+// successfully.  The readiness hooks are then executed.  If rollback is
+// enabled, the deployment is rolled back on failure (see
+// pipelines.Deploy.Rollback).
 func Exec(ctx context.Context, cfg *config.Config, pipeline *pipelines.Pipeline, name names.Name, k8sClient *k8s.K8s) error {
+	applied, err := execSteps(ctx, cfg, pipeline, name, k8sClient)
+	if err == nil {
+		err = execReadiness(ctx, cfg, pipeline, name, k8sClient)
+	}
+	if !pipeline.Deploy.Rollback {
+		return err
+	}
+	if err != nil {
+		return rollback(ctx, cfg, pipeline, name, k8sClient, err)
+	}
+	return saveSnapshot(cfg, name, applied)
+}
+
+// execSteps executes the deploy steps.  The steps that applied resources
+// are returned, in the order of pipelines.Deploy.AllSteps.
+func execSteps(
+	ctx context.Context,
+	cfg *config.Config,
+	pipeline *pipelines.Pipeline,
+	name names.Name,
+	k8sClient *k8s.K8s,
+) ([]RenderedStep, error) {
+	steps := pipeline.Deploy.AllSteps()
+
+	done := make(map[string]chan struct{}, len(steps))
+	stepsByName := make(map[string]*pipelines.DeployStep, len(steps))
+	for i := range steps {
+		done[steps[i].Name] = make(chan struct{})
+		stepsByName[steps[i].Name] = &steps[i]
+	}
+
+	stepRefs := make(map[string]container.ImageRefs)
+	stepResources := make(map[string]string)
+	var stepRefsMut sync.Mutex
+
+	g, gctx := errgroup.WithContext(ctx)
+	for i := range steps {
+		step := &steps[i]
+		g.Go(func() error {
+			for _, dep := range step.DependsOn {
+				select {
+				case <-gctx.Done():
+					return gctx.Err()
+				case <-done[dep]:
+				}
+			}
+
+			stepRefsMut.Lock()
+			refs := dependencyRefs(stepsByName, stepRefs, step)
+			stepRefsMut.Unlock()
+
+			cfg.Logger().Info(logDomain, "step %s: start", step.Name)
+			nextRefs, k8sResourcesPath, err := execStep(gctx, cfg, step, name, refs, pipeline.Deploy.PostRender, k8sClient)
+			if err != nil {
+				return fmt.Errorf("deploy.%s: %v", step.Name, err)
+			}
+			cfg.Logger().Info(logDomain, "step %s: success", step.Name)
+
+			stepRefsMut.Lock()
+			if nextRefs != nil {
+				stepRefs[step.Name] = nextRefs
+			}
+			if k8sResourcesPath != "" {
+				stepResources[step.Name] = k8sResourcesPath
+			}
+			stepRefsMut.Unlock()
+			close(done[step.Name])
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	var applied []RenderedStep
+	for _, step := range steps {
+		if k8sResourcesPath, ok := stepResources[step.Name]; ok {
+			applied = append(applied, RenderedStep{
+				Step:          step.Name,
+				ResourcesPath: k8sResourcesPath,
+			})
+		}
+	}
+	return applied, nil
+}
+
+// execReadiness executes the readiness hooks.
+func execReadiness(
+	ctx context.Context,
+	cfg *config.Config,
+	pipeline *pipelines.Pipeline,
+	name names.Name,
+	k8sClient *k8s.K8s,
+) error {
+	if len(pipeline.Deploy.Readiness) == 0 {
+		return nil
+	}
+	cfg.Logger().Info(logDomain, "readiness: start")
+	if err := run.ExecHooks(ctx, cfg, name, "deploy:readiness", pipeline.Deploy.Readiness, nil, k8sClient); err != nil {
+		return fmt.Errorf("deploy.readiness: %v", err)
+	}
+	cfg.Logger().Info(logDomain, "readiness: success")
+	return nil
+}
+
+// dependencyRefs gives the image references produced by the Container
+// steps a step depends on, directly or indirectly.
+func dependencyRefs(
+	stepsByName map[string]*pipelines.DeployStep,
+	stepRefs map[string]container.ImageRefs,
+	step *pipelines.DeployStep,
+) container.ImageRefs {
 	var refs container.ImageRefs
-	if pipeline.Deploy.Container != nil {
-		var err error
-		refs, err = container.Exec(ctx, cfg, pipeline, name)
-		if err != nil {
-			return fmt.Errorf("deploy.container: %v", err)
+	visited := make(map[string]struct{})
+	var visit func(step *pipelines.DeployStep)
+	visit = func(step *pipelines.DeployStep) {
+		for _, dep := range step.DependsOn {
+			if _, ok := visited[dep]; ok {
+				continue
+			}
+			visited[dep] = struct{}{}
+			visit(stepsByName[dep])
+			for k, v := range stepRefs[dep] {
+				if refs == nil {
+					refs = make(container.ImageRefs)
+				}
+				refs[k] = v
+			}
 		}
 	}
+	visit(step)
+	return refs
+}
 
-	if pipeline.Deploy.Helm != nil {
-		if err := helm.Exec(ctx, cfg, pipeline, name, refs, k8sClient); err != nil {
-			return fmt.Errorf("deploy.helm: %v", err)
+// execStep executes a deploy step.  For Container steps, the image
+// references are returned.  For the steps that apply resources, the
+// path to the resources is returned.
+func execStep(
+	ctx context.Context,
+	cfg *config.Config,
+	step *pipelines.DeployStep,
+	name names.Name,
+	refs container.ImageRefs,
+	postRender []pipelines.BaseCommand,
+	k8sClient *k8s.K8s,
+) (nextRefs container.ImageRefs, k8sResourcesPath string, err error) {
+	switch {
+	case step.Container != nil:
+		nextRefs, err = container.Exec(ctx, cfg, step.Container, name)
+		if err == nil && step.Container.Digest {
+			err = saveResolvedRefs(cfg, name, step.Name, nextRefs)
 		}
+	case step.Helm != nil:
+		k8sResourcesPath, err = helm.Exec(ctx, cfg, step.Helm, name, step.Name, refs, postRender, k8sClient)
+	case step.Kustomize != nil:
+		k8sResourcesPath, err = kustomize.Exec(ctx, cfg, step.Kustomize, name, step.Name, refs, postRender, k8sClient)
+	case step.Manifests != nil:
+		k8sResourcesPath, err = manifests.Exec(ctx, cfg, step.Manifests, name, step.Name, refs, postRender, k8sClient)
+	case step.Jsonnet != nil:
+		k8sResourcesPath, err = jsonnet.Exec(ctx, cfg, step.Jsonnet, name, step.Name, refs, postRender, k8sClient)
+	case step.Run != nil:
+		err = run.ExecBaseCommand(ctx, cfg, name, "deploy:"+step.Name, step.Run, nil, k8sClient)
+	}
+	if err != nil {
+		return nil, "", err
 	}
 
-	if pipeline.Deploy.Kustomize != nil {
-		if err := kustomize.Exec(ctx, cfg, pipeline, name, refs, k8sClient); err != nil {
-			return fmt.Errorf("deploy.kustomize: %v", err)
+	if step.WaitFor != nil {
+		hooks := []pipelines.CommandHook{{WaitFor: step.WaitFor}}
+		if err := run.ExecHooks(ctx, cfg, name, "deploy:"+step.Name, hooks, nil, k8sClient); err != nil {
+			return nil, "", err
 		}
 	}
 
-	return nil
+	return nextRefs, k8sResourcesPath, nil
 }
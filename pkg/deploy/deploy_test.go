@@ -0,0 +1,136 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package deploy
+
+import (
+	"context"
+	"github.com/hchauvin/warp/pkg/config"
+	"github.com/hchauvin/warp/pkg/deploy/container"
+	"github.com/hchauvin/warp/pkg/pipelines"
+	"github.com/hchauvin/warp/pkg/stacks/names"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExecSteps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deploy")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	logPath := filepath.Join(dir, "log")
+	step := func(name string, dependsOn ...string) pipelines.DeployStep {
+		return pipelines.DeployStep{
+			Name:      name,
+			DependsOn: dependsOn,
+			Run: &pipelines.BaseCommand{
+				Command: []string{"sh", "-c", "echo " + name + " >> " + logPath},
+			},
+		}
+	}
+
+	pipeline := &pipelines.Pipeline{
+		Deploy: pipelines.Deploy{
+			Steps: []pipelines.DeployStep{
+				step("c", "b"),
+				step("b", "a"),
+				step("a"),
+			},
+		},
+	}
+	cfg := &config.Config{WorkspaceDir: dir}
+
+	err = Exec(context.Background(), cfg, pipeline, names.Name{ShortName: "stack"}, nil)
+	assert.NoError(t, err)
+
+	b, err := ioutil.ReadFile(logPath)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, strings.Fields(string(b)))
+
+	pipeline.Deploy.Steps = append(pipeline.Deploy.Steps, pipelines.DeployStep{
+		Name: "fail",
+		Run:  &pipelines.BaseCommand{Command: []string{"false"}},
+	})
+	err = Exec(context.Background(), cfg, pipeline, names.Name{ShortName: "stack"}, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "deploy.fail: ")
+}
+
+func TestDependencyRefs(t *testing.T) {
+	steps := []pipelines.DeployStep{
+		{Name: "images1", Container: &pipelines.Container{}},
+		{Name: "images2", Container: &pipelines.Container{}},
+		{Name: "helm", Helm: &pipelines.Helm{}, DependsOn: []string{"images1"}},
+		{Name: "kustomize", Kustomize: &pipelines.Kustomize{}, DependsOn: []string{"helm", "images2"}},
+	}
+	stepsByName := make(map[string]*pipelines.DeployStep)
+	for i := range steps {
+		stepsByName[steps[i].Name] = &steps[i]
+	}
+	stepRefs := map[string]container.ImageRefs{
+		"images1": {"a": "registry/a"},
+		"images2": {"b": "registry/b"},
+	}
+
+	assert.Nil(t, dependencyRefs(stepsByName, stepRefs, &steps[0]))
+	assert.Equal(
+		t,
+		container.ImageRefs{"a": "registry/a"},
+		dependencyRefs(stepsByName, stepRefs, &steps[2]))
+	assert.Equal(
+		t,
+		container.ImageRefs{"a": "registry/a", "b": "registry/b"},
+		dependencyRefs(stepsByName, stepRefs, &steps[3]))
+}
+
+func TestExecConcurrentStacks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deploy")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	dockerPath, err := filepath.Abs("container/testdata/docker.sh")
+	assert.NoError(t, err)
+	os.Setenv("DOCKER_LOG", filepath.Join(dir, "log"))
+	defer os.Unsetenv("DOCKER_LOG")
+
+	cfg := &config.Config{
+		WorkspaceDir: dir,
+		OutputRoot:   "out",
+		Tools:        map[config.Tool]config.ToolInfo{config.Docker: {Path: dockerPath}},
+	}
+	pipeline := &pipelines.Pipeline{
+		Deploy: pipelines.Deploy{
+			Container: &pipelines.Container{
+				ParsedManifest: pipelines.ContainerManifest{
+					"db": {Ref: "registry/db:1.0"},
+				},
+				Push:   "prod",
+				Digest: true,
+			},
+		},
+	}
+
+	// The same pipeline is deployed to two stacks at once: the resolved
+	// references are recorded per stack, and the pipeline is left as is.
+	stackNames := []names.Name{{ShortName: "a"}, {ShortName: "b"}}
+	errc := make(chan error, len(stackNames))
+	for _, name := range stackNames {
+		name := name
+		go func() {
+			errc <- Exec(context.Background(), cfg, pipeline, name, nil)
+		}()
+	}
+	for range stackNames {
+		assert.NoError(t, <-errc)
+	}
+
+	for _, name := range stackNames {
+		b, err := ioutil.ReadFile(ResolvedRefsPath(cfg, name, pipelines.ContainerStep))
+		assert.NoError(t, err)
+		assert.Equal(t, "db: prod/db@sha256:4567\n", string(b))
+	}
+}
@@ -0,0 +1,281 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package deploy
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hchauvin/warp/pkg/k8s"
+	"github.com/hchauvin/warp/pkg/stacks/names"
+	"io"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// ChangeType is the type of change to a resource.
+type ChangeType string
+
+const (
+	// Added indicates a resource that is rendered but is not live.
+	Added ChangeType = "added"
+	// Deleted indicates a live resource that is not rendered anymore.
+	Deleted ChangeType = "deleted"
+	// Modified indicates a resource that is both rendered and live, with
+	// differing fields.
+	Modified ChangeType = "modified"
+)
+
+// ResourceDiff is the difference between the rendered and the live
+// versions of a resource.
+type ResourceDiff struct {
+	// Key identifies the resource (see k8s.ResourceKey).
+	Key    string
+	Change ChangeType
+	// Fields are the fields that differ, for modified resources.
+	Fields []FieldDiff
+}
+
+// FieldDiff is the difference between the rendered and the live
+// values of a field.
+type FieldDiff struct {
+	// Path is the path to the field, e.g. "spec.ports[0].port".
+	Path string
+	// Live is the live value, or nil if the field is absent.
+	Live interface{}
+	// Rendered is the rendered value, or nil if the field is absent.
+	Rendered interface{}
+}
+
+// lastAppliedAnnotation is the annotation "kubectl apply" uses to record
+// the last configuration that was applied.
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// serverManagedMetadata lists the metadata fields that are set by the
+// API server.
+var serverManagedMetadata = []string{
+	"creationTimestamp",
+	"generation",
+	"managedFields",
+	"resourceVersion",
+	"selfLink",
+	"uid",
+}
+
+// Diff compares the rendered resources of a stack (see Render) with the
+// live resources.  Only the fields that are rendered, or that were
+// previously applied and are not rendered anymore, are compared, so that
+// the fields that are defaulted or managed by the server are ignored.
+// The resources of the Helm releases are neither rendered nor labeled
+// with the stack, so they are not compared.
+func Diff(k8sClient *k8s.K8s, name names.Name, rendered []RenderedStep) ([]ResourceDiff, error) {
+	var diffs []ResourceDiff
+	renderedKeys := make(map[string]struct{})
+	for _, r := range rendered {
+		resources, err := ReadResources(r.ResourcesPath)
+		if err != nil {
+			return nil, err
+		}
+		for i := range resources {
+			resource := &resources[i]
+			key := k8s.ResourceKey(resource)
+			renderedKeys[key] = struct{}{}
+
+			live, err := k8sClient.GetResource(resource)
+			if err != nil {
+				return nil, err
+			}
+			if live == nil {
+				diffs = append(diffs, ResourceDiff{Key: key, Change: Added})
+				continue
+			}
+			fields, err := diffResource(resource, live)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", key, err)
+			}
+			if len(fields) > 0 {
+				diffs = append(diffs, ResourceDiff{Key: key, Change: Modified, Fields: fields})
+			}
+		}
+	}
+
+	live, err := k8sClient.ListStackResources(name)
+	if err != nil {
+		return nil, err
+	}
+	for i := range live {
+		key := k8s.ResourceKey(&live[i])
+		if _, ok := renderedKeys[key]; !ok {
+			diffs = append(diffs, ResourceDiff{Key: key, Change: Deleted})
+		}
+	}
+
+	return diffs, nil
+}
+
+// diffResource gives the fields that differ between the rendered and the
+// live versions of a resource.
+func diffResource(rendered, live *unstructured.Unstructured) ([]FieldDiff, error) {
+	renderedObj, err := normalize(rendered.Object)
+	if err != nil {
+		return nil, err
+	}
+	liveObj, err := normalize(live.Object)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastApplied interface{}
+	if s, ok := live.GetAnnotations()[lastAppliedAnnotation]; ok {
+		if err := json.Unmarshal([]byte(s), &lastApplied); err != nil {
+			return nil, fmt.Errorf("cannot decode annotation %s: %v", lastAppliedAnnotation, err)
+		}
+	}
+
+	stripServerManaged(renderedObj)
+	stripServerManaged(liveObj)
+	stripServerManaged(lastApplied)
+
+	var fields []FieldDiff
+	diffValues("", renderedObj, liveObj, lastApplied, &fields)
+	return fields, nil
+}
+
+// normalize converts an object to its JSON representation, so that, e.g.,
+// all the numbers are float64.
+func normalize(obj map[string]interface{}) (interface{}, error) {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	var normalized interface{}
+	if err := json.Unmarshal(b, &normalized); err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
+
+// stripServerManaged removes the status and the server-managed metadata
+// from a normalized object.
+func stripServerManaged(obj interface{}) {
+	m, ok := obj.(map[string]interface{})
+	if !ok {
+		return
+	}
+	delete(m, "status")
+	metadata, ok := m["metadata"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	for _, field := range serverManagedMetadata {
+		delete(metadata, field)
+	}
+	if annotations, ok := metadata["annotations"].(map[string]interface{}); ok {
+		delete(annotations, lastAppliedAnnotation)
+		if len(annotations) == 0 {
+			delete(metadata, "annotations")
+		}
+	}
+}
+
+// diffValues compares a rendered value with a live value.  The maps are
+// compared key by key, for the keys that are either rendered or were last
+// applied.  The lists are compared element by element when they have the
+// same length.  Otherwise, the values are compared as a whole.
+func diffValues(path string, rendered, live, lastApplied interface{}, fields *[]FieldDiff) {
+	switch r := rendered.(type) {
+	case map[string]interface{}:
+		l, ok := live.(map[string]interface{})
+		if !ok {
+			break
+		}
+		la, _ := lastApplied.(map[string]interface{})
+		keys := make(map[string]struct{}, len(r))
+		for k := range r {
+			keys[k] = struct{}{}
+		}
+		for k := range la {
+			if _, ok := l[k]; ok {
+				keys[k] = struct{}{}
+			}
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+		for _, k := range sorted {
+			fieldPath := k
+			if path != "" {
+				fieldPath = path + "." + k
+			}
+			diffValues(fieldPath, r[k], l[k], la[k], fields)
+		}
+		return
+
+	case []interface{}:
+		l, ok := live.([]interface{})
+		if !ok || len(l) != len(r) {
+			break
+		}
+		la, _ := lastApplied.([]interface{})
+		if len(la) != len(r) {
+			la = nil
+		}
+		for i := range r {
+			var lai interface{}
+			if la != nil {
+				lai = la[i]
+			}
+			diffValues(path+"["+strconv.Itoa(i)+"]", r[i], l[i], lai, fields)
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(rendered, live) {
+		*fields = append(*fields, FieldDiff{
+			Path:     path,
+			Live:     live,
+			Rendered: rendered,
+		})
+	}
+}
+
+// FormatDiff writes a human-readable version of resource differences.
+// Added resources are prefixed with "+", deleted resources with "-",
+// and modified resources with "~", followed by the fields that differ.
+func FormatDiff(w io.Writer, diffs []ResourceDiff) error {
+	for _, diff := range diffs {
+		var prefix string
+		switch diff.Change {
+		case Added:
+			prefix = "+"
+		case Deleted:
+			prefix = "-"
+		case Modified:
+			prefix = "~"
+		}
+		if _, err := fmt.Fprintf(w, "%s %s\n", prefix, diff.Key); err != nil {
+			return err
+		}
+		for _, field := range diff.Fields {
+			if _, err := fmt.Fprintf(w, "    %s: %s -> %s\n", field.Path, formatValue(field.Live), formatValue(field.Rendered)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func formatValue(v interface{}) string {
+	if v == nil {
+		return "<none>"
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(b)
+}
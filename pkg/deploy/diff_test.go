@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package deploy
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"testing"
+)
+
+func TestDiffResource(t *testing.T) {
+	rendered := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Service",
+		"metadata": map[string]interface{}{
+			"name":   "api",
+			"labels": map[string]interface{}{"warp.stack": "stack"},
+		},
+		"spec": map[string]interface{}{
+			"ports": []interface{}{
+				map[string]interface{}{"port": int64(8080)},
+			},
+		},
+	}}
+	live := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Service",
+		"metadata": map[string]interface{}{
+			"name":            "api",
+			"uid":             "1234",
+			"resourceVersion": "42",
+			"labels":          map[string]interface{}{"warp.stack": "stack"},
+			"annotations": map[string]interface{}{
+				lastAppliedAnnotation: `{"spec":{"ports":[{"port":80}],"type":"NodePort"}}`,
+			},
+		},
+		"spec": map[string]interface{}{
+			"clusterIP": "10.0.0.1",
+			"type":      "NodePort",
+			"ports": []interface{}{
+				map[string]interface{}{"port": int64(80), "protocol": "TCP"},
+			},
+		},
+		"status": map[string]interface{}{"loadBalancer": map[string]interface{}{}},
+	}}
+
+	fields, err := diffResource(rendered, live)
+	assert.NoError(t, err)
+	assert.Equal(t, []FieldDiff{
+		{Path: "spec.ports[0].port", Live: float64(80), Rendered: float64(8080)},
+		{Path: "spec.type", Live: "NodePort", Rendered: nil},
+	}, fields)
+
+	fields, err = diffResource(live, live)
+	assert.NoError(t, err)
+	assert.Empty(t, fields)
+}
+
+func TestFormatDiff(t *testing.T) {
+	var out bytes.Buffer
+	err := FormatDiff(&out, []ResourceDiff{
+		{Key: "Service/api", Change: Added},
+		{Key: "ConfigMap/old", Change: Deleted},
+		{
+			Key:    "Deployment/api",
+			Change: Modified,
+			Fields: []FieldDiff{
+				{Path: "spec.replicas", Live: float64(1), Rendered: float64(2)},
+				{Path: "spec.paused", Live: true},
+			},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t,
+		"+ Service/api\n"+
+			"- ConfigMap/old\n"+
+			"~ Deployment/api\n"+
+			"    spec.replicas: 1 -> 2\n"+
+			"    spec.paused: true -> <none>\n",
+		out.String())
+}
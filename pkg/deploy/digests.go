@@ -0,0 +1,42 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package deploy
+
+import (
+	"fmt"
+	"github.com/hchauvin/warp/pkg/config"
+	"github.com/hchauvin/warp/pkg/deploy/container"
+	"github.com/hchauvin/warp/pkg/stacks/names"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// ResolvedRefsPath gives the path to the image references resolved to
+// digests by a Container step for a stack (see
+// pipelines.Container.Digest).  The file is a YAML map from the image
+// reference placeholders to the resolved references.
+func ResolvedRefsPath(cfg *config.Config, name names.Name, step string) string {
+	return filepath.Join(cfg.Path(cfg.OutputRoot), "digests", name.String(), step+".yml")
+}
+
+// saveResolvedRefs records the image references resolved to digests by
+// a Container step.  Each stack has its own record, so that stacks
+// deployed concurrently from the same pipeline do not overwrite each
+// other's.
+func saveResolvedRefs(cfg *config.Config, name names.Name, step string, refs container.ImageRefs) error {
+	b, err := yaml.Marshal(refs)
+	if err != nil {
+		return err
+	}
+	path := ResolvedRefsPath(cfg, name, step)
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, b, 0666); err != nil {
+		return fmt.Errorf("cannot record resolved image references: %v", err)
+	}
+	return nil
+}
@@ -9,12 +9,17 @@ import (
 	"fmt"
 	"github.com/hchauvin/warp/pkg/config"
 	"github.com/hchauvin/warp/pkg/deploy/container"
+	"github.com/hchauvin/warp/pkg/deploy/postrender"
 	"github.com/hchauvin/warp/pkg/k8s"
 	"github.com/hchauvin/warp/pkg/pipelines"
 	"github.com/hchauvin/warp/pkg/proc"
 	"github.com/hchauvin/warp/pkg/stacks/names"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 )
 
 const (
@@ -22,62 +27,75 @@ const (
 )
 
 // Exec deploys a stack on Kubernetes using a Helm chart.
+//
+// The step is the name of the deploy step.  It is used to keep apart the
+// output of the steps of the same kind.  The path to the applied resources
+// is returned, or an empty string for Helm releases.
 func Exec(
 	ctx context.Context,
 	cfg *config.Config,
-	pipeline *pipelines.Pipeline,
+	h *pipelines.Helm,
 	name names.Name,
+	step string,
 	imageRefs container.ImageRefs,
+	postRender []pipelines.BaseCommand,
 	k8sClient *k8s.K8s,
-) error {
-	k8sResourcesPath, err := ExpandResources(ctx, cfg, pipeline, name, imageRefs)
+) (k8sResourcesPath string, err error) {
+	if h.Release {
+		return "", execRelease(ctx, cfg, h, name, step, imageRefs, k8sClient)
+	}
+
+	k8sResourcesPath, err = ExpandResources(ctx, cfg, h, name, step, imageRefs)
+	if err != nil {
+		return "", err
+	}
+
+	k8sResourcesPath, err = postrender.Exec(ctx, cfg, postRender, name, step, k8sResourcesPath)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	var labelSelector string
-	h := pipeline.Deploy.Helm
 	if h.LabelSelector != "" {
 		funcs := templateFuncs{cfg, name}
 		ls, err := funcs.Get(ctx, h.LabelSelector)
 		if err != nil {
-			return err
+			return "", err
 		}
 		labelSelector = ls
 	} else {
 		labelSelector = k8s.StackLabel + "=" + name.DNSName()
 	}
 
-	return k8sClient.Apply(ctx, k8sResourcesPath, labelSelector)
+	if err := k8sClient.ApplyStack(ctx, name, step, k8sResourcesPath, labelSelector, imageRefs); err != nil {
+		return "", err
+	}
+	return k8sResourcesPath, nil
 }
 
 // ExpandResources expands the resources defined in a Helm chart
 // into a YAML file, with one resource per YAML document.  The
-// path to this file is returned.
+// path to this file is returned.  The step is the name of the deploy
+// step (see Exec).
 func ExpandResources(
 	ctx context.Context,
 	cfg *config.Config,
-	pipeline *pipelines.Pipeline,
+	h *pipelines.Helm,
 	name names.Name,
+	step string,
 	imageRefs container.ImageRefs,
 ) (k8sResourcesPath string, err error) {
-	// TODO: Use imageRefs
-	h := pipeline.Deploy.Helm
-
-	outputFolderPath := filepath.Join(cfg.Path(cfg.OutputRoot), "helm", name.String())
+	outputFolderPath := filepath.Join(cfg.Path(cfg.OutputRoot), "helm", name.String(), step)
 	if err := os.MkdirAll(outputFolderPath, 0777); err != nil {
 		return "", err
 	}
 
-	args := []string{"template"}
 	funcs := templateFuncs{cfg, name}
-	for _, arg := range h.Args {
-		argExpanded, err := funcs.Get(ctx, arg)
-		if err != nil {
-			return "", err
-		}
-		args = append(args, argExpanded)
+	configArgs, err := chartArgs(ctx, cfg, h, &funcs, imageRefs, outputFolderPath)
+	if err != nil {
+		return "", err
 	}
+	args := append([]string{"template"}, configArgs...)
 	args = append(args, cfg.Path(h.Path))
 
 	helmPath, err := cfg.ToolPath(config.Helm)
@@ -98,7 +116,7 @@ func ExpandResources(
 	if err != nil {
 		panic(fmt.Errorf("could not pipe command stderr: %v", err))
 	}
-	cfg.Logger().PipeReader(config.Kustomize.LogDomain(), stderr)
+	cfg.Logger().PipeReader(config.Helm.LogDomain(), stderr)
 	if err := cmd.Run(); err != nil {
 		return "", fmt.Errorf("could not run helm on '%s': %v", h.Path, err)
 	}
@@ -111,3 +129,112 @@ func ExpandResources(
 
 	return k8sResourcesPath, nil
 }
+
+// chartArgs gives the Helm CLI arguments that configure a chart: the
+// additional arguments, the values files, the inline values, and the
+// image references.
+func chartArgs(
+	ctx context.Context,
+	cfg *config.Config,
+	h *pipelines.Helm,
+	funcs *templateFuncs,
+	imageRefs container.ImageRefs,
+	outputFolderPath string,
+) ([]string, error) {
+	var args []string
+	for _, arg := range h.Args {
+		argExpanded, err := funcs.Get(ctx, arg)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, argExpanded)
+	}
+	for _, valuesFile := range h.ValuesFiles {
+		args = append(args, "--values="+cfg.Path(valuesFile))
+	}
+	if len(h.Values) > 0 {
+		valuesPath, err := writeValues(ctx, funcs, h.Values, outputFolderPath)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "--values="+valuesPath)
+	}
+	imageArgs, err := imageSetArgs(h.Images, imageRefs)
+	if err != nil {
+		return nil, err
+	}
+	return append(args, imageArgs...), nil
+}
+
+// writeValues expands the templates in inline chart values (see
+// pipelines.Helm.Values) and writes them to a values file in the
+// output folder.  The path to this file is returned.
+func writeValues(
+	ctx context.Context,
+	funcs *templateFuncs,
+	values map[string]interface{},
+	outputFolderPath string,
+) (string, error) {
+	expanded, err := funcs.expandValues(ctx, values)
+	if err != nil {
+		return "", err
+	}
+	valuesYaml, err := yaml.Marshal(expanded)
+	if err != nil {
+		return "", fmt.Errorf("could not marshal values to Yaml: %v", err)
+	}
+	valuesPath := filepath.Join(outputFolderPath, "values.yml")
+	if err := ioutil.WriteFile(valuesPath, valuesYaml, 0777); err != nil {
+		return "", fmt.Errorf("could not write values '%s': %v", valuesPath, err)
+	}
+	return valuesPath, nil
+}
+
+// imageSetArgs gives the Helm CLI arguments that set the chart values
+// to the image references (see pipelines.Helm.Images).  The image
+// reference placeholders with no actual reference are ignored.
+func imageSetArgs(images map[string]string, imageRefs container.ImageRefs) ([]string, error) {
+	keys := make([]string, 0, len(images))
+	for k := range images {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var args []string
+	for _, k := range keys {
+		ref := imageRefs[k]
+		if ref == "" {
+			continue
+		}
+		paths := strings.Split(images[k], "/")
+		if len(paths) == 1 {
+			args = append(args, "--set-string="+paths[0]+"="+ref)
+			continue
+		}
+		if len(paths) > 3 {
+			return nil, fmt.Errorf("image '%s': invalid values paths '%s'", k, images[k])
+		}
+		name, tag, err := container.SplitImageRef(ref)
+		if err != nil {
+			return nil, err
+		}
+		if _, digest, ok := container.SplitImageDigest(ref); ok {
+			if len(paths) == 3 {
+				args = append(
+					args,
+					"--set-string="+paths[0]+"="+name,
+					"--set-string="+paths[2]+"="+digest)
+				continue
+			}
+			// Without a values path for the digest, the digest is pinned
+			// through the tag, as "name:tag@digest" is a valid image
+			// reference.
+			tag += "@" + digest
+		}
+		args = append(
+			args,
+			"--set-string="+paths[0]+"="+name,
+			"--set-string="+paths[1]+"="+tag)
+	}
+	return args, nil
+}
@@ -0,0 +1,172 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package helm
+
+import (
+	"context"
+	"github.com/hchauvin/warp/pkg/config"
+	"github.com/hchauvin/warp/pkg/deploy/container"
+	"github.com/hchauvin/warp/pkg/pipelines"
+	"github.com/hchauvin/warp/pkg/stacks/names"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestImageSetArgs(t *testing.T) {
+	args, err := imageSetArgs(
+		map[string]string{
+			"api":     "image.repository/image.tag",
+			"sidecar": "sidecar",
+			"missing": "missing",
+		},
+		container.ImageRefs{
+			"api":     "registry/api:1234",
+			"sidecar": "registry/sidecar",
+		})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"--set-string=image.repository=registry/api",
+		"--set-string=image.tag=1234",
+		"--set-string=sidecar=registry/sidecar",
+	}, args)
+
+	args, err = imageSetArgs(
+		map[string]string{
+			"api":     "image.repository/image.tag/image.digest",
+			"sidecar": "sidecar/tag/digest",
+		},
+		container.ImageRefs{
+			"api":     "registry/api@sha256:0123",
+			"sidecar": "registry/sidecar:1.0",
+		})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"--set-string=image.repository=registry/api",
+		"--set-string=image.digest=sha256:0123",
+		"--set-string=sidecar=registry/sidecar",
+		"--set-string=tag=1.0",
+	}, args)
+
+	// Without a values path for the digest, the digest is pinned through
+	// the tag.
+	args, err = imageSetArgs(
+		map[string]string{
+			"api":     "image.repository/image.tag",
+			"sidecar": "sidecar.repository/sidecar.tag",
+			"db":      "db",
+		},
+		container.ImageRefs{
+			"api":     "localhost:5000/api@sha256:0123",
+			"sidecar": "registry/sidecar:1.0@sha256:4567",
+			"db":      "registry/db:1.0@sha256:89ab",
+		})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"--set-string=image.repository=localhost:5000/api",
+		"--set-string=image.tag=latest@sha256:0123",
+		"--set-string=db=registry/db:1.0@sha256:89ab",
+		"--set-string=sidecar.repository=registry/sidecar",
+		"--set-string=sidecar.tag=1.0@sha256:4567",
+	}, args)
+
+	_, err = imageSetArgs(
+		map[string]string{"api": "a/b/c/d"},
+		container.ImageRefs{"api": "api"})
+	assert.EqualError(t, err, "image 'api': invalid values paths 'a/b/c/d'")
+
+	_, err = imageSetArgs(
+		map[string]string{"api": "a/b"},
+		container.ImageRefs{"api": "a:b:c"})
+	assert.EqualError(t, err, "invalid image ref 'a:b:c'")
+}
+
+func TestWriteValues(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	funcs := templateFuncs{
+		&config.Config{RunID: "run"},
+		names.Name{Family: "family", ShortName: "0"},
+	}
+	var values map[string]interface{}
+	assert.NoError(t, yaml.Unmarshal([]byte(`
+stack: '{{ stackName }}'
+nested:
+  family: '{{ family }}'
+  replicas: 2
+list: ['{{ runID }}']
+`), &values))
+
+	path, err := writeValues(context.Background(), &funcs, values, dir)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "values.yml"), path)
+
+	b, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	var written map[string]interface{}
+	assert.NoError(t, yaml.Unmarshal(b, &written))
+	assert.Equal(t, map[string]interface{}{
+		"stack": "family-0",
+		"nested": map[interface{}]interface{}{
+			"family":   "family",
+			"replicas": 2,
+		},
+		"list": []interface{}{"run"},
+	}, written)
+
+	_, err = writeValues(context.Background(), &funcs, map[string]interface{}{"a": "{{"}, dir)
+	assert.Error(t, err)
+}
+
+func TestExpandResourcesImages(t *testing.T) {
+	helmPath, err := exec.LookPath("helm")
+	if err != nil {
+		t.Skip("No helm")
+	}
+
+	workspaceDir, err := filepath.Abs("testdata")
+	assert.NoError(t, err)
+	outputRoot, err := ioutil.TempDir("", "helm")
+	assert.NoError(t, err)
+	defer os.RemoveAll(outputRoot)
+
+	cfg := &config.Config{
+		WorkspaceDir: workspaceDir,
+		OutputRoot:   outputRoot,
+		Tools: map[config.Tool]config.ToolInfo{
+			config.Helm: {Path: helmPath},
+		},
+	}
+	h := &pipelines.Helm{
+		Path: "chart",
+		Images: map[string]string{
+			"api":     "image.repository/image.tag",
+			"sidecar": "sidecar",
+		},
+	}
+	imageRefs := container.ImageRefs{
+		"api":     "registry/api:1234",
+		"sidecar": "registry/sidecar:5678",
+	}
+
+	path, err := ExpandResources(
+		context.Background(),
+		cfg,
+		h,
+		names.Name{ShortName: "stack"},
+		"helm",
+		imageRefs)
+	assert.NoError(t, err)
+
+	b, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), "image: 'registry/api:1234'")
+	assert.Contains(t, string(b), "image: 'registry/sidecar:5678'")
+}
@@ -0,0 +1,95 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package helm
+
+import (
+	"context"
+	"fmt"
+	"github.com/hchauvin/warp/pkg/config"
+	"github.com/hchauvin/warp/pkg/deploy/container"
+	"github.com/hchauvin/warp/pkg/k8s"
+	"github.com/hchauvin/warp/pkg/pipelines"
+	"github.com/hchauvin/warp/pkg/stacks/names"
+	"os"
+	"path/filepath"
+)
+
+// execRelease deploys a stack as a Helm release, and optionally tests
+// the release.
+func execRelease(
+	ctx context.Context,
+	cfg *config.Config,
+	h *pipelines.Helm,
+	name names.Name,
+	step string,
+	imageRefs container.ImageRefs,
+	k8sClient *k8s.K8s,
+) error {
+	outputFolderPath := filepath.Join(cfg.Path(cfg.OutputRoot), "helm", name.String(), step)
+	if err := os.MkdirAll(outputFolderPath, 0777); err != nil {
+		return err
+	}
+
+	funcs := templateFuncs{cfg, name}
+	configArgs, err := chartArgs(ctx, cfg, h, &funcs, imageRefs, outputFolderPath)
+	if err != nil {
+		return err
+	}
+
+	release := k8s.HelmReleaseName(name, step)
+	if err := k8sClient.RecordHelmRelease(name, release); err != nil {
+		return err
+	}
+	args := upgradeArgs(release, cfg.Path(h.Path), h.Timeout, configArgs)
+	if err := runHelm(ctx, cfg, k8sClient, args); err != nil {
+		return fmt.Errorf("could not install release '%s' of chart '%s': %v", release, h.Path, err)
+	}
+	cfg.Logger().Info(logDomain, "helm release '%s' deployed", release)
+
+	if h.Test {
+		if err := runHelm(ctx, cfg, k8sClient, testArgs(release, h.Timeout)); err != nil {
+			return fmt.Errorf("tests failed for release '%s': %v", release, err)
+		}
+		cfg.Logger().Info(logDomain, "helm release '%s' tested", release)
+	}
+
+	return nil
+}
+
+// upgradeArgs gives the Helm CLI arguments to install or upgrade
+// a release.
+func upgradeArgs(release, chartPath, timeout string, configArgs []string) []string {
+	args := []string{
+		"upgrade", release, chartPath,
+		"--install",
+		"--namespace", k8s.StackNamespace,
+		"--wait",
+	}
+	if timeout != "" {
+		args = append(args, "--timeout", timeout)
+	}
+	return append(args, configArgs...)
+}
+
+// testArgs gives the Helm CLI arguments to test a release.
+func testArgs(release, timeout string) []string {
+	args := []string{
+		"test", release,
+		"--namespace", k8s.StackNamespace,
+		"--logs",
+	}
+	if timeout != "" {
+		args = append(args, "--timeout", timeout)
+	}
+	return args
+}
+
+func runHelm(ctx context.Context, cfg *config.Config, k8sClient *k8s.K8s, args []string) error {
+	cmd, err := k8sClient.HelmCommandContext(ctx, args...)
+	if err != nil {
+		return err
+	}
+	cfg.Logger().Pipe(config.Helm.LogDomain(), cmd)
+	return cmd.Run()
+}
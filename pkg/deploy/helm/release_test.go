@@ -0,0 +1,40 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package helm
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestUpgradeArgs(t *testing.T) {
+	assert.Equal(
+		t,
+		[]string{
+			"upgrade", "stack", "/chart",
+			"--install",
+			"--namespace", "default",
+			"--wait",
+			"--values=/values.yml",
+		},
+		upgradeArgs("stack", "/chart", "", []string{"--values=/values.yml"}))
+
+	assert.Equal(
+		t,
+		[]string{
+			"upgrade", "stack", "/chart",
+			"--install",
+			"--namespace", "default",
+			"--wait",
+			"--timeout", "5m",
+		},
+		upgradeArgs("stack", "/chart", "5m", nil))
+}
+
+func TestTestArgs(t *testing.T) {
+	assert.Equal(
+		t,
+		[]string{"test", "stack", "--namespace", "default", "--logs", "--timeout", "1m"},
+		testArgs("stack", "1m"))
+}
@@ -45,5 +45,51 @@ func (funcs *templateFuncs) txtFuncMap(ctx context.Context) template.FuncMap {
 		"stackName": func() string {
 			return funcs.name.DNSName()
 		},
+		"family": func() string {
+			return funcs.name.Family
+		},
+		"runID": func() string {
+			return funcs.cfg.RunID
+		},
+	}
+}
+
+// expandValues expands the templates in all the strings of chart values.
+func (funcs *templateFuncs) expandValues(ctx context.Context, values interface{}) (interface{}, error) {
+	switch v := values.(type) {
+	case string:
+		return funcs.Get(ctx, v)
+	case map[interface{}]interface{}:
+		expanded := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			expandedValue, err := funcs.expandValues(ctx, value)
+			if err != nil {
+				return nil, err
+			}
+			expanded[fmt.Sprintf("%v", key)] = expandedValue
+		}
+		return expanded, nil
+	case map[string]interface{}:
+		expanded := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			expandedValue, err := funcs.expandValues(ctx, value)
+			if err != nil {
+				return nil, err
+			}
+			expanded[key] = expandedValue
+		}
+		return expanded, nil
+	case []interface{}:
+		expanded := make([]interface{}, len(v))
+		for i, value := range v {
+			expandedValue, err := funcs.expandValues(ctx, value)
+			if err != nil {
+				return nil, err
+			}
+			expanded[i] = expandedValue
+		}
+		return expanded, nil
+	default:
+		return values, nil
 	}
 }
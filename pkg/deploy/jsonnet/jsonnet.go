@@ -0,0 +1,214 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+// Package jsonnet implements Kubernetes deployment from Jsonnet programs.
+package jsonnet
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	gojsonnet "github.com/google/go-jsonnet"
+	"github.com/hchauvin/warp/pkg/config"
+	"github.com/hchauvin/warp/pkg/deploy/container"
+	"github.com/hchauvin/warp/pkg/deploy/postrender"
+	"github.com/hchauvin/warp/pkg/k8s"
+	"github.com/hchauvin/warp/pkg/pipelines"
+	"github.com/hchauvin/warp/pkg/stacks/names"
+	"io/ioutil"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strings"
+)
+
+const (
+	logDomain = "deploy.jsonnet"
+)
+
+// Exec deploys a stack on Kubernetes using a Jsonnet program.
+//
+// The step is the name of the deploy step.  It is used to keep apart the
+// output of the steps of the same kind.  The path to the applied resources
+// is returned.
+func Exec(
+	ctx context.Context,
+	cfg *config.Config,
+	j *pipelines.Jsonnet,
+	name names.Name,
+	step string,
+	imageRefs container.ImageRefs,
+	postRender []pipelines.BaseCommand,
+	k8sClient *k8s.K8s,
+) (k8sResourcesPath string, err error) {
+	k8sResourcesPath, err = ExpandResources(ctx, cfg, j, name, step, imageRefs)
+	if err != nil {
+		return "", err
+	}
+
+	k8sResourcesPath, err = postrender.Exec(ctx, cfg, postRender, name, step, k8sResourcesPath)
+	if err != nil {
+		return "", err
+	}
+
+	if err := k8sClient.ApplyStack(ctx, name, step, k8sResourcesPath, k8s.StackLabel+"="+name.DNSName(), imageRefs); err != nil {
+		return "", err
+	}
+	return k8sResourcesPath, nil
+}
+
+// ExpandResources evaluates a Jsonnet program into a YAML file, with
+// one resource per YAML document.  The path to this file is returned.
+// The step is the name of the deploy step (see Exec).
+func ExpandResources(
+	ctx context.Context,
+	cfg *config.Config,
+	j *pipelines.Jsonnet,
+	name names.Name,
+	step string,
+	imageRefs container.ImageRefs,
+) (k8sResourcesPath string, err error) {
+	outputFolderPath := filepath.Join(cfg.Path(cfg.OutputRoot), "jsonnet", name.String(), step)
+	if err := os.MkdirAll(outputFolderPath, 0777); err != nil {
+		return "", err
+	}
+
+	path := cfg.Path(j.Path)
+	program, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("cannot read Jsonnet file '%s': %v", path, err)
+	}
+
+	vm, err := makeVM(cfg, j, name, imageRefs)
+	if err != nil {
+		return "", err
+	}
+	output, err := vm.EvaluateSnippet(path, string(program))
+	if err != nil {
+		return "", fmt.Errorf("cannot evaluate Jsonnet file '%s': %v", path, err)
+	}
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(output), &value); err != nil {
+		return "", fmt.Errorf("cannot decode the output of Jsonnet file '%s': %v", path, err)
+	}
+	var resources []unstructured.Unstructured
+	if err := flatten(value, "$", &resources); err != nil {
+		return "", fmt.Errorf("Jsonnet file '%s': %v", path, err)
+	}
+
+	var namePrefix string
+	if j.NamePrefix {
+		namePrefix = name.DNSName() + "-"
+	}
+
+	var expanded bytes.Buffer
+	for i := range resources {
+		resource := &resources[i]
+		if err := k8s.AddStackLabel(resource, name.DNSName()); err != nil {
+			return "", fmt.Errorf("Jsonnet file '%s': %v", path, err)
+		}
+		if namePrefix != "" {
+			resource.SetName(namePrefix + resource.GetName())
+		}
+
+		b, err := yaml.Marshal(resource.Object)
+		if err != nil {
+			return "", fmt.Errorf("Jsonnet file '%s': cannot marshal resource: %v", path, err)
+		}
+		expanded.WriteString("---\n")
+		expanded.Write(b)
+	}
+
+	k8sResourcesPath = filepath.Join(outputFolderPath, "expanded_resources.yml")
+	if err := ioutil.WriteFile(k8sResourcesPath, expanded.Bytes(), 0777); err != nil {
+		return "", fmt.Errorf("could not write expanded resources '%s': %v", k8sResourcesPath, err)
+	}
+
+	cfg.Logger().Info(logDomain, "jsonnet expanded to '%s'", k8sResourcesPath)
+
+	return k8sResourcesPath, nil
+}
+
+// makeVM creates a Jsonnet virtual machine with the library search
+// paths and the external variables.
+func makeVM(
+	cfg *config.Config,
+	j *pipelines.Jsonnet,
+	name names.Name,
+	imageRefs container.ImageRefs,
+) (*gojsonnet.VM, error) {
+	vm := gojsonnet.MakeVM()
+
+	jpaths := make([]string, len(j.JPath))
+	for i, p := range j.JPath {
+		jpaths[i] = cfg.Path(p)
+	}
+	vm.Importer(&gojsonnet.FileImporter{JPaths: jpaths})
+
+	for k, v := range j.ExtVars {
+		vm.ExtVar(k, os.ExpandEnv(v))
+	}
+	vm.ExtVar("stackName", name.String())
+	vm.ExtVar("dnsName", name.DNSName())
+	vm.ExtVar("family", name.Family)
+	vm.ExtVar("runID", cfg.RunID)
+
+	images := imageRefs
+	if images == nil {
+		images = container.ImageRefs{}
+	}
+	b, err := json.Marshal(images)
+	if err != nil {
+		return nil, err
+	}
+	vm.ExtCode("images", string(b))
+
+	return vm, nil
+}
+
+// flatten collects the Kubernetes resources in the output of a Jsonnet
+// program.  The output can be a resource, a list of resources ("kind:
+// List"), or arrays and objects that contain resources, at any depth.
+// Null values are ignored.  The path is used in error messages.
+func flatten(value interface{}, path string, resources *[]unstructured.Unstructured) error {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		for i, item := range v {
+			if err := flatten(item, fmt.Sprintf("%s[%d]", path, i), resources); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]interface{}:
+		_, hasAPIVersion := v["apiVersion"]
+		kind, hasKind := v["kind"]
+		if hasAPIVersion && hasKind {
+			if kind, ok := kind.(string); ok && strings.HasSuffix(kind, "List") {
+				if items, ok := v["items"]; ok {
+					return flatten(items, path+".items", resources)
+				}
+			}
+			*resources = append(*resources, unstructured.Unstructured{Object: v})
+			return nil
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if err := flatten(v[k], path+"."+k, resources); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("%s: expected a Kubernetes resource, an array, or an object, got '%v'", path, value)
+	}
+}
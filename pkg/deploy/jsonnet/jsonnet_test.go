@@ -0,0 +1,107 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package jsonnet
+
+import (
+	"context"
+	"github.com/hchauvin/warp/pkg/config"
+	"github.com/hchauvin/warp/pkg/deploy/container"
+	"github.com/hchauvin/warp/pkg/pipelines"
+	"github.com/hchauvin/warp/pkg/stacks/names"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExpandResources(t *testing.T) {
+	outputRoot, err := ioutil.TempDir("", "jsonnet")
+	assert.NoError(t, err)
+	defer os.RemoveAll(outputRoot)
+
+	workspaceDir, err := filepath.Abs("testdata")
+	assert.NoError(t, err)
+	cfg := &config.Config{
+		WorkspaceDir: workspaceDir,
+		OutputRoot:   outputRoot,
+		RunID:        "run",
+	}
+	os.Setenv("WARP_TEST_ENV", "test")
+	defer os.Unsetenv("WARP_TEST_ENV")
+
+	j := &pipelines.Jsonnet{
+		Path:       "stack.jsonnet",
+		JPath:      []string{"lib"},
+		ExtVars:    map[string]string{"env": "$WARP_TEST_ENV"},
+		NamePrefix: true,
+	}
+	name := names.Name{Family: "foo", ShortName: "bar"}
+	refs := container.ImageRefs{"api": "registry/api:1.0"}
+
+	k8sResourcesPath, err := ExpandResources(context.Background(), cfg, j, name, "jsonnet", refs)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(outputRoot, "jsonnet", "foo_bar", "jsonnet", "expanded_resources.yml"), k8sResourcesPath)
+
+	b, err := ioutil.ReadFile(k8sResourcesPath)
+	assert.NoError(t, err)
+	assert.Equal(t, `---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  labels:
+    warp.stack: foo-bar
+  name: foo-bar-api
+spec:
+  template:
+    metadata:
+      labels:
+        app: api
+        warp.stack: foo-bar
+    spec:
+      containers:
+      - image: registry/api:1.0
+        name: api
+---
+apiVersion: v1
+data:
+  dns: foo-bar
+  env: test
+  family: foo
+  run: run
+  stack: foo_bar
+kind: ConfigMap
+metadata:
+  labels:
+    warp.stack: foo-bar
+  name: foo-bar-config
+`, string(b))
+}
+
+func TestFlatten(t *testing.T) {
+	resource := func(name string) map[string]interface{} {
+		return map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata":   map[string]interface{}{"name": name},
+		}
+	}
+
+	var resources []unstructured.Unstructured
+	err := flatten(map[string]interface{}{
+		"b": []interface{}{resource("b0"), nil, resource("b1")},
+		"a": map[string]interface{}{"nested": resource("a")},
+	}, "$", &resources)
+	assert.NoError(t, err)
+	var names []string
+	for _, r := range resources {
+		names = append(names, r.GetName())
+	}
+	assert.Equal(t, []string{"a", "b0", "b1"}, names)
+
+	resources = nil
+	err = flatten(map[string]interface{}{"a": []interface{}{"invalid"}}, "$", &resources)
+	assert.EqualError(t, err, "$.a[0]: expected a Kubernetes resource, an array, or an object, got 'invalid'")
+}
@@ -0,0 +1,13 @@
+{
+  deployment(name, image):: {
+    apiVersion: 'apps/v1',
+    kind: 'Deployment',
+    metadata: { name: name },
+    spec: {
+      template: {
+        metadata: { labels: { app: name } },
+        spec: { containers: [{ name: name, image: image }] },
+      },
+    },
+  },
+}
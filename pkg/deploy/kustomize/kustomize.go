@@ -9,15 +9,19 @@ import (
 	"fmt"
 	"github.com/hchauvin/warp/pkg/config"
 	"github.com/hchauvin/warp/pkg/deploy/container"
+	"github.com/hchauvin/warp/pkg/deploy/postrender"
 	"github.com/hchauvin/warp/pkg/k8s"
 	"github.com/hchauvin/warp/pkg/pipelines"
-	"github.com/hchauvin/warp/pkg/proc"
 	"github.com/hchauvin/warp/pkg/stacks/names"
-	"gopkg.in/yaml.v2"
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"strings"
+	"sigs.k8s.io/kustomize/api/filesys"
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/api/resid"
+	"sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/yaml"
+	"sort"
 )
 
 const (
@@ -25,78 +29,61 @@ const (
 )
 
 // Exec deploys a stack on Kubernetes using a Kustomization configuration.
+//
+// The step is the name of the deploy step.  It is used to keep apart the
+// output of the steps of the same kind.  The path to the applied resources
+// is returned.
 func Exec(
 	ctx context.Context,
 	cfg *config.Config,
-	pipeline *pipelines.Pipeline,
+	k *pipelines.Kustomize,
 	name names.Name,
+	step string,
 	imageRefs container.ImageRefs,
+	postRender []pipelines.BaseCommand,
 	k8sClient *k8s.K8s,
-) error {
-	k8sResourcesPath, err := ExpandResources(ctx, cfg, pipeline, name, imageRefs)
+) (k8sResourcesPath string, err error) {
+	k8sResourcesPath, err = ExpandResources(ctx, cfg, k, name, step, imageRefs)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	return k8sClient.Apply(ctx, k8sResourcesPath, k8s.StackLabel+"="+name.DNSName())
+	k8sResourcesPath, err = postrender.Exec(ctx, cfg, postRender, name, step, k8sResourcesPath)
+	if err != nil {
+		return "", err
+	}
+
+	if err := k8sClient.ApplyStack(ctx, name, step, k8sResourcesPath, k8s.StackLabel+"="+name.DNSName(), imageRefs); err != nil {
+		return "", err
+	}
+	return k8sResourcesPath, nil
 }
 
 // ExpandResources expands the resources defined in a kustomization
 // into a YAML file, with one resource per YAML document.  The
-// path to this file is returned.
+// path to this file is returned.  The step is the name of the deploy
+// step (see Exec).
+//
+// An overlay is written next to the expanded resources, and is built
+// in-process with the Kustomize API.
 func ExpandResources(
 	ctx context.Context,
 	cfg *config.Config,
-	pipeline *pipelines.Pipeline,
+	k *pipelines.Kustomize,
 	name names.Name,
+	step string,
 	imageRefs container.ImageRefs,
 ) (k8sResourcesPath string, err error) {
-	dnsName := name.DNSName()
-	k := pipeline.Deploy.Kustomize
-
-	overlayFolderPath := filepath.Join(cfg.Path(cfg.OutputRoot), "kustomize", name.String())
+	overlayFolderPath := filepath.Join(cfg.Path(cfg.OutputRoot), "kustomize", name.String(), step)
 	if err := os.MkdirAll(overlayFolderPath, 0777); err != nil {
 		return "", err
 	}
 
-	relativeBase, err := filepath.Rel(overlayFolderPath, cfg.Path(pipeline.Deploy.Kustomize.Path))
+	overlay, err := makeOverlay(cfg, k, name, imageRefs, overlayFolderPath)
 	if err != nil {
 		return "", err
 	}
 
-	type m map[string]interface{}
-	overlay := m{
-		"resources": []string{relativeBase},
-		"commonLabels": m{
-			k8s.StackLabel: dnsName,
-		},
-		"patchesStrategicMerge": pipeline.Deploy.Kustomize.PatchesStrategicMerge,
-	}
-	if !k.DisableNamePrefix {
-		overlay["namePrefix"] = dnsName + "-"
-	}
-	if imageRefs != nil {
-		images := make([]m, 0, len(imageRefs))
-		for k, v := range imageRefs {
-			if v == "" {
-				continue
-			}
-			image := m{"name": k}
-			parts := strings.Split(v, ":")
-			if len(parts) == 1 {
-				image["newName"] = parts[0]
-				image["newTag"] = "latest"
-			} else if len(parts) == 2 {
-				image["newName"] = parts[0]
-				image["newTag"] = parts[1]
-			} else {
-				return "", fmt.Errorf("invalid image ref '%s'", v)
-			}
-			images = append(images, image)
-		}
-		overlay["images"] = images
-	}
-
 	overlayYaml, err := yaml.Marshal(overlay)
 	if err != nil {
 		return "", fmt.Errorf("could not marshal overlay to Yaml: %v", err)
@@ -111,18 +98,160 @@ func ExpandResources(
 		return "", fmt.Errorf("could not write kustomization overlay '%s': %v", overlayPath, err)
 	}
 
-	kustomizePath, err := cfg.ToolPath(config.Kustomize)
+	resources, err := krusty.MakeKustomizer(filesys.MakeFsOnDisk(), krusty.MakeDefaultOptions()).
+		Run(overlayFolderPath)
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("could not build kustomization '%s': %v", overlayFolderPath, err)
 	}
+	resourcesYaml, err := resources.AsYaml()
+	if err != nil {
+		return "", fmt.Errorf("could not marshal the resources of kustomization '%s': %v", overlayFolderPath, err)
+	}
+
 	k8sResourcesPath = filepath.Join(overlayFolderPath, "expanded_resources.yml")
-	cmd := proc.GracefulCommandContext(ctx, kustomizePath, "build", overlayFolderPath, "-o", k8sResourcesPath)
-	cfg.Logger().Pipe(config.Kustomize.LogDomain(), cmd)
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("could not run kustomize on '%s': %v", overlayFolderPath, err)
+	if err := ioutil.WriteFile(k8sResourcesPath, resourcesYaml, 0777); err != nil {
+		return "", fmt.Errorf("could not write expanded resources '%s': %v", k8sResourcesPath, err)
 	}
 
 	cfg.Logger().Info(logDomain, "kustomization expanded to '%s'", k8sResourcesPath)
 
 	return k8sResourcesPath, nil
 }
+
+// makeOverlay makes the overlay that adds the stack-specific
+// configuration to a kustomization.
+func makeOverlay(
+	cfg *config.Config,
+	k *pipelines.Kustomize,
+	name names.Name,
+	imageRefs container.ImageRefs,
+	overlayFolderPath string,
+) (*types.Kustomization, error) {
+	dnsName := name.DNSName()
+
+	relativeBase, err := filepath.Rel(overlayFolderPath, cfg.Path(k.Path))
+	if err != nil {
+		return nil, err
+	}
+
+	expander := newTemplateExpander(cfg, name, imageRefs)
+
+	overlay := &types.Kustomization{
+		TypeMeta: types.TypeMeta{
+			APIVersion: types.KustomizationVersion,
+			Kind:       types.KustomizationKind,
+		},
+		Resources: []string{relativeBase},
+		CommonLabels: map[string]string{
+			k8s.StackLabel: dnsName,
+		},
+		Namespace: k.Namespace,
+	}
+	if !k.DisableNamePrefix {
+		overlay.NamePrefix = dnsName + "-"
+	}
+
+	for _, component := range k.Components {
+		relativeComponent, err := filepath.Rel(overlayFolderPath, cfg.Path(component))
+		if err != nil {
+			return nil, err
+		}
+		overlay.Components = append(overlay.Components, relativeComponent)
+	}
+
+	if len(k.CommonAnnotations) > 0 {
+		overlay.CommonAnnotations = make(map[string]string, len(k.CommonAnnotations))
+		for key, value := range k.CommonAnnotations {
+			expanded, err := expander.expand("commonAnnotations."+key, value)
+			if err != nil {
+				return nil, err
+			}
+			overlay.CommonAnnotations[key] = expanded
+		}
+	}
+
+	for i, patch := range k.PatchesStrategicMerge {
+		expanded, err := expander.expand(fmt.Sprintf("patchesStrategicMerge[%d]", i), patch)
+		if err != nil {
+			return nil, err
+		}
+		overlay.PatchesStrategicMerge = append(overlay.PatchesStrategicMerge, types.PatchStrategicMerge(expanded))
+	}
+
+	for i, patch := range k.PatchesJSON6902 {
+		expanded, err := expander.expand(fmt.Sprintf("patchesJson6902[%d]", i), patch.Patch)
+		if err != nil {
+			return nil, err
+		}
+		overlay.PatchesJson6902 = append(overlay.PatchesJson6902, types.PatchJson6902{
+			Target: &types.PatchTarget{
+				Gvk: resid.Gvk{
+					Group:   patch.Target.Group,
+					Version: patch.Target.Version,
+					Kind:    patch.Target.Kind,
+				},
+				Namespace: patch.Target.Namespace,
+				Name:      patch.Target.Name,
+			},
+			Patch: expanded,
+		})
+	}
+
+	for _, gen := range k.ConfigMapGenerator {
+		literals, err := expander.expandLiterals("configMapGenerator."+gen.Name, gen.Literals)
+		if err != nil {
+			return nil, err
+		}
+		overlay.ConfigMapGenerator = append(overlay.ConfigMapGenerator, types.ConfigMapArgs{
+			GeneratorArgs: types.GeneratorArgs{
+				Name:          gen.Name,
+				KvPairSources: types.KvPairSources{LiteralSources: literals},
+			},
+		})
+	}
+
+	for _, gen := range k.SecretGenerator {
+		literals, err := expander.expandLiterals("secretGenerator."+gen.Name, gen.Literals)
+		if err != nil {
+			return nil, err
+		}
+		overlay.SecretGenerator = append(overlay.SecretGenerator, types.SecretArgs{
+			GeneratorArgs: types.GeneratorArgs{
+				Name:          gen.Name,
+				KvPairSources: types.KvPairSources{LiteralSources: literals},
+			},
+			Type: gen.Type,
+		})
+	}
+
+	imageNames := make([]string, 0, len(imageRefs))
+	for imageName := range imageRefs {
+		imageNames = append(imageNames, imageName)
+	}
+	sort.Strings(imageNames)
+	for _, imageName := range imageNames {
+		ref := imageRefs[imageName]
+		if ref == "" {
+			continue
+		}
+		if newName, digest, ok := container.SplitImageDigest(ref); ok {
+			overlay.Images = append(overlay.Images, types.Image{
+				Name:    imageName,
+				NewName: newName,
+				Digest:  digest,
+			})
+			continue
+		}
+		newName, newTag, err := container.SplitImageRef(ref)
+		if err != nil {
+			return nil, err
+		}
+		overlay.Images = append(overlay.Images, types.Image{
+			Name:    imageName,
+			NewName: newName,
+			NewTag:  newTag,
+		})
+	}
+
+	return overlay, nil
+}
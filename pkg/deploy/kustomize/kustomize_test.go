@@ -0,0 +1,121 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package kustomize
+
+import (
+	"context"
+	"github.com/hchauvin/warp/pkg/config"
+	"github.com/hchauvin/warp/pkg/deploy/container"
+	"github.com/hchauvin/warp/pkg/pipelines"
+	"github.com/hchauvin/warp/pkg/stacks/names"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/kustomize/api/types"
+	"strings"
+	"testing"
+)
+
+func TestExpandResources(t *testing.T) {
+	outputRoot, err := ioutil.TempDir("", "kustomize")
+	assert.NoError(t, err)
+	defer os.RemoveAll(outputRoot)
+
+	workspaceDir, err := filepath.Abs("testdata")
+	assert.NoError(t, err)
+	cfg := &config.Config{
+		WorkspaceDir: workspaceDir,
+		OutputRoot:   outputRoot,
+		RunID:        "run",
+	}
+
+	k := &pipelines.Kustomize{
+		Path:       "base",
+		Namespace:  "default",
+		Components: []string{"component"},
+		CommonAnnotations: map[string]string{
+			"stack": "{{ .StackName }}",
+		},
+		PatchesStrategicMerge: []string{
+			"apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: api\n  labels:\n    run: '{{ .RunID }}'\n",
+		},
+		PatchesJSON6902: []pipelines.KustomizeJSONPatch{
+			{
+				Target: pipelines.KustomizePatchTarget{
+					Group:   "apps",
+					Version: "v1",
+					Kind:    "Deployment",
+					Name:    "api",
+				},
+				Patch: "- op: replace\n  path: /spec/replicas\n  value: 3\n",
+			},
+		},
+		ConfigMapGenerator: []pipelines.KustomizeGenerator{
+			{Name: "config", Literals: []string{"STACK={{ .DNSName }}"}},
+		},
+		SecretGenerator: []pipelines.KustomizeGenerator{
+			{Name: "secret", Literals: []string{"password=foo"}},
+		},
+	}
+	name := names.Name{Family: "foo", ShortName: "bar"}
+	refs := container.ImageRefs{"api": "registry/api:1.0"}
+
+	k8sResourcesPath, err := ExpandResources(context.Background(), cfg, k, name, "kustomize", refs)
+	assert.NoError(t, err)
+
+	b, err := ioutil.ReadFile(k8sResourcesPath)
+	assert.NoError(t, err)
+	resources := string(b)
+
+	assert.Contains(t, resources, "name: foo-bar-api\n")
+	assert.Contains(t, resources, "namespace: default\n")
+	assert.Contains(t, resources, "warp.stack: foo-bar\n")
+	assert.Contains(t, resources, "component: enabled\n")
+	assert.Contains(t, resources, "stack: foo_bar\n")
+	assert.Contains(t, resources, "run: run\n")
+	assert.Contains(t, resources, "replicas: 3\n")
+	assert.Contains(t, resources, "image: registry/api:1.0\n")
+	assert.Contains(t, resources, "STACK: foo-bar\n")
+	assert.Contains(t, resources, "kind: Secret\n")
+
+	// The reference to the generated ConfigMap is updated with the
+	// prefix and the hash.
+	assert.Contains(t, resources, "name: foo-bar-config-")
+	assert.Equal(t, 2, strings.Count(resources, "name: foo-bar-config-"))
+}
+
+func TestMakeOverlayImages(t *testing.T) {
+	cfg := &config.Config{WorkspaceDir: "/workspace"}
+	k := &pipelines.Kustomize{Path: "base"}
+	refs := container.ImageRefs{
+		"api":     "registry/api:1.0",
+		"db":      "registry/db@sha256:0123",
+		"missing": "",
+		"web":     "localhost:5000/web:2.0@sha256:4567",
+	}
+
+	overlay, err := makeOverlay(cfg, k, names.Name{ShortName: "stack"}, refs, "/workspace/overlay")
+	assert.NoError(t, err)
+	assert.Equal(t, []types.Image{
+		{Name: "api", NewName: "registry/api", NewTag: "1.0"},
+		{Name: "db", NewName: "registry/db", Digest: "sha256:0123"},
+		{Name: "web", NewName: "localhost:5000/web", Digest: "sha256:4567"},
+	}, overlay.Images)
+}
+
+func TestExpandLiterals(t *testing.T) {
+	cfg := &config.Config{RunID: "run"}
+	expander := newTemplateExpander(cfg, names.Name{ShortName: "staging"}, nil)
+
+	literals, err := expander.expandLiterals("gen", []string{"a={{ .DNSName }}", "b=c=d"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a=staging", "b=c=d"}, literals)
+
+	_, err = expander.expandLiterals("gen", []string{"invalid"})
+	assert.Error(t, err)
+
+	_, err = expander.expandLiterals("gen", []string{"a={{ .Missing }}"})
+	assert.Error(t, err)
+}
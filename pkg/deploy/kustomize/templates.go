@@ -0,0 +1,64 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package kustomize
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/Masterminds/sprig"
+	"github.com/hchauvin/warp/pkg/config"
+	"github.com/hchauvin/warp/pkg/deploy/container"
+	deploytemplates "github.com/hchauvin/warp/pkg/deploy/templates"
+	"github.com/hchauvin/warp/pkg/stacks/names"
+	"github.com/hchauvin/warp/pkg/templates"
+	"strings"
+	"text/template"
+)
+
+// templateExpander expands the templates of the overlay with
+// information about the stack.
+type templateExpander struct {
+	data *deploytemplates.Data
+}
+
+func newTemplateExpander(cfg *config.Config, name names.Name, imageRefs container.ImageRefs) *templateExpander {
+	return &templateExpander{
+		data: deploytemplates.NewData(cfg, name, imageRefs),
+	}
+}
+
+// expand expands a template.  The path identifies the template in
+// error messages.
+func (expander *templateExpander) expand(path string, text string) (string, error) {
+	tpl, err := template.New(path).
+		Funcs(sprig.TxtFuncMap()).
+		Funcs(templates.TxtFuncMap()).
+		Option("missingkey=error").
+		Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("cannot parse template %s: %v", path, err)
+	}
+	var expanded bytes.Buffer
+	if err := tpl.Execute(&expanded, expander.data); err != nil {
+		return "", fmt.Errorf("cannot expand template %s: %v", path, err)
+	}
+	return expanded.String(), nil
+}
+
+// expandLiterals expands the values of "key=value" literals.
+func (expander *templateExpander) expandLiterals(path string, literals []string) ([]string, error) {
+	expanded := make([]string, 0, len(literals))
+	for _, literal := range literals {
+		parts := strings.SplitN(literal, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%s: invalid literal '%s': expected 'key=value'", path, literal)
+		}
+		value, err := expander.expand(path+"."+parts[0], parts[1])
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, parts[0]+"="+value)
+	}
+	return expanded, nil
+}
@@ -0,0 +1,189 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+// Package manifests implements Kubernetes deployment from raw manifests.
+package manifests
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/Masterminds/sprig"
+	"github.com/hchauvin/warp/pkg/config"
+	"github.com/hchauvin/warp/pkg/deploy/container"
+	"github.com/hchauvin/warp/pkg/deploy/postrender"
+	deploytemplates "github.com/hchauvin/warp/pkg/deploy/templates"
+	"github.com/hchauvin/warp/pkg/k8s"
+	"github.com/hchauvin/warp/pkg/pipelines"
+	"github.com/hchauvin/warp/pkg/stacks/names"
+	"github.com/hchauvin/warp/pkg/templates"
+	"io"
+	"io/ioutil"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"text/template"
+)
+
+const (
+	logDomain = "deploy.manifests"
+)
+
+// Exec deploys a stack on Kubernetes using raw manifests.
+//
+// The step is the name of the deploy step.  It is used to keep apart the
+// output of the steps of the same kind.  The path to the applied resources
+// is returned.
+func Exec(
+	ctx context.Context,
+	cfg *config.Config,
+	m *pipelines.Manifests,
+	name names.Name,
+	step string,
+	imageRefs container.ImageRefs,
+	postRender []pipelines.BaseCommand,
+	k8sClient *k8s.K8s,
+) (k8sResourcesPath string, err error) {
+	k8sResourcesPath, err = ExpandResources(ctx, cfg, m, name, step, imageRefs)
+	if err != nil {
+		return "", err
+	}
+
+	k8sResourcesPath, err = postrender.Exec(ctx, cfg, postRender, name, step, k8sResourcesPath)
+	if err != nil {
+		return "", err
+	}
+
+	if err := k8sClient.ApplyStack(ctx, name, step, k8sResourcesPath, k8s.StackLabel+"="+name.DNSName(), imageRefs); err != nil {
+		return "", err
+	}
+	return k8sResourcesPath, nil
+}
+
+// ExpandResources expands the resources defined in raw manifests
+// into a YAML file, with one resource per YAML document.  The
+// path to this file is returned.  The step is the name of the deploy
+// step (see Exec).
+func ExpandResources(
+	ctx context.Context,
+	cfg *config.Config,
+	m *pipelines.Manifests,
+	name names.Name,
+	step string,
+	imageRefs container.ImageRefs,
+) (k8sResourcesPath string, err error) {
+	outputFolderPath := filepath.Join(cfg.Path(cfg.OutputRoot), "manifests", name.String(), step)
+	if err := os.MkdirAll(outputFolderPath, 0777); err != nil {
+		return "", err
+	}
+
+	paths, err := glob(cfg, m.Paths)
+	if err != nil {
+		return "", err
+	}
+
+	data := deploytemplates.NewData(cfg, name, imageRefs)
+	var namePrefix string
+	if m.NamePrefix {
+		namePrefix = name.DNSName() + "-"
+	}
+
+	var expanded bytes.Buffer
+	for _, path := range paths {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("cannot read manifest '%s': %v", path, err)
+		}
+		resources, err := expandManifest(path, b, data, namePrefix)
+		if err != nil {
+			return "", err
+		}
+		expanded.Write(resources)
+	}
+
+	k8sResourcesPath = filepath.Join(outputFolderPath, "expanded_resources.yml")
+	if err := ioutil.WriteFile(k8sResourcesPath, expanded.Bytes(), 0777); err != nil {
+		return "", fmt.Errorf("could not write expanded resources '%s': %v", k8sResourcesPath, err)
+	}
+
+	cfg.Logger().Info(logDomain, "manifests expanded to '%s'", k8sResourcesPath)
+
+	return k8sResourcesPath, nil
+}
+
+// glob gives the paths to the files that match glob patterns, relative
+// to the workspace dir.  It errors if a pattern does not match any file.
+func glob(cfg *config.Config, patterns []string) ([]string, error) {
+	var paths []string
+	seen := make(map[string]struct{})
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(cfg.Path(pattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid manifest pattern '%s': %v", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("manifest pattern '%s' does not match any file", pattern)
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			if _, ok := seen[match]; ok {
+				continue
+			}
+			seen[match] = struct{}{}
+			paths = append(paths, match)
+		}
+	}
+	return paths, nil
+}
+
+// expandManifest expands the templates in a manifest file, and gives the
+// resulting resources, with the stack label and the name prefix, as YAML
+// documents.
+func expandManifest(path string, manifest []byte, data *deploytemplates.Data, namePrefix string) ([]byte, error) {
+	tpl, err := template.New(path).
+		Funcs(sprig.TxtFuncMap()).
+		Funcs(templates.TxtFuncMap()).
+		Option("missingkey=error").
+		Parse(string(manifest))
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse manifest '%s': %v", path, err)
+	}
+	var expanded bytes.Buffer
+	if err := tpl.Execute(&expanded, data); err != nil {
+		return nil, fmt.Errorf("cannot expand manifest '%s': %v", path, err)
+	}
+
+	var out bytes.Buffer
+	decoder := utilyaml.NewYAMLOrJSONDecoder(&expanded, 4096)
+	for {
+		var obj map[string]interface{}
+		if err := decoder.Decode(&obj); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("cannot decode manifest '%s': %v", path, err)
+		}
+		if len(obj) == 0 {
+			continue
+		}
+
+		resource := unstructured.Unstructured{Object: obj}
+		if err := k8s.AddStackLabel(&resource, data.DNSName); err != nil {
+			return nil, fmt.Errorf("manifest '%s': %v", path, err)
+		}
+		if namePrefix != "" {
+			resource.SetName(namePrefix + resource.GetName())
+		}
+
+		b, err := yaml.Marshal(resource.Object)
+		if err != nil {
+			return nil, fmt.Errorf("manifest '%s': cannot marshal resource: %v", path, err)
+		}
+		out.WriteString("---\n")
+		out.Write(b)
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,114 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package manifests
+
+import (
+	"context"
+	"github.com/hchauvin/warp/pkg/config"
+	"github.com/hchauvin/warp/pkg/deploy/container"
+	deploytemplates "github.com/hchauvin/warp/pkg/deploy/templates"
+	"github.com/hchauvin/warp/pkg/pipelines"
+	"github.com/hchauvin/warp/pkg/stacks/names"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExpandResources(t *testing.T) {
+	workspaceDir, err := filepath.Abs("testdata")
+	assert.NoError(t, err)
+	outputRoot, err := ioutil.TempDir("", "manifests")
+	assert.NoError(t, err)
+	defer os.RemoveAll(outputRoot)
+
+	cfg := &config.Config{
+		WorkspaceDir: workspaceDir,
+		OutputRoot:   outputRoot,
+		RunID:        "run",
+	}
+	m := &pipelines.Manifests{
+		Paths:      []string{"manifests/*.yml", "manifests/service.yml"},
+		NamePrefix: true,
+	}
+
+	path, err := ExpandResources(
+		context.Background(),
+		cfg,
+		m,
+		names.Name{Family: "family", ShortName: "0"},
+		"step",
+		container.ImageRefs{"api": "registry/api:1234"})
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(outputRoot, "manifests", "family_0", "step", "expanded_resources.yml"), path)
+
+	b, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, `---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  labels:
+    app: api
+    warp.stack: family-0
+  name: family-0-api
+spec:
+  selector:
+    matchLabels:
+      app: api
+  template:
+    metadata:
+      labels:
+        app: api
+        warp.stack: family-0
+    spec:
+      containers:
+      - env:
+        - name: RUN_ID
+          value: run
+        image: registry/api:1234
+        name: api
+---
+apiVersion: v1
+kind: Service
+metadata:
+  labels:
+    warp.stack: family-0
+  name: family-0-api
+spec:
+  ports:
+  - port: 80
+  selector:
+    app: api
+---
+apiVersion: v1
+data:
+  dns: family-0
+  family: family
+  stack: family_0
+kind: ConfigMap
+metadata:
+  labels:
+    warp.stack: family-0
+  name: family-0-config
+`, string(b))
+}
+
+func TestExpandResourcesErrors(t *testing.T) {
+	workspaceDir, err := filepath.Abs("testdata")
+	assert.NoError(t, err)
+	cfg := &config.Config{WorkspaceDir: workspaceDir}
+
+	_, err = glob(cfg, []string{"manifests/*.json"})
+	assert.EqualError(t, err, "manifest pattern 'manifests/*.json' does not match any file")
+
+	_, err = expandManifest("foo.yml", []byte("{{ .Unknown }}"), &deploytemplates.Data{}, "")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot expand manifest 'foo.yml'")
+
+	_, err = expandManifest("foo.yml", []byte("a: [b"), &deploytemplates.Data{}, "")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot decode manifest 'foo.yml'")
+}
@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+// Package postrender implements the transformation of expanded resources
+// by post-render commands (see pipelines.Deploy.PostRender).
+package postrender
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/hchauvin/warp/pkg/config"
+	"github.com/hchauvin/warp/pkg/pipelines"
+	"github.com/hchauvin/warp/pkg/proc"
+	"github.com/hchauvin/warp/pkg/stacks/names"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const logDomain = "deploy.postRender"
+
+// Exec pipes the expanded resources through the post-render commands,
+// in order, and writes the result next to the expanded resources.  The
+// path to the transformed resources is returned.  If there are no
+// post-render commands, the path to the expanded resources is returned
+// as is.  The step is the name of the deploy step that expanded the
+// resources.
+func Exec(
+	ctx context.Context,
+	cfg *config.Config,
+	commands []pipelines.BaseCommand,
+	name names.Name,
+	step string,
+	k8sResourcesPath string,
+) (string, error) {
+	if len(commands) == 0 {
+		return k8sResourcesPath, nil
+	}
+
+	resources, err := ioutil.ReadFile(k8sResourcesPath)
+	if err != nil {
+		return "", err
+	}
+
+	for i := range commands {
+		resources, err = execCommand(ctx, cfg, &commands[i], name, step, i, resources)
+		if err != nil {
+			return "", fmt.Errorf("deploy.postRender[%d]: %v", i, err)
+		}
+	}
+
+	postRenderedPath := filepath.Join(filepath.Dir(k8sResourcesPath), "post_rendered_resources.yml")
+	if err := ioutil.WriteFile(postRenderedPath, resources, 0777); err != nil {
+		return "", fmt.Errorf("could not write post-rendered resources '%s': %v", postRenderedPath, err)
+	}
+
+	cfg.Logger().Info(logDomain, "resources of step %s post-rendered to '%s'", step, postRenderedPath)
+
+	return postRenderedPath, nil
+}
+
+// execCommand executes a post-render command, and gives its output.
+func execCommand(
+	ctx context.Context,
+	cfg *config.Config,
+	spec *pipelines.BaseCommand,
+	name names.Name,
+	step string,
+	index int,
+	resources []byte,
+) ([]byte, error) {
+	if len(spec.Command) == 0 {
+		return nil, fmt.Errorf("command must at least give the program name")
+	}
+	cmd := proc.GracefulCommandContext(ctx, spec.Command[0], spec.Command[1:]...)
+	if spec.WorkingDir != "" {
+		cmd.Dir = cfg.Path(spec.WorkingDir)
+	}
+	cmd.Env = append(os.Environ(), stackEnv(cfg, name, step)...)
+	for _, e := range spec.Env {
+		cmd.Env = append(cmd.Env, os.ExpandEnv(e))
+	}
+
+	var stdout bytes.Buffer
+	cmd.Stdin = bytes.NewReader(resources)
+	cmd.Stdout = &stdout
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		panic(fmt.Errorf("could not pipe command stderr: %v", err))
+	}
+	cfg.Logger().PipeReader(fmt.Sprintf("%s[%d]", logDomain, index), stderr)
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("could not run '%s': %v", spec.Command[0], err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// stackEnv gives the environment variables that describe the stack to
+// the post-render commands.
+func stackEnv(cfg *config.Config, name names.Name, step string) []string {
+	return []string{
+		"WARP_STACK_NAME=" + name.String(),
+		"WARP_STACK_DNS_NAME=" + name.DNSName(),
+		"WARP_STACK_FAMILY=" + name.Family,
+		"WARP_RUN_ID=" + cfg.RunID,
+		"WARP_DEPLOY_STEP=" + step,
+	}
+}
@@ -0,0 +1,73 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package postrender
+
+import (
+	"context"
+	"github.com/hchauvin/warp/pkg/config"
+	"github.com/hchauvin/warp/pkg/pipelines"
+	"github.com/hchauvin/warp/pkg/stacks/names"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExec(t *testing.T) {
+	dir, err := ioutil.TempDir("", "postrender")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	k8sResourcesPath := filepath.Join(dir, "expanded_resources.yml")
+	assert.NoError(t, ioutil.WriteFile(k8sResourcesPath, []byte("name: foo\n"), 0666))
+
+	cfg := &config.Config{WorkspaceDir: dir, RunID: "run"}
+	name := names.Name{Family: "family", ShortName: "0"}
+	os.Setenv("WARP_TEST_SUFFIX", "suffix")
+	defer os.Unsetenv("WARP_TEST_SUFFIX")
+
+	commands := []pipelines.BaseCommand{
+		{Command: []string{"sed", "s/foo/bar/"}},
+		{
+			Command: []string{"sh", "-c", `cat; echo "# $WARP_STACK_NAME $WARP_STACK_DNS_NAME $WARP_STACK_FAMILY $WARP_RUN_ID $WARP_DEPLOY_STEP $EXTRA"`},
+			Env:     []string{"EXTRA=extra-$WARP_TEST_SUFFIX"},
+		},
+	}
+	postRenderedPath, err := Exec(context.Background(), cfg, commands, name, "kustomize", k8sResourcesPath)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "post_rendered_resources.yml"), postRenderedPath)
+
+	b, err := ioutil.ReadFile(postRenderedPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "name: bar\n# family_0 family-0 family run kustomize extra-suffix\n", string(b))
+
+	// The expanded resources are left untouched.
+	b, err = ioutil.ReadFile(k8sResourcesPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "name: foo\n", string(b))
+}
+
+func TestExecNoCommand(t *testing.T) {
+	path, err := Exec(context.Background(), &config.Config{}, nil, names.Name{ShortName: "stack"}, "kustomize", "resources.yml")
+	assert.NoError(t, err)
+	assert.Equal(t, "resources.yml", path)
+}
+
+func TestExecFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "postrender")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	k8sResourcesPath := filepath.Join(dir, "expanded_resources.yml")
+	assert.NoError(t, ioutil.WriteFile(k8sResourcesPath, []byte("name: foo\n"), 0666))
+
+	commands := []pipelines.BaseCommand{
+		{Command: []string{"cat"}},
+		{Command: []string{"false"}},
+	}
+	_, err = Exec(context.Background(), &config.Config{}, commands, names.Name{ShortName: "stack"}, "kustomize", k8sResourcesPath)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "deploy.postRender[1]")
+}
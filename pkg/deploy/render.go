@@ -0,0 +1,162 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"github.com/hchauvin/warp/pkg/config"
+	"github.com/hchauvin/warp/pkg/deploy/container"
+	"github.com/hchauvin/warp/pkg/deploy/helm"
+	"github.com/hchauvin/warp/pkg/deploy/jsonnet"
+	"github.com/hchauvin/warp/pkg/deploy/kustomize"
+	"github.com/hchauvin/warp/pkg/deploy/manifests"
+	"github.com/hchauvin/warp/pkg/deploy/postrender"
+	"github.com/hchauvin/warp/pkg/k8s"
+	"github.com/hchauvin/warp/pkg/pipelines"
+	"github.com/hchauvin/warp/pkg/stacks/names"
+	"io"
+	"io/ioutil"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"os"
+)
+
+// RenderedStep gives the resources of a deploy step, as they would be
+// applied.
+type RenderedStep struct {
+	// Step is the name of the deploy step.
+	Step string
+
+	// ResourcesPath is the path to a YAML file with one resource per
+	// YAML document.
+	ResourcesPath string
+}
+
+// Render expands the resources of the Helm, Kustomize, Manifests, and
+// Jsonnet deploy steps, without applying them.  The Container steps are
+// executed to get the image references: the images are built, and, when
+// push is true, pushed, loaded into a local cluster, and resolved to
+// digests as with a deployment (see container.Exec and container.Build).
+// The Helm releases (see pipelines.Helm.Release) are installed with
+// "helm upgrade" and never expanded, so they are skipped, as are the
+// other steps.  The rendered steps are given in the order of
+// pipelines.Deploy.AllSteps.
+func Render(ctx context.Context, cfg *config.Config, pipeline *pipelines.Pipeline, name names.Name, push bool) ([]RenderedStep, error) {
+	steps := pipeline.Deploy.AllSteps()
+
+	stepsByName := make(map[string]*pipelines.DeployStep, len(steps))
+	stepRefs := make(map[string]container.ImageRefs)
+	for i := range steps {
+		step := &steps[i]
+		stepsByName[step.Name] = step
+		if step.Container == nil {
+			continue
+		}
+		var refs container.ImageRefs
+		var err error
+		if push {
+			refs, err = container.Exec(ctx, cfg, step.Container, name)
+		} else {
+			refs, err = container.Build(ctx, cfg, step.Container, name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("deploy.%s: %v", step.Name, err)
+		}
+		stepRefs[step.Name] = refs
+	}
+
+	var rendered []RenderedStep
+	for i := range steps {
+		step := &steps[i]
+		refs := dependencyRefs(stepsByName, stepRefs, step)
+		var k8sResourcesPath string
+		var err error
+		switch {
+		case step.Helm != nil && step.Helm.Release:
+			continue
+		case step.Helm != nil:
+			k8sResourcesPath, err = helm.ExpandResources(ctx, cfg, step.Helm, name, step.Name, refs)
+		case step.Kustomize != nil:
+			k8sResourcesPath, err = kustomize.ExpandResources(ctx, cfg, step.Kustomize, name, step.Name, refs)
+		case step.Manifests != nil:
+			k8sResourcesPath, err = manifests.ExpandResources(ctx, cfg, step.Manifests, name, step.Name, refs)
+		case step.Jsonnet != nil:
+			k8sResourcesPath, err = jsonnet.ExpandResources(ctx, cfg, step.Jsonnet, name, step.Name, refs)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("deploy.%s: %v", step.Name, err)
+		}
+		k8sResourcesPath, err = postrender.Exec(ctx, cfg, pipeline.Deploy.PostRender, name, step.Name, k8sResourcesPath)
+		if err != nil {
+			return nil, err
+		}
+		// The resources are labeled as they are when applied (see
+		// k8s.K8s.ApplyStack), so that they can be compared with the live
+		// resources.
+		if err := k8s.AddStepLabel(k8sResourcesPath, step.Name); err != nil {
+			return nil, fmt.Errorf("deploy.%s: %v", step.Name, err)
+		}
+		rendered = append(rendered, RenderedStep{
+			Step:          step.Name,
+			ResourcesPath: k8sResourcesPath,
+		})
+	}
+	return rendered, nil
+}
+
+// ReadResources reads the resources of a YAML file with one resource
+// per YAML document.  Empty documents are skipped.
+func ReadResources(resourcesPath string) ([]unstructured.Unstructured, error) {
+	f, err := os.Open(resourcesPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return decodeResources(f, resourcesPath)
+}
+
+func decodeResources(r io.Reader, resourcesPath string) ([]unstructured.Unstructured, error) {
+	var resources []unstructured.Unstructured
+	decoder := utilyaml.NewYAMLOrJSONDecoder(r, 4096)
+	for {
+		var obj map[string]interface{}
+		if err := decoder.Decode(&obj); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("cannot decode resources '%s': %v", resourcesPath, err)
+		}
+		if len(obj) == 0 {
+			continue
+		}
+		resources = append(resources, unstructured.Unstructured{Object: obj})
+	}
+	return resources, nil
+}
+
+// ConcatResources concatenates the resources of rendered steps into
+// one YAML stream.  A comment gives the step each resource comes from.
+func ConcatResources(w io.Writer, rendered []RenderedStep) error {
+	for _, r := range rendered {
+		b, err := ioutil.ReadFile(r.ResourcesPath)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "---\n# Source: deploy.%s\n", r.Step); err != nil {
+			return err
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+		if len(b) > 0 && b[len(b)-1] != '\n' {
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
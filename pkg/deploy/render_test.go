@@ -0,0 +1,78 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"github.com/hchauvin/warp/pkg/config"
+	"github.com/hchauvin/warp/pkg/pipelines"
+	"github.com/hchauvin/warp/pkg/stacks/names"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	dir, err := ioutil.TempDir("", "render")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, ioutil.WriteFile(
+		filepath.Join(dir, "service.yml"),
+		[]byte("apiVersion: v1\nkind: Service\nmetadata:\n  name: api\n"),
+		0666))
+	assert.NoError(t, ioutil.WriteFile(
+		filepath.Join(dir, "config.yml"),
+		[]byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\n"),
+		0666))
+
+	pipeline := &pipelines.Pipeline{
+		Deploy: pipelines.Deploy{
+			Steps: []pipelines.DeployStep{
+				{
+					Name:      "service",
+					DependsOn: []string{"migrate"},
+					Manifests: &pipelines.Manifests{Paths: []string{"service.yml"}},
+				},
+				{
+					Name: "migrate",
+					Run:  &pipelines.BaseCommand{Command: []string{"false"}},
+				},
+				{
+					Name:      "config",
+					Manifests: &pipelines.Manifests{Paths: []string{"config.yml"}},
+				},
+				{
+					Name: "release",
+					Helm: &pipelines.Helm{Path: "chart", Release: true},
+				},
+			},
+		},
+	}
+	cfg := &config.Config{WorkspaceDir: dir, OutputRoot: "out"}
+
+	rendered, err := Render(context.Background(), cfg, pipeline, names.Name{ShortName: "stack"}, false)
+	assert.NoError(t, err)
+	assert.Len(t, rendered, 2)
+	assert.Equal(t, "service", rendered[0].Step)
+	assert.Equal(t, "config", rendered[1].Step)
+
+	resources, err := ReadResources(rendered[0].ResourcesPath)
+	assert.NoError(t, err)
+	assert.Len(t, resources, 1)
+	assert.Equal(t, "Service", resources[0].GetKind())
+	assert.Equal(t, "stack", resources[0].GetLabels()["warp.stack"])
+
+	var out bytes.Buffer
+	assert.NoError(t, ConcatResources(&out, rendered))
+	assert.Contains(t, out.String(), "---\n# Source: deploy.service\n")
+	assert.Contains(t, out.String(), "---\n# Source: deploy.config\n")
+
+	resources, err = decodeResources(&out, "concat")
+	assert.NoError(t, err)
+	assert.Len(t, resources, 2)
+}
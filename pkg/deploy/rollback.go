@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"github.com/hchauvin/warp/pkg/config"
+	"github.com/hchauvin/warp/pkg/k8s"
+	"github.com/hchauvin/warp/pkg/pipelines"
+	"github.com/hchauvin/warp/pkg/stacks/names"
+	"os"
+	"path/filepath"
+)
+
+// SnapshotPath gives the path to the snapshot of the resources of the
+// last successful deployment of a stack.  The snapshot is a YAML file
+// with one resource per YAML document.
+func SnapshotPath(cfg *config.Config, name names.Name) string {
+	return filepath.Join(cfg.Path(cfg.OutputRoot), "snapshots", name.String(), "resources.yml")
+}
+
+// saveSnapshot saves the resources applied by a successful deployment,
+// for later rollbacks.  Nothing is saved when no resource was applied.
+func saveSnapshot(cfg *config.Config, name names.Name, applied []RenderedStep) error {
+	if len(applied) == 0 {
+		return nil
+	}
+
+	path := SnapshotPath(cfg, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+
+	// The snapshot is written to a temporary file first, so that the
+	// previous snapshot is kept if writing fails.
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("cannot create snapshot: %v", err)
+	}
+	if err := ConcatResources(f, applied); err != nil {
+		f.Close()
+		return fmt.Errorf("cannot write snapshot: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("cannot write snapshot: %v", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("cannot write snapshot: %v", err)
+	}
+
+	cfg.Logger().Info(logDomain, "snapshot saved to '%s'", path)
+	return nil
+}
+
+// rollback applies the resources of the last successful deployment of
+// a stack again, after a deployment failed, then executes the readiness
+// hooks.  The returned error reports both the deployment failure and
+// the result of the rollback.
+func rollback(
+	ctx context.Context,
+	cfg *config.Config,
+	pipeline *pipelines.Pipeline,
+	name names.Name,
+	k8sClient *k8s.K8s,
+	deployErr error,
+) error {
+	path := SnapshotPath(cfg, name)
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%v; no previous deployment to roll back to", deployErr)
+		}
+		return fmt.Errorf("%v; rollback failed: %v", deployErr, err)
+	}
+
+	cfg.Logger().Error(logDomain, "%v", deployErr)
+	cfg.Logger().Warning(logDomain, "rolling back to '%s'", path)
+
+	// The resources of all the steps are applied at once, so that
+	// pruning does not remove the resources of the other steps.  The
+	// deploy hashes are deleted as they no longer reflect the resources
+	// of the stack.
+	if err := k8sClient.DeleteDeployHashes(name); err != nil {
+		return fmt.Errorf("%v; rollback failed: %v", deployErr, err)
+	}
+	if err := k8sClient.Apply(ctx, path, k8s.StackLabel+"="+name.DNSName()); err != nil {
+		return fmt.Errorf("%v; rollback failed: %v", deployErr, err)
+	}
+	if err := execReadiness(ctx, cfg, pipeline, name, k8sClient); err != nil {
+		return fmt.Errorf("%v; rollback failed: %v", deployErr, err)
+	}
+
+	return fmt.Errorf("%v; rolled back to the previous deployment", deployErr)
+}
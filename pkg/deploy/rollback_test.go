@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package deploy
+
+import (
+	"context"
+	"github.com/hchauvin/warp/pkg/config"
+	"github.com/hchauvin/warp/pkg/pipelines"
+	"github.com/hchauvin/warp/pkg/stacks/names"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rollback")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg := &config.Config{WorkspaceDir: dir, OutputRoot: "out"}
+	name := names.Name{ShortName: "staging"}
+
+	assert.NoError(t, saveSnapshot(cfg, name, nil))
+	_, err = os.Stat(SnapshotPath(cfg, name))
+	assert.True(t, os.IsNotExist(err))
+
+	resourcesPath := filepath.Join(dir, "resources.yml")
+	assert.NoError(t, ioutil.WriteFile(resourcesPath, []byte("kind: Service\n"), 0666))
+	applied := []RenderedStep{{Step: "manifests", ResourcesPath: resourcesPath}}
+	assert.NoError(t, saveSnapshot(cfg, name, applied))
+
+	b, err := ioutil.ReadFile(SnapshotPath(cfg, name))
+	assert.NoError(t, err)
+	assert.Equal(t, "---\n# Source: deploy.manifests\nkind: Service\n", string(b))
+}
+
+func TestExecReadinessRollback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rollback")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	pipeline := &pipelines.Pipeline{
+		Deploy: pipelines.Deploy{
+			Steps: []pipelines.DeployStep{
+				{
+					Name: "migrate",
+					Run:  &pipelines.BaseCommand{Command: []string{"true"}},
+				},
+			},
+			Readiness: []pipelines.CommandHook{
+				{Run: &pipelines.BaseCommand{Command: []string{"false"}}},
+			},
+		},
+	}
+	cfg := &config.Config{WorkspaceDir: dir, OutputRoot: "out"}
+	name := names.Name{ShortName: "staging"}
+
+	err = Exec(context.Background(), cfg, pipeline, name, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "deploy.readiness: ")
+	assert.NotContains(t, err.Error(), "roll back")
+
+	pipeline.Deploy.Rollback = true
+	err = Exec(context.Background(), cfg, pipeline, name, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "deploy.readiness: ")
+	assert.Contains(t, err.Error(), "no previous deployment to roll back to")
+
+	pipeline.Deploy.Readiness = nil
+	assert.NoError(t, Exec(context.Background(), cfg, pipeline, name, nil))
+}
@@ -0,0 +1,33 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+// Package templates gives the data available to the templates of the
+// deploy steps that expand their resources from Go templates.
+package templates
+
+import (
+	"github.com/hchauvin/warp/pkg/config"
+	"github.com/hchauvin/warp/pkg/deploy/container"
+	"github.com/hchauvin/warp/pkg/stacks/names"
+)
+
+// Data is the data available to the templates of the Kustomize overlays
+// and of the manifests.
+type Data struct {
+	StackName string
+	DNSName   string
+	Family    string
+	RunID     string
+	Images    container.ImageRefs
+}
+
+// NewData gives the template data for a stack.
+func NewData(cfg *config.Config, name names.Name, imageRefs container.ImageRefs) *Data {
+	return &Data{
+		StackName: name.String(),
+		DNSName:   name.DNSName(),
+		Family:    name.Family,
+		RunID:     cfg.RunID,
+		Images:    imageRefs,
+	}
+}
@@ -0,0 +1,26 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package templates
+
+import (
+	"github.com/hchauvin/warp/pkg/config"
+	"github.com/hchauvin/warp/pkg/deploy/container"
+	"github.com/hchauvin/warp/pkg/stacks/names"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNewData(t *testing.T) {
+	cfg := &config.Config{RunID: "run"}
+	name := names.Name{Family: "family", ShortName: "0"}
+	images := container.ImageRefs{"api": "api:tag"}
+
+	assert.Equal(t, &Data{
+		StackName: name.String(),
+		DNSName:   name.DNSName(),
+		Family:    "family",
+		RunID:     "run",
+		Images:    images,
+	}, NewData(cfg, name, images))
+}
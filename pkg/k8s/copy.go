@@ -0,0 +1,192 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package k8s
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// CopyToPod copies a local file or folder to a container.  The remote
+// path is the path of the copy in the container, whose parent folder must
+// exist.  As with "kubectl cp", the container must have a "tar" binary.
+func (k8s *K8s) CopyToPod(
+	ctx context.Context,
+	namespace string,
+	podName string,
+	containerName string,
+	localPath string,
+	remotePath string,
+) error {
+	remotePath = path.Clean(remotePath)
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeTar(pw, localPath, path.Base(remotePath)))
+	}()
+	defer pr.Close()
+
+	var stderr bytes.Buffer
+	err := k8s.Exec(
+		ctx,
+		namespace,
+		podName,
+		containerName,
+		[]string{"tar", "-xmf", "-", "-C", path.Dir(remotePath)},
+		pr,
+		nil,
+		&stderr,
+	)
+	if err != nil {
+		return fmt.Errorf("cannot copy '%s' to %s:%s: %v; stderr: <<< %s >>>", localPath, podName, remotePath, err, stderr.String())
+	}
+	return nil
+}
+
+// CopyFromPod copies a file or folder from a container to a local path.
+// As with "kubectl cp", the container must have a "tar" binary.
+func (k8s *K8s) CopyFromPod(
+	ctx context.Context,
+	namespace string,
+	podName string,
+	containerName string,
+	remotePath string,
+	localPath string,
+) error {
+	remotePath = path.Clean(remotePath)
+	pr, pw := io.Pipe()
+	extracted := make(chan error, 1)
+	go func() {
+		err := extractTar(pr, path.Base(remotePath), localPath)
+		// Drain the pipe so that the exec stream is not blocked.
+		io.Copy(ioutil.Discard, pr)
+		extracted <- err
+	}()
+
+	var stderr bytes.Buffer
+	err := k8s.Exec(
+		ctx,
+		namespace,
+		podName,
+		containerName,
+		[]string{"tar", "-cf", "-", "-C", path.Dir(remotePath), path.Base(remotePath)},
+		nil,
+		pw,
+		&stderr,
+	)
+	pw.CloseWithError(err)
+	if err != nil {
+		return fmt.Errorf("cannot copy %s:%s to '%s': %v; stderr: <<< %s >>>", podName, remotePath, localPath, err, stderr.String())
+	}
+	if err := <-extracted; err != nil {
+		return fmt.Errorf("cannot copy %s:%s to '%s': %v", podName, remotePath, localPath, err)
+	}
+	return nil
+}
+
+// writeTar writes a tar archive of a local file or folder.  In the
+// archive, the file or folder is renamed to the given name.  Only
+// regular files and folders are archived.
+func writeTar(w io.Writer, localPath string, name string) error {
+	tw := tar.NewWriter(w)
+	err := filepath.Walk(localPath, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() && !info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(localPath, p)
+		if err != nil {
+			return err
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = path.Join(name, filepath.ToSlash(rel))
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// extractTar extracts a tar archive to a local path.  The entries of the
+// archive must be the given name, or under the given name, which is
+// replaced by the local path.  Only regular files and folders are
+// extracted.
+func extractTar(r io.Reader, name string, localPath string) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		entry := path.Clean(header.Name)
+		var dest string
+		if entry == name {
+			dest = localPath
+		} else if strings.HasPrefix(entry, name+"/") {
+			dest = filepath.Join(localPath, filepath.FromSlash(entry[len(name)+1:]))
+		} else {
+			return fmt.Errorf("unexpected archive entry '%s'", header.Name)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(dest, 0777); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := extractFile(tr, dest, os.FileMode(header.Mode).Perm()); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func extractFile(r io.Reader, dest string, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0777); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,67 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package k8s
+
+import (
+	"archive/tar"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTarRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "copy")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	assert.NoError(t, os.MkdirAll(filepath.Join(src, "sub"), 0777))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(src, "a.txt"), []byte("a"), 0666))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(src, "sub", "b.txt"), []byte("b"), 0666))
+
+	var archive bytes.Buffer
+	assert.NoError(t, writeTar(&archive, src, "fixtures"))
+
+	dest := filepath.Join(dir, "dest")
+	assert.NoError(t, extractTar(&archive, "fixtures", dest))
+
+	a, err := ioutil.ReadFile(filepath.Join(dest, "a.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "a", string(a))
+	b, err := ioutil.ReadFile(filepath.Join(dest, "sub", "b.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "b", string(b))
+}
+
+func TestTarRoundTripFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "copy")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "coverage.out")
+	assert.NoError(t, ioutil.WriteFile(src, []byte("mode: set"), 0666))
+
+	var archive bytes.Buffer
+	assert.NoError(t, writeTar(&archive, src, "cover.out"))
+
+	dest := filepath.Join(dir, "out", "coverage.txt")
+	assert.NoError(t, extractTar(&archive, "cover.out", dest))
+
+	content, err := ioutil.ReadFile(dest)
+	assert.NoError(t, err)
+	assert.Equal(t, "mode: set", string(content))
+}
+
+func TestExtractTarUnexpectedEntry(t *testing.T) {
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	assert.NoError(t, tw.WriteHeader(&tar.Header{Name: "fixtures/../../etc/passwd", Typeflag: tar.TypeReg}))
+	assert.NoError(t, tw.Close())
+
+	err := extractTar(&archive, "fixtures", "/nonexistent")
+	assert.EqualError(t, err, "unexpected archive entry 'fixtures/../../etc/passwd'")
+}
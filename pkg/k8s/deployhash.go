@@ -0,0 +1,158 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package k8s
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/hchauvin/warp/pkg/stacks/names"
+	"io/ioutil"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sort"
+	"strings"
+)
+
+const deployHashKey = "hash"
+
+// ApplyStack applies the resources of a deploy step of a stack, like
+// Apply, unless the same resources, with the same image references, were
+// already applied to the stack.  The hash of what was applied is recorded
+// in a ConfigMap.  Config.ForceDeploy disables the check.  The resources
+// are given the step label (see AddStepLabel), and only the resources
+// that match both the label selector and the step label are pruned.
+func (k8s *K8s) ApplyStack(
+	ctx context.Context,
+	name names.Name,
+	step string,
+	resourcesPath string,
+	labelSelector string,
+	imageRefs map[string]string,
+) error {
+	if err := AddStepLabel(resourcesPath, step); err != nil {
+		return err
+	}
+	hash, err := DeployHash(resourcesPath, imageRefs)
+	if err != nil {
+		return err
+	}
+
+	configMapName := DeployHashConfigMapName(name, step)
+	if !k8s.cfg.ForceDeploy {
+		prevHash, err := k8s.deployHash(configMapName)
+		if err != nil {
+			return err
+		}
+		if prevHash == hash {
+			k8s.cfg.Logger().Info(
+				logDomain,
+				"%s|%s: resources unchanged; skipping apply (use --force_deploy to override)",
+				name.DNSName(),
+				step)
+			return nil
+		}
+	}
+
+	if err := k8s.Apply(ctx, resourcesPath, labelSelector+","+StepLabel+"="+step); err != nil {
+		return err
+	}
+
+	return k8s.setDeployHash(name, configMapName, hash)
+}
+
+// DeployHash hashes resources, given by a path to a file, and the image
+// references they use.
+func DeployHash(resourcesPath string, imageRefs map[string]string) (string, error) {
+	b, err := ioutil.ReadFile(resourcesPath)
+	if err != nil {
+		return "", fmt.Errorf("cannot read resources '%s': %v", resourcesPath, err)
+	}
+
+	h := sha256.New()
+	h.Write(b)
+	keys := make([]string, 0, len(imageRefs))
+	for k := range imageRefs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(h, "\x00%s=%s", k, imageRefs[k])
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// DeployHashConfigMapName gives the name of the ConfigMap that records
+// the hash of the resources applied by a deploy step of a stack.
+func DeployHashConfigMapName(name names.Name, step string) string {
+	return name.DNSName() + "-warp-deploy-" + stepDNSName(step)
+}
+
+// stepDNSName gives a form of a deploy step name that can be part of a
+// DNS-1123 name: step names can have uppercase letters and "_", which
+// are lowercased and replaced by "-", respectively.
+func stepDNSName(step string) string {
+	return strings.ToLower(strings.ReplaceAll(step, "_", "-"))
+}
+
+// deployHash gives the hash recorded in a ConfigMap, or an empty string
+// if there is no such ConfigMap.
+func (k8s *K8s) deployHash(configMapName string) (string, error) {
+	configMap, err := k8s.Clientset.CoreV1().ConfigMaps(StackNamespace).Get(configMapName, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("cannot get ConfigMap '%s': %v", configMapName, err)
+	}
+	return configMap.Data[deployHashKey], nil
+}
+
+// setDeployHash records a hash in a ConfigMap, creating it if necessary.
+func (k8s *K8s) setDeployHash(name names.Name, configMapName string, hash string) error {
+	api := k8s.Clientset.CoreV1().ConfigMaps(StackNamespace)
+	configMap, err := api.Get(configMapName, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("cannot get ConfigMap '%s': %v", configMapName, err)
+		}
+		_, err = api.Create(&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   configMapName,
+				Labels: map[string]string{DeployHashLabel: name.DNSName()},
+			},
+			Data: map[string]string{deployHashKey: hash},
+		})
+		if err != nil {
+			return fmt.Errorf("cannot create ConfigMap '%s': %v", configMapName, err)
+		}
+		return nil
+	}
+
+	if configMap.Data == nil {
+		configMap.Data = make(map[string]string)
+	}
+	configMap.Data[deployHashKey] = hash
+	if _, err := api.Update(configMap); err != nil {
+		return fmt.Errorf("cannot update ConfigMap '%s': %v", configMapName, err)
+	}
+	return nil
+}
+
+// DeleteDeployHashes deletes the ConfigMaps that record the hashes of
+// the resources applied to a stack, so that they are applied again when
+// the stack is reused.
+func (k8s *K8s) DeleteDeployHashes(name names.Name) error {
+	err := k8s.Clientset.CoreV1().ConfigMaps(StackNamespace).DeleteCollection(
+		nil,
+		metav1.ListOptions{
+			LabelSelector: Labels{DeployHashLabel: name.DNSName()}.String(),
+		})
+	if err != nil {
+		return fmt.Errorf("cannot delete deploy hashes: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package k8s
+
+import (
+	"github.com/hchauvin/warp/pkg/stacks/names"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDeployHash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deployhash")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "expanded_resources.yml")
+	assert.NoError(t, ioutil.WriteFile(path, []byte("kind: Service\n"), 0666))
+
+	refs := map[string]string{"api": "api:1", "web": "web:1"}
+	hash, err := DeployHash(path, refs)
+	assert.NoError(t, err)
+	assert.Len(t, hash, 64)
+
+	same, err := DeployHash(path, map[string]string{"web": "web:1", "api": "api:1"})
+	assert.NoError(t, err)
+	assert.Equal(t, hash, same)
+
+	otherRefs, err := DeployHash(path, map[string]string{"api": "api:2", "web": "web:1"})
+	assert.NoError(t, err)
+	assert.NotEqual(t, hash, otherRefs)
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte("kind: Deployment\n"), 0666))
+	otherResources, err := DeployHash(path, refs)
+	assert.NoError(t, err)
+	assert.NotEqual(t, hash, otherResources)
+
+	_, err = DeployHash(filepath.Join(dir, "missing.yml"), nil)
+	assert.Error(t, err)
+}
+
+func TestDeployHashConfigMapName(t *testing.T) {
+	name := names.Name{Family: "foo", ShortName: "bar"}
+	assert.Equal(t, name.DNSName()+"-warp-deploy-helm", DeployHashConfigMapName(name, "helm"))
+	// The step names with uppercase letters and "_" give the same valid
+	// DNS-1123 names as for the Helm releases.
+	assert.Equal(t, name.DNSName()+"-warp-deploy-my-step", DeployHashConfigMapName(name, "my_Step"))
+	assert.Empty(t, validation.IsDNS1123Subdomain(DeployHashConfigMapName(name, "my_Step")))
+	assert.Empty(t, validation.IsDNS1123Subdomain(helmReleaseConfigMapName(HelmReleaseName(name, "my_Step"))))
+}
@@ -0,0 +1,143 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"io"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+	"time"
+)
+
+// ServicePod gives a ready pod of the service that matches a spec, and
+// a ready container of this pod.  If the container name is empty, the
+// first ready container is chosen.  ServicePod waits until such a pod is
+// found, or the context is done.
+func (k8s *K8s) ServicePod(ctx context.Context, service ServiceSpec, container string) (podName, containerName string, err error) {
+	for {
+		podName, containerName, err := k8s.findServicePod(service, container)
+		if err != nil {
+			return "", "", err
+		}
+		if podName != "" {
+			return podName, containerName, nil
+		}
+		k8s.cfg.Logger().Info(logDomain, "exec: no ready pod for service %s", service)
+
+		select {
+		case <-time.After(2 * time.Second):
+		case <-ctx.Done():
+			return "", "", fmt.Errorf("no ready pod for service %s: %v", service, ctx.Err())
+		}
+	}
+}
+
+func (k8s *K8s) findServicePod(service ServiceSpec, container string) (podName, containerName string, err error) {
+	services, err := k8s.Clientset.CoreV1().Services(service.Namespace).List(metav1.ListOptions{
+		LabelSelector: service.Labels,
+	})
+	if err != nil {
+		return "", "", err
+	}
+	if len(services.Items) != 1 {
+		return "", "", fmt.Errorf("expected one and only one service to match spec %v, got %d", service, len(services.Items))
+	}
+	selector := services.Items[0].Spec.Selector
+	if len(selector) == 0 {
+		return "", "", fmt.Errorf("service %s|%s has no pod selector", service.Namespace, services.Items[0].Name)
+	}
+
+	pods, err := k8s.Clientset.CoreV1().Pods(service.Namespace).List(metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(selector).String(),
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return readyContainer(pods.Items, container)
+}
+
+// readyContainer gives the first ready container among some pods.  If
+// the container name is not empty, only the containers with this name
+// are considered.  Empty names are returned if no container is ready.
+func readyContainer(pods []corev1.Pod, container string) (podName, containerName string, err error) {
+	for _, pod := range pods {
+		if pod.Status.Phase != corev1.PodRunning || pod.DeletionTimestamp != nil {
+			continue
+		}
+		if container != "" && !hasContainer(&pod, container) {
+			return "", "", fmt.Errorf("pod %s has no container named '%s'", pod.Name, container)
+		}
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.Ready && (container == "" || status.Name == container) {
+				return pod.Name, status.Name, nil
+			}
+		}
+	}
+	return "", "", nil
+}
+
+func hasContainer(pod *corev1.Pod, container string) bool {
+	for _, c := range pod.Spec.Containers {
+		if c.Name == container {
+			return true
+		}
+	}
+	return false
+}
+
+// Exec executes a command in a container through the "exec" subresource.
+// The standard input is optional.  An error is returned if the command
+// exits with a non-zero status.  When the context is done, Exec returns
+// without waiting for the command to terminate.
+func (k8s *K8s) Exec(
+	ctx context.Context,
+	namespace string,
+	podName string,
+	containerName string,
+	command []string,
+	stdin io.Reader,
+	stdout io.Writer,
+	stderr io.Writer,
+) error {
+	req := k8s.Clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Name(podName).
+		Namespace(namespace).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: containerName,
+			Command:   command,
+			Stdin:     stdin != nil,
+			Stdout:    stdout != nil,
+			Stderr:    stderr != nil,
+		}, scheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(k8s.restconfig, "POST", req.URL())
+	if err != nil {
+		return err
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- executor.Stream(remotecommand.StreamOptions{
+			Stdin:  stdin,
+			Stdout: stdout,
+			Stderr: stderr,
+		})
+	}()
+	select {
+	case err := <-errc:
+		if err != nil {
+			return fmt.Errorf("exec in %s|%s[%s]: %v", namespace, podName, containerName, err)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,53 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package k8s
+
+import (
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+)
+
+func testPod(name string, phase corev1.PodPhase, ready map[string]bool) corev1.Pod {
+	pod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status:     corev1.PodStatus{Phase: phase},
+	}
+	for _, container := range []string{"app", "sidecar"} {
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: container})
+		pod.Status.ContainerStatuses = append(pod.Status.ContainerStatuses, corev1.ContainerStatus{
+			Name:  container,
+			Ready: ready[container],
+		})
+	}
+	return pod
+}
+
+func TestReadyContainer(t *testing.T) {
+	pods := []corev1.Pod{
+		testPod("pending", corev1.PodPending, nil),
+		testPod("starting", corev1.PodRunning, map[string]bool{"sidecar": true}),
+		testPod("ready", corev1.PodRunning, map[string]bool{"app": true, "sidecar": true}),
+	}
+
+	pod, container, err := readyContainer(pods, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "starting", pod)
+	assert.Equal(t, "sidecar", container)
+
+	pod, container, err = readyContainer(pods, "app")
+	assert.NoError(t, err)
+	assert.Equal(t, "ready", pod)
+	assert.Equal(t, "app", container)
+
+	pod, container, err = readyContainer(pods[:2], "app")
+	assert.NoError(t, err)
+	assert.Equal(t, "", pod)
+	assert.Equal(t, "", container)
+
+	_, _, err = readyContainer(pods, "unknown")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "pod starting has no container named 'unknown'")
+}
@@ -9,11 +9,17 @@ import (
 	"github.com/hchauvin/warp/pkg/config"
 	"github.com/hchauvin/warp/pkg/stacks/names"
 	"golang.org/x/sync/errgroup"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/dynamic"
 )
 
+// helmReleaseKey is the key of the release name in the ConfigMaps that
+// record the Helm releases of a stack.
+const helmReleaseKey = "release"
+
 // gcResources lists the resources that are garbage-collected by default.
 var gcResources = []config.Resource{
 	{
@@ -88,40 +94,29 @@ type GcOptions struct {
 
 // Gc garbage-collects resources that pertain to a given stack.
 func (k8s *K8s) Gc(ctx context.Context, cfg *config.Config, name names.Name, options *GcOptions) error {
-	namespace := "default"
+	namespace := StackNamespace
 	labelSelector := Labels{
 		StackLabel: name.DNSName(),
 	}.String()
 
-	var g errgroup.Group
-	var resources []config.Resource
-	resources = append(resources, gcResources...)
-	if !options.PreservePersistentVolumeClaims {
-		resources = append(resources, gcResourcesVolumes...)
+	// The Helm releases must be uninstalled first, for the chart hooks
+	// to execute.
+	if err := k8s.uninstallHelmReleases(ctx, namespace, name); err != nil {
+		return err
 	}
-	if k8s.cfg.Kubernetes != nil {
-		resources = append(resources, k8s.cfg.Kubernetes.Resources...)
+
+	// The deploy hashes are deleted before the resources, so that the
+	// resources are applied again even if the garbage collection fails
+	// midway.
+	if err := k8s.DeleteDeployHashes(name); err != nil {
+		return err
 	}
-	for _, res := range resources {
+
+	var g errgroup.Group
+	for _, res := range k8s.stackResourceTypes(!options.PreservePersistentVolumeClaims) {
 		res := res
 		g.Go(func() error {
-			var api dynamic.ResourceInterface
-			if res.Namespaced {
-				api = k8s.DynClient.
-					Resource(schema.GroupVersionResource{
-						Group:    res.Group,
-						Version:  res.Version,
-						Resource: res.Resource,
-					}).
-					Namespace(namespace)
-			} else {
-				api = k8s.DynClient.
-					Resource(schema.GroupVersionResource{
-						Group:    res.Group,
-						Version:  res.Version,
-						Resource: res.Resource,
-					})
-			}
+			api := k8s.resourceInterface(res, namespace)
 			list, err := api.List(metav1.ListOptions{
 				LabelSelector: labelSelector,
 			})
@@ -145,3 +140,110 @@ func (k8s *K8s) Gc(ctx context.Context, cfg *config.Config, name names.Name, opt
 	}
 	return g.Wait()
 }
+
+// stackResourceTypes gives the types of the resources that are garbage-collected.
+func (k8s *K8s) stackResourceTypes(volumes bool) []config.Resource {
+	var resources []config.Resource
+	resources = append(resources, gcResources...)
+	if volumes {
+		resources = append(resources, gcResourcesVolumes...)
+	}
+	if k8s.cfg.Kubernetes != nil {
+		resources = append(resources, k8s.cfg.Kubernetes.Resources...)
+	}
+	return resources
+}
+
+// resourceInterface gives the dynamic client for a resource type.
+func (k8s *K8s) resourceInterface(res config.Resource, namespace string) dynamic.ResourceInterface {
+	api := k8s.DynClient.Resource(schema.GroupVersionResource{
+		Group:    res.Group,
+		Version:  res.Version,
+		Resource: res.Resource,
+	})
+	if res.Namespaced {
+		return api.Namespace(namespace)
+	}
+	return api
+}
+
+// HelmReleaseName gives the name of the Helm release of a deploy step of
+// a stack.
+func HelmReleaseName(name names.Name, step string) string {
+	return name.DNSName() + "-" + stepDNSName(step)
+}
+
+// helmReleaseConfigMapName gives the name of the ConfigMap that records
+// a Helm release.
+func helmReleaseConfigMapName(release string) string {
+	return release + "-warp-release"
+}
+
+// RecordHelmRelease records a Helm release of a stack in a ConfigMap, so
+// that it is uninstalled by the garbage collection (see Gc).  It must be
+// called before the release is installed.
+func (k8s *K8s) RecordHelmRelease(name names.Name, release string) error {
+	configMapName := helmReleaseConfigMapName(release)
+	api := k8s.Clientset.CoreV1().ConfigMaps(StackNamespace)
+	_, err := api.Create(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   configMapName,
+			Labels: map[string]string{HelmReleaseLabel: name.DNSName()},
+		},
+		Data: map[string]string{helmReleaseKey: release},
+	})
+	if err != nil && !errors.IsAlreadyExists(err) {
+		return fmt.Errorf("cannot create ConfigMap '%s': %v", configMapName, err)
+	}
+	return nil
+}
+
+// uninstallHelmReleases uninstalls the Helm releases recorded for a stack
+// (see RecordHelmRelease), then deletes the records.
+func (k8s *K8s) uninstallHelmReleases(ctx context.Context, namespace string, name names.Name) error {
+	api := k8s.Clientset.CoreV1().ConfigMaps(StackNamespace)
+	listOptions := metav1.ListOptions{
+		LabelSelector: Labels{HelmReleaseLabel: name.DNSName()}.String(),
+	}
+	records, err := api.List(listOptions)
+	if err != nil {
+		return fmt.Errorf("cannot list Helm release records: %v", err)
+	}
+	for _, record := range records.Items {
+		if err := k8s.uninstallHelmRelease(ctx, namespace, record.Data[helmReleaseKey]); err != nil {
+			return err
+		}
+	}
+	if err := api.DeleteCollection(nil, listOptions); err != nil {
+		return fmt.Errorf("cannot delete Helm release records: %v", err)
+	}
+	return nil
+}
+
+// uninstallHelmRelease uninstalls a Helm release, if it exists.  The
+// releases are detected with the secrets Helm uses to store them, so
+// that the Helm CLI is only required when there is a release.
+func (k8s *K8s) uninstallHelmRelease(ctx context.Context, namespace, release string) error {
+	secrets, err := k8s.Clientset.CoreV1().Secrets(namespace).List(metav1.ListOptions{
+		LabelSelector: Labels{
+			"owner": "helm",
+			"name":  release,
+		}.String(),
+	})
+	if err != nil {
+		return fmt.Errorf("cannot list Helm releases: %v", err)
+	}
+	if len(secrets.Items) == 0 {
+		return nil
+	}
+
+	cmd, err := k8s.HelmCommandContext(ctx, "uninstall", release, "--namespace", namespace)
+	if err != nil {
+		return err
+	}
+	k8s.cfg.Logger().Pipe(config.Helm.LogDomain(), cmd)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("could not uninstall Helm release '%s': %v", release, err)
+	}
+	return nil
+}
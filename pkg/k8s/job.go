@@ -0,0 +1,222 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package k8s
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/hchauvin/warp/pkg/stacks/names"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strings"
+	"time"
+)
+
+// jobLogLines is the number of lines of logs kept to report a failed job.
+const jobLogLines = 100
+
+// JobSpec specifies a Kubernetes Job to run in a stack.
+type JobSpec struct {
+	// Name identifies the job within the stack.  It is optional, and is
+	// only used to give the Job a more readable name.
+	Name string
+
+	// Image is the container image to run.
+	Image string
+
+	// Command is the entrypoint of the container.  If it is empty, the
+	// entrypoint of the image is used.
+	Command []string
+
+	// Env is the environment of the container.
+	Env []corev1.EnvVar
+
+	// ServiceAccountName is the service account to run the pod as.  If
+	// it is empty, the default service account is used.
+	ServiceAccountName string
+}
+
+// RunJob runs a Job in a stack and waits for its completion.  The logs of
+// the pod are piped to the logger under the given log domain.  If the job
+// fails, the last lines of the logs are part of the error.  The Job is
+// deleted once it has completed, or when the context is done.
+func (k8s *K8s) RunJob(ctx context.Context, name names.Name, spec *JobSpec, logDomain string) error {
+	jobs := k8s.Clientset.BatchV1().Jobs(StackNamespace)
+	job, err := jobs.Create(jobObject(name, spec))
+	if err != nil {
+		return fmt.Errorf("could not create job: %v", err)
+	}
+	defer func() {
+		propagation := metav1.DeletePropagationBackground
+		if err := jobs.Delete(job.Name, &metav1.DeleteOptions{PropagationPolicy: &propagation}); err != nil {
+			k8s.cfg.Logger().Warning(logDomain, "could not delete job %s: %v", job.Name, err)
+		}
+	}()
+	k8s.cfg.Logger().Info(logDomain, "job %s created", job.Name)
+
+	logs := &logTail{max: jobLogLines}
+	podName, err := k8s.waitForJobPod(ctx, job.Name)
+	if err != nil {
+		return fmt.Errorf("job %s: %v", job.Name, err)
+	}
+	if podName != "" {
+		if err := k8s.streamPodLogs(ctx, podName, logDomain, logs); err != nil {
+			k8s.cfg.Logger().Warning(logDomain, "cannot stream the logs of pod %s: %v", podName, err)
+		}
+	}
+
+	for {
+		status, err := jobs.Get(job.Name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("could not get job %s: %v", job.Name, err)
+		}
+		if status.Status.Succeeded > 0 {
+			return nil
+		}
+		if status.Status.Failed > 0 {
+			return fmt.Errorf("job %s failed; last lines of the logs:\n%s", job.Name, logs)
+		}
+
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return fmt.Errorf("job %s: %v; last lines of the logs:\n%s", job.Name, ctx.Err(), logs)
+		}
+	}
+}
+
+// jobObject gives the Job resource for a job spec.  The Job and its pod
+// are labeled with the stack, and the pod is never restarted.
+func jobObject(name names.Name, spec *JobSpec) *batchv1.Job {
+	labels := map[string]string{
+		StackLabel: name.DNSName(),
+	}
+
+	prefix := name.DNSName() + "-job"
+	if spec.Name != "" {
+		prefix = name.DNSName() + "-" + strings.ToLower(strings.ReplaceAll(spec.Name, "_", "-"))
+	}
+	// The Job name is used as the value of the "job-name" label of the pod,
+	// which is limited to 63 characters.  Five random characters are appended
+	// to the generated name.
+	if len(prefix) > 57 {
+		prefix = prefix[:57]
+	}
+	prefix = strings.TrimRight(prefix, "-") + "-"
+
+	var backoffLimit int32
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: prefix,
+			Namespace:    StackNamespace,
+			Labels:       labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy:      corev1.RestartPolicyNever,
+					ServiceAccountName: spec.ServiceAccountName,
+					Containers: []corev1.Container{
+						{
+							Name:    "job",
+							Image:   spec.Image,
+							Command: spec.Command,
+							Env:     spec.Env,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// waitForJobPod waits for the pod of a job to leave the pending phase,
+// and returns its name.  An empty name is returned if the job completed
+// before a pod could be found.
+func (k8s *K8s) waitForJobPod(ctx context.Context, jobName string) (string, error) {
+	for {
+		pods, err := k8s.Clientset.CoreV1().Pods(StackNamespace).List(metav1.ListOptions{
+			LabelSelector: Labels{"job-name": jobName}.String(),
+		})
+		if err != nil {
+			return "", fmt.Errorf("could not list pods: %v", err)
+		}
+		for _, pod := range pods.Items {
+			if pod.Status.Phase != corev1.PodPending {
+				return pod.Name, nil
+			}
+		}
+
+		job, err := k8s.Clientset.BatchV1().Jobs(StackNamespace).Get(jobName, metav1.GetOptions{})
+		if err != nil {
+			return "", fmt.Errorf("could not get job: %v", err)
+		}
+		if job.Status.Succeeded > 0 || job.Status.Failed > 0 {
+			return "", nil
+		}
+
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return "", fmt.Errorf("pod not started: %v", ctx.Err())
+		}
+	}
+}
+
+// streamPodLogs follows the logs of a pod until its container terminates.
+// Each line is logged, and kept in a tail.
+func (k8s *K8s) streamPodLogs(ctx context.Context, podName string, logDomain string, logs *logTail) error {
+	stream, err := k8s.Clientset.CoreV1().Pods(StackNamespace).
+		GetLogs(podName, &corev1.PodLogOptions{Follow: true}).
+		Stream()
+	if err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			stream.Close()
+		case <-done:
+		}
+	}()
+	defer stream.Close()
+
+	scanner := bufio.NewScanner(stream)
+	for scanner.Scan() {
+		line := scanner.Text()
+		k8s.cfg.Logger().Info(logDomain, "%s", line)
+		logs.add(line)
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		return scanner.Err()
+	}
+}
+
+// logTail keeps the last lines of some logs.
+type logTail struct {
+	max   int
+	lines []string
+}
+
+func (t *logTail) add(line string) {
+	t.lines = append(t.lines, line)
+	if len(t.lines) > t.max {
+		t.lines = t.lines[len(t.lines)-t.max:]
+	}
+}
+
+func (t *logTail) String() string {
+	return strings.Join(t.lines, "\n")
+}
@@ -0,0 +1,55 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package k8s
+
+import (
+	"github.com/hchauvin/warp/pkg/stacks/names"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"strings"
+	"testing"
+)
+
+func TestJobObject(t *testing.T) {
+	name := names.Name{Family: "foo", ShortName: "bar"}
+	job := jobObject(name, &JobSpec{
+		Name:               "Seed_DB",
+		Image:              "migrate:1",
+		Command:            []string{"migrate", "up"},
+		Env:                []corev1.EnvVar{{Name: "DB", Value: "db:5432"}},
+		ServiceAccountName: "migrator",
+	})
+
+	assert.Equal(t, "foo-bar-seed-db-", job.GenerateName)
+	assert.Equal(t, StackNamespace, job.Namespace)
+	assert.Equal(t, "foo-bar", job.Labels[StackLabel])
+	assert.Equal(t, "foo-bar", job.Spec.Template.Labels[StackLabel])
+	assert.Equal(t, int32(0), *job.Spec.BackoffLimit)
+
+	pod := job.Spec.Template.Spec
+	assert.Equal(t, corev1.RestartPolicyNever, pod.RestartPolicy)
+	assert.Equal(t, "migrator", pod.ServiceAccountName)
+	assert.Len(t, pod.Containers, 1)
+	assert.Equal(t, "migrate:1", pod.Containers[0].Image)
+	assert.Equal(t, []string{"migrate", "up"}, pod.Containers[0].Command)
+	assert.Equal(t, []corev1.EnvVar{{Name: "DB", Value: "db:5432"}}, pod.Containers[0].Env)
+}
+
+func TestJobObjectName(t *testing.T) {
+	name := names.Name{Family: "foo", ShortName: "bar"}
+	assert.Equal(t, "foo-bar-job-", jobObject(name, &JobSpec{}).GenerateName)
+
+	long := jobObject(name, &JobSpec{Name: strings.Repeat("a", 100)}).GenerateName
+	assert.Len(t, long, 58)
+	assert.True(t, strings.HasSuffix(long, "a-"))
+}
+
+func TestLogTail(t *testing.T) {
+	logs := &logTail{max: 2}
+	logs.add("a")
+	assert.Equal(t, "a", logs.String())
+	logs.add("b")
+	logs.add("c")
+	assert.Equal(t, "b\nc", logs.String())
+}
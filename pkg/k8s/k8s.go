@@ -9,10 +9,13 @@ import (
 	"fmt"
 	"github.com/hchauvin/warp/pkg/config"
 	"github.com/hchauvin/warp/pkg/proc"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/discovery/cached/memory"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
 	"k8s.io/client-go/tools/clientcmd"
 	"os"
 	"os/exec"
@@ -32,6 +35,7 @@ type K8s struct {
 	Clientset  *kubernetes.Clientset
 	DynClient  dynamic.Interface
 	restconfig *rest.Config
+	restMapper meta.RESTMapper
 	Ports      *Ports
 }
 
@@ -82,6 +86,8 @@ func New(cfg *config.Config) (*K8s, error) {
 		Clientset:  clientset,
 		DynClient:  dynClient,
 		restconfig: restconfig,
+		restMapper: restmapper.NewDeferredDiscoveryRESTMapper(
+			memory.NewMemCacheClient(clientset.Discovery())),
 	}
 	client.Ports = newPorts(client)
 	return client, nil
@@ -121,14 +127,28 @@ func (k8s *K8s) KubectlCommandContext(ctx context.Context, args ...string) (*exe
 // or a command that behave similarly concerning the "--context" option
 // and the "KUBECONFIG" environment variable.
 func (k8s *K8s) KubectlLikeCommandContext(ctx context.Context, command string, args ...string) (*exec.Cmd, error) {
+	return k8s.commandContext(ctx, "--context", command, args...), nil
+}
+
+// HelmCommandContext returns a command object to call the helm command.
+// Helm uses the same Kubernetes context and kubeconfig as kubectl.
+func (k8s *K8s) HelmCommandContext(ctx context.Context, args ...string) (*exec.Cmd, error) {
+	helmPath, err := k8s.cfg.ToolPath(config.Helm)
+	if err != nil {
+		return nil, err
+	}
+
+	return k8s.commandContext(ctx, "--kube-context", helmPath, args...), nil
+}
+
+func (k8s *K8s) commandContext(ctx context.Context, contextFlag string, command string, args ...string) *exec.Cmd {
 	kcfg := k8s.cfg.Kubernetes
 	if kcfg == nil {
-		cmd := proc.GracefulCommandContext(ctx, command, args...)
-		return cmd, nil
+		return proc.GracefulCommandContext(ctx, command, args...)
 	}
 
 	if kcfg.DefaultContext != "" {
-		args = append([]string{"--context", kcfg.DefaultContext}, args...)
+		args = append([]string{contextFlag, kcfg.DefaultContext}, args...)
 	}
 	cmd := proc.GracefulCommandContext(ctx, command, args...)
 
@@ -139,5 +159,5 @@ func (k8s *K8s) KubectlLikeCommandContext(ctx context.Context, command string, a
 		cmd.Env = append(cmd.Env, "KUBECONFIG="+kcfg.KubeconfigEnvVar)
 	}
 
-	return cmd, nil
+	return cmd
 }
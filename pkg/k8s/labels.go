@@ -25,8 +25,26 @@ const (
 	// "warp".  Having such a label ensures that the resource is recreated every
 	// time warp runs.
 	RunIDLabel = "warp.runID"
+
+	// DeployHashLabel is put on the ConfigMaps that record the hashes of the
+	// resources applied to a stack.  StackLabel is not used for these ConfigMaps,
+	// otherwise they would be pruned by "kubectl apply --prune".
+	DeployHashLabel = "warp.deployHash"
+
+	// StepLabel is put on the resources applied by a deploy step, along with
+	// StackLabel.  Each step only prunes the resources that carry its own
+	// step label, so that the steps do not prune each other's resources.
+	StepLabel = "warp.step"
+
+	// HelmReleaseLabel is put on the ConfigMaps that record the Helm releases
+	// of a stack, so that they can be uninstalled by the garbage collection.
+	// As for DeployHashLabel, StackLabel is not used for these ConfigMaps.
+	HelmReleaseLabel = "warp.helmRelease"
 )
 
+// StackNamespace is the Kubernetes namespace the stacks are deployed to.
+const StackNamespace = "default"
+
 // Labels maps labels to values.  Combining Labels with the String function gives
 // a convenient way to specify a Kubernetes selector.
 type Labels map[string]string
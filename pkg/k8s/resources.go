@@ -0,0 +1,168 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package k8s
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/hchauvin/warp/pkg/stacks/names"
+	"golang.org/x/sync/errgroup"
+	"io"
+	"io/ioutil"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"os"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"sync"
+)
+
+// ListStackResources lists the live resources of a stack.  Only the
+// types of resources that are garbage-collected are considered (see Gc).
+func (k8s *K8s) ListStackResources(name names.Name) ([]unstructured.Unstructured, error) {
+	labelSelector := Labels{
+		StackLabel: name.DNSName(),
+	}.String()
+
+	var resources []unstructured.Unstructured
+	var mut sync.Mutex
+	var g errgroup.Group
+	for _, res := range k8s.stackResourceTypes(true) {
+		res := res
+		g.Go(func() error {
+			list, err := k8s.resourceInterface(res, StackNamespace).List(metav1.ListOptions{
+				LabelSelector: labelSelector,
+			})
+			if err != nil {
+				return fmt.Errorf("cannot list resources %v: %v", res, err)
+			}
+			mut.Lock()
+			resources = append(resources, list.Items...)
+			mut.Unlock()
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(resources, func(i, j int) bool {
+		return ResourceKey(&resources[i]) < ResourceKey(&resources[j])
+	})
+	return resources, nil
+}
+
+// GetResource gets the live version of a resource, or nil if the resource
+// does not exist.  The resource is identified by its API version, kind,
+// namespace and name.  The namespace defaults to StackNamespace.
+func (k8s *K8s) GetResource(obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	gvk := obj.GroupVersionKind()
+	mapping, err := k8s.restMapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, fmt.Errorf("cannot find resource type for %s: %v", gvk, err)
+	}
+
+	api := k8s.DynClient.Resource(mapping.Resource)
+	var live *unstructured.Unstructured
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		namespace := obj.GetNamespace()
+		if namespace == "" {
+			namespace = StackNamespace
+		}
+		live, err = api.Namespace(namespace).Get(obj.GetName(), metav1.GetOptions{})
+	} else {
+		live, err = api.Get(obj.GetName(), metav1.GetOptions{})
+	}
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("cannot get %s: %v", ResourceKey(obj), err)
+	}
+	return live, nil
+}
+
+// ResourceKey gives a key that identifies a resource, in the form
+// "<kind>/<name>" or "<kind>/<namespace>/<name>" when the namespace
+// is set and is not StackNamespace.
+func ResourceKey(obj *unstructured.Unstructured) string {
+	namespace := obj.GetNamespace()
+	if namespace == "" || namespace == StackNamespace {
+		return obj.GetKind() + "/" + obj.GetName()
+	}
+	return obj.GetKind() + "/" + namespace + "/" + obj.GetName()
+}
+
+// AddStackLabel adds the stack label to a resource and, if the resource
+// has a pod template, to the pod template.
+func AddStackLabel(resource *unstructured.Unstructured, dnsName string) error {
+	labels := resource.GetLabels()
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	labels[StackLabel] = dnsName
+	resource.SetLabels(labels)
+
+	_, hasTemplate, err := unstructured.NestedMap(resource.Object, "spec", "template")
+	if err != nil || !hasTemplate {
+		return err
+	}
+	templateLabels, _, err := unstructured.NestedStringMap(resource.Object, "spec", "template", "metadata", "labels")
+	if err != nil {
+		return err
+	}
+	if templateLabels == nil {
+		templateLabels = make(map[string]string)
+	}
+	templateLabels[StackLabel] = dnsName
+	return unstructured.SetNestedStringMap(resource.Object, templateLabels, "spec", "template", "metadata", "labels")
+}
+
+// AddStepLabel adds the step label to the resources of a YAML file with
+// one resource per YAML document.  The file is rewritten in place.  As
+// opposed to AddStackLabel, the pod templates are left untouched: the
+// label is only used for pruning.
+func AddStepLabel(resourcesPath string, step string) error {
+	f, err := os.Open(resourcesPath)
+	if err != nil {
+		return err
+	}
+	var labeled bytes.Buffer
+	decoder := utilyaml.NewYAMLOrJSONDecoder(f, 4096)
+	for {
+		var obj map[string]interface{}
+		if err := decoder.Decode(&obj); err != nil {
+			if err == io.EOF {
+				break
+			}
+			f.Close()
+			return fmt.Errorf("cannot decode resources '%s': %v", resourcesPath, err)
+		}
+		if len(obj) == 0 {
+			continue
+		}
+		resource := unstructured.Unstructured{Object: obj}
+		labels := resource.GetLabels()
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels[StepLabel] = step
+		resource.SetLabels(labels)
+
+		b, err := yaml.Marshal(resource.Object)
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("cannot marshal resource: %v", err)
+		}
+		labeled.WriteString("---\n")
+		labeled.Write(b)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(resourcesPath, labeled.Bytes(), 0777)
+}
@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package k8s
+
+import (
+	"github.com/hchauvin/warp/pkg/stacks/names"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAddStepLabel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "steplabel")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "expanded_resources.yml")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(`---
+kind: Service
+metadata:
+  name: api
+  labels:
+    warp.stack: foo-bar
+---
+---
+kind: Deployment
+metadata:
+  name: api
+spec:
+  template:
+    metadata:
+      labels:
+        app: api
+`), 0666))
+
+	assert.NoError(t, AddStepLabel(path, "crds"))
+
+	b, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, `---
+kind: Service
+metadata:
+  labels:
+    warp.stack: foo-bar
+    warp.step: crds
+  name: api
+---
+kind: Deployment
+metadata:
+  labels:
+    warp.step: crds
+  name: api
+spec:
+  template:
+    metadata:
+      labels:
+        app: api
+`, string(b))
+
+	// Labeling is idempotent.
+	assert.NoError(t, AddStepLabel(path, "crds"))
+	again, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, string(b), string(again))
+}
+
+func TestHelmReleaseName(t *testing.T) {
+	name := names.Name{Family: "foo", ShortName: "bar"}
+	assert.Equal(t, "foo-bar-helm", HelmReleaseName(name, "helm"))
+	assert.Equal(t, "foo-bar-my-chart", HelmReleaseName(name, "My_Chart"))
+}
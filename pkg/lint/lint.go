@@ -9,7 +9,10 @@ import (
 	"github.com/hchauvin/warp/pkg/config"
 	"github.com/hchauvin/warp/pkg/deploy/container"
 	"github.com/hchauvin/warp/pkg/deploy/helm"
+	"github.com/hchauvin/warp/pkg/deploy/jsonnet"
 	"github.com/hchauvin/warp/pkg/deploy/kustomize"
+	"github.com/hchauvin/warp/pkg/deploy/manifests"
+	"github.com/hchauvin/warp/pkg/deploy/postrender"
 	"github.com/hchauvin/warp/pkg/lint/kubescore"
 	"github.com/hchauvin/warp/pkg/pipelines"
 	"github.com/hchauvin/warp/pkg/stacks/names"
@@ -27,30 +30,48 @@ func Lint(ctx context.Context, cfg *config.Config, pipeline *pipelines.Pipeline)
 		name.ShortName += "-" + pipeline.Stack.Variant
 	}
 
+	steps := pipeline.Deploy.AllSteps()
+
+	// The resources are linted with the image references of all the
+	// Container steps.
 	var refs container.ImageRefs
-	if pipeline.Deploy.Container != nil {
-		var err error
-		refs, err = container.Exec(ctx, cfg, pipeline, name)
-		if err != nil {
-			return fmt.Errorf("deploy.container: %v", err)
+	for _, step := range steps {
+		if step.Container == nil {
+			continue
 		}
-	}
-
-	if pipeline.Deploy.Helm != nil && !pipeline.Lint.DisableHelmKubeScore {
-		k8sResourcesPath, err := helm.ExpandResources(ctx, cfg, pipeline, name, refs)
+		stepRefs, err := container.Exec(ctx, cfg, step.Container, name)
 		if err != nil {
-			return fmt.Errorf("deploy.helm: %v", err)
+			return fmt.Errorf("deploy.%s: %v", step.Name, err)
 		}
-
-		if err := kubescore.Lint(ctx, cfg, k8sResourcesPath); err != nil {
-			return err
+		for k, v := range stepRefs {
+			if refs == nil {
+				refs = make(container.ImageRefs)
+			}
+			refs[k] = v
 		}
 	}
 
-	if pipeline.Deploy.Kustomize != nil && !pipeline.Lint.DisableKustomizeKubeScore {
-		k8sResourcesPath, err := kustomize.ExpandResources(ctx, cfg, pipeline, name, refs)
+	for _, step := range steps {
+		var k8sResourcesPath string
+		var err error
+		switch {
+		case step.Helm != nil && !pipeline.Lint.DisableHelmKubeScore:
+			k8sResourcesPath, err = helm.ExpandResources(ctx, cfg, step.Helm, name, step.Name, refs)
+		case step.Kustomize != nil && !pipeline.Lint.DisableKustomizeKubeScore:
+			k8sResourcesPath, err = kustomize.ExpandResources(ctx, cfg, step.Kustomize, name, step.Name, refs)
+		case step.Manifests != nil && !pipeline.Lint.DisableManifestsKubeScore:
+			k8sResourcesPath, err = manifests.ExpandResources(ctx, cfg, step.Manifests, name, step.Name, refs)
+		case step.Jsonnet != nil && !pipeline.Lint.DisableJsonnetKubeScore:
+			k8sResourcesPath, err = jsonnet.ExpandResources(ctx, cfg, step.Jsonnet, name, step.Name, refs)
+		default:
+			continue
+		}
 		if err != nil {
-			return fmt.Errorf("deploy.kustomize: %v", err)
+			return fmt.Errorf("deploy.%s: %v", step.Name, err)
+		}
+		k8sResourcesPath, err = postrender.Exec(ctx, cfg, pipeline.Deploy.PostRender, name, step.Name, k8sResourcesPath)
+		if err != nil {
+			return err
 		}
 
 		if err := kubescore.Lint(ctx, cfg, k8sResourcesPath); err != nil {
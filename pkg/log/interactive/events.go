@@ -27,3 +27,12 @@ const (
 	// work is complete.
 	Completed = "completed"
 )
+
+// LogEvent reports a line of output produced by an allocation.
+type LogEvent struct {
+	// Name is the name of the allocation.
+	Name string
+
+	// Line is the line of output, without the trailing newline.
+	Line string
+}
@@ -8,8 +8,6 @@ import (
 	"github.com/benbjohnson/clock"
 	"github.com/fatih/color"
 	"github.com/hchauvin/warp/pkg/log"
-	"sort"
-	"sync"
 	"time"
 )
 
@@ -34,6 +32,7 @@ type allocationProgress struct {
 	stage     string
 	started   *time.Time
 	completed *time.Time
+	logTail   []string
 }
 
 type summary struct {
@@ -58,8 +57,7 @@ func report(
 	l.SetInteractive(true)
 	defer l.SetInteractive(false)
 
-	var progressMut sync.Mutex
-	progress := make(map[string]allocationProgress)
+	t := newTracker()
 
 	go func() {
 		for {
@@ -67,22 +65,7 @@ func report(
 			case <-done:
 				return
 			case e := <-eventc:
-				switch et := e.(type) {
-				case SetStateEvent:
-					progressMut.Lock()
-					p, _ := progress[et.Name]
-					now := clk.Now()
-					switch et.State {
-					case Started:
-						p.started = &now
-					case Completed:
-						p.completed = &now
-					}
-					p.state = et.State
-					p.stage = et.Stage
-					progress[et.Name] = p
-					progressMut.Unlock()
-				}
+				t.apply(e, clk.Now())
 			}
 		}
 	}()
@@ -100,20 +83,11 @@ func report(
 
 		now := clk.Now()
 
-		progressMut.Lock()
-		sortedAllocationNames := make([]string, 0, len(progress))
-		for allocationName := range progress {
-			sortedAllocationNames = append(sortedAllocationNames, allocationName)
-		}
-		sort.Slice(sortedAllocationNames, func(i, j int) bool {
-			return sortedAllocationNames[i] < sortedAllocationNames[j]
-		})
+		sortedProgress := t.sorted()
 
-		lines := make([]string, 0, len(progress))
+		lines := make([]string, 0, len(sortedProgress))
 		completedCount := 0
-		for _, target := range sortedAllocationNames {
-			p := progress[target]
-
+		for _, p := range sortedProgress {
 			if p.state == Completed {
 				completedCount++
 			}
@@ -123,11 +97,11 @@ func report(
 			if p.completed != nil {
 				if clk.Since(*p.completed) < allocationPersistenceDuration {
 					duration := p.completed.Sub(*p.started).Seconds()
-					line = fmt.Sprintf(bold("=> [%4.1fs]")+" %s "+bold("%s"), duration, target, p.state)
+					line = fmt.Sprintf(bold("=> [%4.1fs]")+" %s "+bold("%s"), duration, p.name, p.state)
 				}
 			} else if p.started != nil {
 				duration := now.Sub(*p.started).Seconds()
-				line = fmt.Sprintf(bold("=> [%4.1fs]")+" %s %s", duration, target, p.stage)
+				line = fmt.Sprintf(bold("=> [%4.1fs]")+" %s %s", duration, p.name, p.stage)
 			}
 
 			if line == "" {
@@ -138,9 +112,8 @@ func report(
 		summary := fmt.Sprintf(
 			"Running [%d/%d, %3.1fs]:",
 			completedCount,
-			len(progress),
+			len(sortedProgress),
 			now.Sub(started).Seconds())
-		progressMut.Unlock()
 		lines = append([]string{summary}, lines...)
 		if err := r.replace(lines); err != nil {
 			return err
@@ -0,0 +1,354 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package interactive
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/benbjohnson/clock"
+	"github.com/hchauvin/warp/pkg/log"
+	"github.com/julienschmidt/httprouter"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const serveLogDomain = "interactive.serve"
+
+// Serve serves a stream of events over HTTP, on the given listener,
+// until done is closed.  The listener is closed when Serve returns.
+// The following endpoints are exposed:
+//
+//   - "/": an HTML page rendering the same view as Report;
+//   - "/status": the current status, as JSON (see Status);
+//   - "/events": a Server-Sent Events stream of status updates.
+func Serve(l *log.Logger, listener net.Listener, eventc <-chan interface{}, done <-chan struct{}) error {
+	l.Info(serveLogDomain, "serving batch progress on http://%s", listener.Addr())
+
+	s := newServer(clock.New())
+	srv := &http.Server{Handler: s.handler()}
+	servec := make(chan error, 1)
+	go func() {
+		servec <- srv.Serve(listener)
+	}()
+
+	s.consume(eventc, done)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		return err
+	}
+	if err := <-servec; err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Status is the status of a stream of events, as served by Serve.
+type Status struct {
+	// Started is when serving started.
+	Started time.Time `json:"started"`
+
+	// DurationSeconds is the number of seconds elapsed since Started.
+	DurationSeconds float64 `json:"durationSeconds"`
+
+	// Done is true when the stream of events is over.
+	Done bool `json:"done"`
+
+	// CompletedCount is the number of completed allocations.
+	CompletedCount int `json:"completedCount"`
+
+	// Allocations is the status of all the allocations, sorted by name.
+	Allocations []AllocationStatus `json:"allocations"`
+}
+
+// AllocationStatus is the status of an allocation.  An allocation is
+// either a batch command or a stack.
+type AllocationStatus struct {
+	// Name is the name of the allocation.
+	Name string `json:"name"`
+
+	// Kind is either "stack" or "command".
+	Kind string `json:"kind"`
+
+	// State is the current state of the allocation.
+	State State `json:"state"`
+
+	// Stage further qualifies the state.
+	Stage string `json:"stage,omitempty"`
+
+	// Started is when work started on the allocation, if it did.
+	Started *time.Time `json:"started,omitempty"`
+
+	// Completed is when work completed on the allocation, if it did.
+	Completed *time.Time `json:"completed,omitempty"`
+
+	// DurationSeconds is the number of seconds spent working on the
+	// allocation.
+	DurationSeconds float64 `json:"durationSeconds"`
+
+	// LogTail contains the last lines of output of the allocation.
+	LogTail []string `json:"logTail,omitempty"`
+}
+
+type server struct {
+	tracker     *tracker
+	clk         clock.Clock
+	started     time.Time
+	mut         sync.Mutex
+	done        bool
+	subscribers map[chan []byte]struct{}
+}
+
+func newServer(clk clock.Clock) *server {
+	return &server{
+		tracker:     newTracker(),
+		clk:         clk,
+		started:     clk.Now(),
+		subscribers: make(map[chan []byte]struct{}),
+	}
+}
+
+func (s *server) consume(eventc <-chan interface{}, done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			s.mut.Lock()
+			s.done = true
+			s.mut.Unlock()
+			s.broadcast("done", s.status())
+			return
+		case e := <-eventc:
+			name := s.tracker.apply(e, s.clk.Now())
+			if name == "" {
+				continue
+			}
+			p, _ := s.tracker.get(name)
+			s.broadcast("allocation", s.allocationStatus(p))
+		}
+	}
+}
+
+func (s *server) status() Status {
+	s.mut.Lock()
+	done := s.done
+	s.mut.Unlock()
+
+	progress := s.tracker.sorted()
+	status := Status{
+		Started:         s.started,
+		DurationSeconds: s.clk.Now().Sub(s.started).Seconds(),
+		Done:            done,
+		Allocations:     make([]AllocationStatus, 0, len(progress)),
+	}
+	for _, p := range progress {
+		if p.state == Completed {
+			status.CompletedCount++
+		}
+		status.Allocations = append(status.Allocations, s.allocationStatus(p))
+	}
+	return status
+}
+
+func (s *server) allocationStatus(p allocationProgress) AllocationStatus {
+	status := AllocationStatus{
+		Name:      p.name,
+		Kind:      "command",
+		State:     p.state,
+		Stage:     p.stage,
+		Started:   p.started,
+		Completed: p.completed,
+		LogTail:   p.logTail,
+	}
+	if strings.HasPrefix(p.name, "stack/") {
+		status.Kind = "stack"
+	}
+	if p.started != nil {
+		if p.completed != nil {
+			status.DurationSeconds = p.completed.Sub(*p.started).Seconds()
+		} else {
+			status.DurationSeconds = s.clk.Now().Sub(*p.started).Seconds()
+		}
+	}
+	return status
+}
+
+func (s *server) broadcast(event string, data interface{}) {
+	msg, err := sseMessage(event, data)
+	if err != nil {
+		// The data types are under our control and always marshal.
+		panic(err.Error())
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	for sub := range s.subscribers {
+		select {
+		case sub <- msg:
+		default:
+			// Slow subscribers miss updates rather than blocking the batch.
+		}
+	}
+}
+
+func (s *server) subscribe() chan []byte {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	sub := make(chan []byte, 64)
+	s.subscribers[sub] = struct{}{}
+	return sub
+}
+
+func (s *server) unsubscribe(sub chan []byte) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	delete(s.subscribers, sub)
+}
+
+func (s *server) handler() http.Handler {
+	router := httprouter.New()
+	router.GET("/", s.handleIndex)
+	router.GET("/status", s.handleStatus)
+	router.GET("/events", s.handleEvents)
+	return router
+}
+
+func (s *server) handleIndex(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if _, err := w.Write([]byte(indexHTML)); err != nil {
+		return
+	}
+}
+
+func (s *server) handleStatus(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.status()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (s *server) handleEvents(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	sub := s.subscribe()
+	defer s.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	msg, err := sseMessage("status", s.status())
+	if err != nil {
+		panic(err.Error())
+	}
+	if _, err := w.Write(msg); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case msg := <-sub:
+			if _, err := w.Write(msg); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func sseMessage(event string, data interface{}) ([]byte, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return []byte("event: " + event + "\ndata: " + string(b) + "\n\n"), nil
+}
+
+// indexHTML renders the same view as Report, from the "/events" stream.
+const indexHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>warp batch</title>
+<style>
+body { font-family: monospace; background: #1e1e1e; color: #ddd; }
+b { color: #fff; }
+pre { margin: 0 0 0 2em; color: #999; }
+</style>
+</head>
+<body>
+<div id="summary"></div>
+<div id="allocations"></div>
+<script>
+var allocations = {};
+var started = null;
+var done = false;
+
+function duration(a) {
+  if (!a.started) { return 0; }
+  var end = a.completed ? new Date(a.completed) : new Date();
+  return (end - new Date(a.started)) / 1000;
+}
+
+function escape(s) {
+  var div = document.createElement("div");
+  div.textContent = s;
+  return div.innerHTML;
+}
+
+function render() {
+  var names = Object.keys(allocations).sort();
+  var completed = 0;
+  var html = "";
+  names.forEach(function(name) {
+    var a = allocations[name];
+    if (a.state === "completed") { completed++; }
+    if (!a.started) { return; }
+    var label = a.completed ? "<b>" + a.state + "</b>" : escape(a.stage || "");
+    html += "<div><b>=&gt; [" + duration(a).toFixed(1) + "s]</b> " + escape(name) + " " + label;
+    if (a.logTail && !a.completed) {
+      html += "<pre>" + escape(a.logTail.join("\n")) + "</pre>";
+    }
+    html += "</div>";
+  });
+  var total = started ? (new Date() - started) / 1000 : 0;
+  document.getElementById("summary").textContent =
+    (done ? "Done" : "Running") + " [" + completed + "/" + names.length + ", " + total.toFixed(1) + "s]:";
+  document.getElementById("allocations").innerHTML = html;
+}
+
+var source = new EventSource("events");
+source.addEventListener("status", function(e) {
+  var status = JSON.parse(e.data);
+  started = new Date(status.started);
+  done = status.done;
+  allocations = {};
+  status.allocations.forEach(function(a) { allocations[a.name] = a; });
+  render();
+});
+source.addEventListener("allocation", function(e) {
+  var a = JSON.parse(e.data);
+  allocations[a.name] = a;
+  render();
+});
+source.addEventListener("done", function(e) {
+  done = true;
+  source.close();
+  render();
+});
+setInterval(function() { if (!done) { render(); } }, 100);
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,118 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package interactive
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/benbjohnson/clock"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestServeStatus(t *testing.T) {
+	clk := clock.NewMock()
+	clk.Set(initialTestTime)
+	s := newServer(clk)
+	srv := httptest.NewServer(s.handler())
+	defer srv.Close()
+
+	eventc := make(chan interface{})
+	done := make(chan struct{})
+	consumed := make(chan struct{})
+	go func() {
+		s.consume(eventc, done)
+		close(consumed)
+	}()
+
+	eventc <- SetStateEvent{Name: "stack/foo", State: Started, Stage: "deploying"}
+	eventc <- SetStateEvent{Name: "cmd", State: Started}
+	// Events are applied sequentially: once this event is received, the
+	// previous one has been applied.
+	eventc <- LogEvent{Name: "unknown", Line: "ignored"}
+	clk.Add(refreshDuration)
+	eventc <- LogEvent{Name: "cmd", Line: "hello"}
+	eventc <- SetStateEvent{Name: "cmd", State: Completed}
+	close(done)
+	<-consumed
+
+	resp, err := http.Get(srv.URL + "/status")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	var status Status
+	err = json.NewDecoder(resp.Body).Decode(&status)
+	assert.NoError(t, err)
+
+	assert.True(t, status.Done)
+	assert.Equal(t, 1, status.CompletedCount)
+	assert.Len(t, status.Allocations, 2)
+
+	assert.Equal(t, "cmd", status.Allocations[0].Name)
+	assert.Equal(t, "command", status.Allocations[0].Kind)
+	assert.Equal(t, State(Completed), status.Allocations[0].State)
+	assert.Equal(t, []string{"hello"}, status.Allocations[0].LogTail)
+	assert.Equal(t, refreshDuration.Seconds(), status.Allocations[0].DurationSeconds)
+
+	assert.Equal(t, "stack/foo", status.Allocations[1].Name)
+	assert.Equal(t, "stack", status.Allocations[1].Kind)
+	assert.Equal(t, "deploying", status.Allocations[1].Stage)
+}
+
+func TestServeEvents(t *testing.T) {
+	clk := clock.NewMock()
+	s := newServer(clk)
+	srv := httptest.NewServer(s.handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/events")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	r := bufio.NewReader(resp.Body)
+	assert.Equal(t, "status", readSSEEvent(t, r))
+
+	eventc := make(chan interface{})
+	done := make(chan struct{})
+	go s.consume(eventc, done)
+
+	eventc <- SetStateEvent{Name: "cmd", State: Started}
+	assert.Equal(t, "allocation", readSSEEvent(t, r))
+	close(done)
+	assert.Equal(t, "done", readSSEEvent(t, r))
+}
+
+func TestTrackerLogTail(t *testing.T) {
+	tr := newTracker()
+	tr.apply(SetStateEvent{Name: "cmd", State: Started}, initialTestTime)
+	for i := 0; i < logTailSize+5; i++ {
+		tr.apply(LogEvent{Name: "cmd", Line: strconv.Itoa(i)}, initialTestTime)
+	}
+	p, ok := tr.get("cmd")
+	assert.True(t, ok)
+	assert.Len(t, p.logTail, logTailSize)
+	assert.Equal(t, "5", p.logTail[0])
+}
+
+func readSSEEvent(t *testing.T, r *bufio.Reader) string {
+	var event string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			assert.FailNow(t, "cannot read SSE stream", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return event
+		}
+		if strings.HasPrefix(line, "event: ") {
+			event = strings.TrimPrefix(line, "event: ")
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package interactive
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// logTailSize is the maximum number of log lines kept per allocation.
+const logTailSize = 20
+
+// tracker tracks the progress of allocations from a stream of events.
+type tracker struct {
+	mut      sync.Mutex
+	progress map[string]allocationProgress
+}
+
+func newTracker() *tracker {
+	return &tracker{
+		progress: make(map[string]allocationProgress),
+	}
+}
+
+// apply applies an event.  The name of the allocation the event
+// pertains to is returned, or "" if the event was ignored.
+func (t *tracker) apply(e interface{}, now time.Time) string {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	switch et := e.(type) {
+	case SetStateEvent:
+		p, _ := t.progress[et.Name]
+		switch et.State {
+		case Started:
+			p.started = &now
+		case Completed:
+			p.completed = &now
+		}
+		p.name = et.Name
+		p.state = et.State
+		p.stage = et.Stage
+		t.progress[et.Name] = p
+		return et.Name
+	case LogEvent:
+		p, ok := t.progress[et.Name]
+		if !ok {
+			return ""
+		}
+		p.logTail = append(p.logTail, et.Line)
+		if len(p.logTail) > logTailSize {
+			p.logTail = p.logTail[len(p.logTail)-logTailSize:]
+		}
+		t.progress[et.Name] = p
+		return et.Name
+	default:
+		return ""
+	}
+}
+
+// get gets the progress of an allocation.
+func (t *tracker) get(name string) (allocationProgress, bool) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	p, ok := t.progress[name]
+	if ok {
+		p.logTail = append([]string(nil), p.logTail...)
+	}
+	return p, ok
+}
+
+// sorted returns the progress of all the allocations, sorted by name.
+func (t *tracker) sorted() []allocationProgress {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	ans := make([]allocationProgress, 0, len(t.progress))
+	for _, p := range t.progress {
+		p.logTail = append([]string(nil), p.logTail...)
+		ans = append(ans, p)
+	}
+	sort.Slice(ans, func(i, j int) bool {
+		return ans[i].name < ans[j].name
+	})
+	return ans
+}
@@ -0,0 +1,202 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package pipelines
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/afero"
+	"sigs.k8s.io/yaml"
+	"strings"
+)
+
+// Formats of the container manifests (see Container.Format).
+const (
+	// ContainerManifestJSONFormat is the default format: a JSON map from
+	// image names to container manifest entries (see
+	// ContainerManifestEntry), e.g., {"api": {"ref": "registry/api:1.0"}}.
+	ContainerManifestJSONFormat = "json"
+
+	// ContainerManifestYAMLFormat is the same as the JSON format, in YAML.
+	ContainerManifestYAMLFormat = "yaml"
+
+	// ContainerManifestBazelFormat is a JSON map from image names to
+	// the attributes of rules_docker "container_push" rules, e.g.,
+	// {"api": {"registry": "gcr.io", "repository": "project/api",
+	// "tag": "1.0", "digest": "sha256:..."}}.  The digest, when given,
+	// is the content of the ".digest" output of "container_push", and
+	// takes precedence over the tag.
+	ContainerManifestBazelFormat = "bazel"
+
+	// ContainerManifestSkaffoldFormat is the JSON output of
+	// "skaffold build --file-output", also used for the images built
+	// with ko through skaffold, e.g., {"builds": [{"imageName": "api",
+	// "tag": "gcr.io/project/api:1.0@sha256:..."}]}.
+	ContainerManifestSkaffoldFormat = "skaffold"
+
+	// ContainerManifestKoFormat is an alias for ContainerManifestSkaffoldFormat.
+	ContainerManifestKoFormat = "ko"
+
+	// ContainerManifestEnvFormat is an env file, with one "name=ref"
+	// line per image.  Empty lines and lines starting with "#" are
+	// ignored.
+	ContainerManifestEnvFormat = "env"
+)
+
+// containerManifestReaders maps the formats of the container manifests
+// to the functions that read them.
+var containerManifestReaders = map[string]func(b []byte) (ContainerManifest, error){
+	ContainerManifestJSONFormat:     readJSONContainerManifest,
+	ContainerManifestYAMLFormat:     readYAMLContainerManifest,
+	ContainerManifestBazelFormat:    readBazelContainerManifest,
+	ContainerManifestSkaffoldFormat: readSkaffoldContainerManifest,
+	ContainerManifestKoFormat:       readSkaffoldContainerManifest,
+	ContainerManifestEnvFormat:      readEnvContainerManifest,
+}
+
+func parseContainerManifest(fs afero.Fs, path string, format string) (ContainerManifest, error) {
+	if format == "" {
+		format = ContainerManifestJSONFormat
+	}
+	reader, ok := containerManifestReaders[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown container manifest format '%s'", format)
+	}
+
+	b, err := afero.ReadFile(fs, path)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := reader(b)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, entry := range manifest {
+		if entry.Build == nil {
+			continue
+		}
+		if entry.Ref == "" {
+			return nil, fmt.Errorf("image '%s': the ref must be given for the images to build", name)
+		}
+		if entry.Build.Context == "" {
+			return nil, fmt.Errorf("image '%s': the build context must be given", name)
+		}
+	}
+
+	return manifest, nil
+}
+
+func readJSONContainerManifest(b []byte) (ContainerManifest, error) {
+	var manifest ContainerManifest
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+func readYAMLContainerManifest(b []byte) (ContainerManifest, error) {
+	var manifest ContainerManifest
+	if err := yaml.UnmarshalStrict(b, &manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// bazelContainerPush holds the attributes of a rules_docker
+// "container_push" rule.
+type bazelContainerPush struct {
+	Registry   string `json:"registry"`
+	Repository string `json:"repository"`
+	Tag        string `json:"tag"`
+	Digest     string `json:"digest"`
+}
+
+func readBazelContainerManifest(b []byte) (ContainerManifest, error) {
+	var pushes map[string]bazelContainerPush
+	if err := json.Unmarshal(b, &pushes); err != nil {
+		return nil, err
+	}
+	manifest := make(ContainerManifest, len(pushes))
+	for name, push := range pushes {
+		if push.Repository == "" {
+			return nil, fmt.Errorf("image '%s': the repository must be given", name)
+		}
+		ref := push.Repository
+		if push.Registry != "" {
+			ref = push.Registry + "/" + ref
+		}
+		switch {
+		case push.Digest != "":
+			ref += "@" + strings.TrimSpace(push.Digest)
+		case push.Tag != "":
+			ref += ":" + push.Tag
+		}
+		manifest[name] = ContainerManifestEntry{Ref: ref}
+	}
+	return manifest, nil
+}
+
+// skaffoldBuildOutput is the output of "skaffold build --file-output".
+type skaffoldBuildOutput struct {
+	Builds []struct {
+		ImageName string `json:"imageName"`
+		Tag       string `json:"tag"`
+	} `json:"builds"`
+}
+
+func readSkaffoldContainerManifest(b []byte) (ContainerManifest, error) {
+	var output skaffoldBuildOutput
+	if err := json.Unmarshal(b, &output); err != nil {
+		return nil, err
+	}
+	manifest := make(ContainerManifest, len(output.Builds))
+	for _, build := range output.Builds {
+		if build.ImageName == "" || build.Tag == "" {
+			return nil, fmt.Errorf("both the image name and the tag must be given")
+		}
+		manifest[build.ImageName] = ContainerManifestEntry{Ref: stripTagWithDigest(build.Tag)}
+	}
+	return manifest, nil
+}
+
+// stripTagWithDigest removes the tag from image references that have
+// both a tag and a digest ("name:tag@digest"), as the tag is then
+// ignored.
+func stripTagWithDigest(ref string) string {
+	parts := strings.SplitN(ref, "@", 2)
+	if len(parts) != 2 {
+		return ref
+	}
+	name := parts[0]
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name = name[:i]
+	}
+	return name + "@" + parts[1]
+}
+
+func readEnvContainerManifest(b []byte) (ContainerManifest, error) {
+	manifest := make(ContainerManifest)
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("line %d: expected 'name=ref'", lineno)
+		}
+		manifest[strings.TrimSpace(parts[0])] = ContainerManifestEntry{Ref: strings.TrimSpace(parts[1])}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package pipelines
+
+import (
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseContainerManifestFormats(t *testing.T) {
+	expected := ContainerManifest{
+		"api": {Ref: "gcr.io/project/api@sha256:0123"},
+		"db":  {Ref: "gcr.io/project/db:1.0"},
+	}
+
+	for _, tc := range []struct {
+		format   string
+		manifest string
+	}{
+		{"", `{"api": {"ref": "gcr.io/project/api@sha256:0123"}, "db": {"ref": "gcr.io/project/db:1.0"}}`},
+		{"json", `{"api": {"ref": "gcr.io/project/api@sha256:0123"}, "db": {"ref": "gcr.io/project/db:1.0"}}`},
+		{"yaml", "api:\n  ref: gcr.io/project/api@sha256:0123\ndb:\n  ref: gcr.io/project/db:1.0\n"},
+		{"bazel", `{
+			"api": {"registry": "gcr.io", "repository": "project/api", "tag": "latest", "digest": "sha256:0123\n"},
+			"db": {"registry": "gcr.io", "repository": "project/db", "tag": "1.0"}
+		}`},
+		{"skaffold", `{"builds": [
+			{"imageName": "api", "tag": "gcr.io/project/api:latest@sha256:0123"},
+			{"imageName": "db", "tag": "gcr.io/project/db:1.0"}
+		]}`},
+		{"ko", `{"builds": [
+			{"imageName": "api", "tag": "gcr.io/project/api@sha256:0123"},
+			{"imageName": "db", "tag": "gcr.io/project/db:1.0"}
+		]}`},
+		{"env", "# Images\napi=gcr.io/project/api@sha256:0123\n\ndb = gcr.io/project/db:1.0\n"},
+	} {
+		fs := afero.NewMemMapFs()
+		assert.NoError(t, afero.WriteFile(fs, "/manifest", []byte(tc.manifest), 0666))
+		manifest, err := parseContainerManifest(fs, "/manifest", tc.format)
+		assert.NoError(t, err, tc.format)
+		assert.Equal(t, expected, manifest, tc.format)
+	}
+}
+
+func TestParseInvalidContainerManifestFormats(t *testing.T) {
+	for _, tc := range []struct {
+		format   string
+		manifest string
+		err      string
+	}{
+		{"unknown", `{}`, "unknown container manifest format 'unknown'"},
+		{"yaml", "api:\n  image: api\n", "unknown field"},
+		{"bazel", `{"api": {"tag": "1.0"}}`, "image 'api': the repository must be given"},
+		{"skaffold", `{"builds": [{"imageName": "api"}]}`, "both the image name and the tag must be given"},
+		{"env", "api=api\ndb\n", "line 2: expected 'name=ref'"},
+	} {
+		fs := afero.NewMemMapFs()
+		assert.NoError(t, afero.WriteFile(fs, "/manifest", []byte(tc.manifest), 0666))
+		_, err := parseContainerManifest(fs, "/manifest", tc.format)
+		if assert.Error(t, err, tc.format) {
+			assert.Contains(t, err.Error(), tc.err, tc.format)
+		}
+	}
+}
+
+func TestStripTagWithDigest(t *testing.T) {
+	assert.Equal(t, "registry:5000/api@sha256:0123", stripTagWithDigest("registry:5000/api:1.0@sha256:0123"))
+	assert.Equal(t, "registry:5000/api@sha256:0123", stripTagWithDigest("registry:5000/api@sha256:0123"))
+	assert.Equal(t, "api:1.0", stripTagWithDigest("api:1.0"))
+}
@@ -95,6 +95,18 @@ func validateCommandHook(hook *CommandHook) error {
 	if hook.HTTPGet != nil {
 		actionCount++
 	}
+	if hook.Job != nil {
+		actionCount++
+		if err := hook.Job.validate(); err != nil {
+			return fmt.Errorf("invalid job hook: %v", err)
+		}
+	}
+	if hook.Exec != nil {
+		actionCount++
+	}
+	if hook.Copy != nil {
+		actionCount++
+	}
 	if actionCount != 1 {
 		return fmt.Errorf("there must be one and only one action per command hook")
 	}
@@ -118,3 +130,12 @@ resources:
 
 	return nil
 }
+
+func (h *JobHook) validate() error {
+	for _, e := range h.Env {
+		if !strings.Contains(e, "=") {
+			return fmt.Errorf("environment variable '%s' must be specified as 'name=value'", e)
+		}
+	}
+	return nil
+}
@@ -95,3 +95,20 @@ func TestValidateWaitForHook(t *testing.T) {
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "unknown resource kind 'foo'")
 }
+
+func TestValidateJobHook(t *testing.T) {
+	h := &JobHook{Image: "migrate", Env: []string{"DB=postgres://db:5432", "EMPTY="}}
+	assert.NoError(t, h.validate())
+
+	h = &JobHook{Image: "migrate", Env: []string{"DB"}}
+	err := h.validate()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "environment variable 'DB' must be specified as 'name=value'")
+
+	hooks := []CommandHook{
+		{Name: "foo", Job: &JobHook{Image: "migrate"}, HTTPGet: &HTTPGet{}},
+	}
+	_, err = dedupeAndValidateCommandHooks(hooks)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "there must be one and only one action per command hook")
+}
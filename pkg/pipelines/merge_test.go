@@ -47,3 +47,39 @@ func TestMergeSetups(t *testing.T) {
 
 	assert.ElementsMatch(t, expectedSetups, p.Setups)
 }
+
+func TestMergeHelmValues(t *testing.T) {
+	dest := &Pipeline{}
+	base := &Pipeline{
+		Deploy: Deploy{
+			Helm: &Helm{
+				Path:        "chart",
+				ValuesFiles: []string{"base.yml"},
+				Values: map[string]interface{}{
+					"replicas": 1,
+					"image":    "base",
+				},
+			},
+		},
+	}
+	overlay := &Pipeline{
+		Deploy: Deploy{
+			Helm: &Helm{
+				ValuesFiles: []string{"overlay.yml"},
+				Values: map[string]interface{}{
+					"image": "overlay",
+				},
+			},
+		},
+	}
+
+	assert.NoError(t, mergePipelines(dest, base))
+	assert.NoError(t, mergePipelines(dest, overlay))
+
+	assert.Equal(t, "chart", dest.Deploy.Helm.Path)
+	assert.Equal(t, []string{"base.yml", "overlay.yml"}, dest.Deploy.Helm.ValuesFiles)
+	assert.Equal(t, map[string]interface{}{
+		"replicas": 1,
+		"image":    "overlay",
+	}, dest.Deploy.Helm.Values)
+}
@@ -23,6 +23,16 @@ type Pipeline struct {
 	// controlled for.
 	Bases []string `yaml:"bases,omitempty"`
 
+	// Requires lists the pipelines of the stacks this stack depends on,
+	// such as a shared database.  When a stack is held, the stacks of
+	// the required pipelines are also held, and deployed if needed,
+	// before the stack is deployed.  Within a process, the required
+	// stacks are shared between all the stacks that require them: the
+	// required pipelines that give the same stack.name share one stack,
+	// and so do the ones that give the same stack.family, for which a
+	// stack of the family is held once and reused.
+	Requires []Requirement `yaml:"requires,omitempty" validate:"dive"`
+
 	Lint Lint `yaml:"lint"`
 
 	// Deploy describes the deployment steps.
@@ -41,6 +51,18 @@ type Pipeline struct {
 	Path string `yaml:"-"`
 }
 
+// Requirement is a pipeline the stack depends on (see Pipeline.Requires).
+type Requirement struct {
+	// Pipeline is the path to the required pipeline, relative to the
+	// workspace dir.
+	Pipeline string `yaml:"pipeline" validate:"required"`
+
+	// Alias is the name the required stack is referred to with, e.g.,
+	// in the "requiredServiceAddress" template function.  Aliases are
+	// unique within a pipeline.
+	Alias string `yaml:"alias" validate:"required,name"`
+}
+
 // Setups is a slice of named setups.  Within this slice, names
 // are unique.
 type Setups []Setup
@@ -102,6 +124,14 @@ type Lint struct {
 	// DisableKustomizeKubeScore disables applying kube-score on
 	// the Kubernetes resources created by Kustomize.
 	DisableKustomizeKubeScore bool `yaml:"disableKustomizeKubeScore"`
+
+	// DisableManifestsKubeScore disables applying kube-score on
+	// the Kubernetes resources created from raw manifests.
+	DisableManifestsKubeScore bool `yaml:"disableManifestsKubeScore"`
+
+	// DisableJsonnetKubeScore disables applying kube-score on
+	// the Kubernetes resources created by Jsonnet.
+	DisableJsonnetKubeScore bool `yaml:"disableJsonnetKubeScore"`
 }
 
 // Deploy describes the deployment steps.
@@ -121,6 +151,51 @@ type Deploy struct {
 	// deploy to a Kubernetes cluster.  If it is omitted,
 	// the stack is not deployed to Kubernetes with kustomize.
 	Kustomize *Kustomize `yaml:"kustomize,omitempty"`
+
+	// Manifests describes raw Kubernetes manifests to deploy to a
+	// Kubernetes cluster.  If it is omitted, the stack is not
+	// deployed to Kubernetes with raw manifests.
+	//
+	// The Manifests step always happens after the Kustomize step.
+	Manifests *Manifests `yaml:"manifests,omitempty"`
+
+	// Jsonnet describes a Jsonnet program that evaluates to Kubernetes
+	// resources to deploy to a Kubernetes cluster.  If it is omitted,
+	// the stack is not deployed to Kubernetes with Jsonnet.
+	//
+	// The Jsonnet step always happens after the Manifests step.
+	Jsonnet *Jsonnet `yaml:"jsonnet,omitempty"`
+
+	// Steps are additional, named deployment steps.  Contrary to the
+	// Container, Helm, Kustomize, Manifests, and Jsonnet fields, which give at
+	// most one step of each kind, executed in a fixed order, steps
+	// can be of any kind and number, and are executed as an acyclic
+	// dependency graph (see AllSteps).
+	Steps []DeployStep `yaml:"steps,omitempty" patchStrategy:"merge" patchMergeKey:"name" validate:"dive"`
+
+	// Readiness is a list of command hooks that are executed after
+	// all the deployment steps, to check that the stack is ready.
+	// The deployment fails if any of the hooks fails.
+	Readiness []CommandHook `yaml:"readiness,omitempty" validate:"dive"`
+
+	// PostRender is a list of commands that transform the resources
+	// expanded by the Helm, Kustomize, Manifests, and Jsonnet steps, before
+	// they are applied or linted.  Each command receives the resources,
+	// as a YAML stream, on its standard input, and must write the
+	// transformed resources on its standard output.  The commands are
+	// chained, in order.  The environment variables WARP_STACK_NAME,
+	// WARP_STACK_DNS_NAME, WARP_STACK_FAMILY, WARP_RUN_ID, and
+	// WARP_DEPLOY_STEP describe the stack.  The values of Env are
+	// subject to environment variable expansion, but are not templated.
+	// Helm releases (see Helm.Release) are not post-rendered.
+	PostRender []BaseCommand `yaml:"postRender,omitempty" validate:"dive"`
+
+	// Rollback indicates that, when the deployment fails, including
+	// when a readiness hook fails, the resources of the last successful
+	// deployment of the stack are applied again.  Only the resources
+	// from Helm charts rendered with "helm template", Kustomize, raw
+	// manifests, and Jsonnet are rolled back.
+	Rollback bool `yaml:"rollback,omitempty"`
 }
 
 // Container describes the deployment steps relative to
@@ -134,20 +209,42 @@ type Container struct {
 	// to actual addresses.
 	Manifest string `yaml:"manifest,omitempty"`
 
+	// Format is the format of the container manifest: "json" (the
+	// default), "yaml", "bazel", "skaffold" (or "ko"), or "env".  See
+	// the ContainerManifest*Format constants.
+	Format string `yaml:"format,omitempty" validate:"omitempty,oneof=json yaml bazel skaffold ko env"`
+
 	// ParsedManifest is populated by Read and contains the parsed
 	// version of the Manifest.
 	ParsedManifest ContainerManifest `yaml:"-"`
 
 	// Label gives a list of additional labels to apply to all the
 	// images in the manifest.  New images are created with these labels,
-	// and pushed to the container registry.
+	// and pushed to the container registry.  The labels are also applied
+	// to the images that are built (see ContainerManifestEntry.Build).
 	Label []string `yaml:"label,omitempty"`
 
 	// Push gives an alternative container registry to use for the
 	// stack.  The images in the manifest are tagged for this registry,
 	// then pushed to this registry.  This is useful, e.g., when
-	// wants to put images to a production registry.
+	// wants to put images to a production registry.  The images that
+	// are built are only pushed when Push is given.
 	Push string `yaml:"push,omitempty"`
+
+	// Load loads the images into the local Kubernetes cluster configured
+	// in config.Config.LocalCluster (kind, k3d, or minikube), instead of
+	// pushing them to a container registry.  The images are tagged with
+	// a content hash, so that they are not pulled from a registry with
+	// the default image pull policy.  Load cannot be used with Push.
+	Load bool `yaml:"load,omitempty"`
+
+	// Digest resolves the image references to immutable digests
+	// ("name@sha256:..."), so that stacks deployed at different times
+	// run the same images even if the tags are moved.  The images must
+	// be in a container registry, so Digest cannot be used with Load,
+	// and images that are built must be pushed (see Push).  The resolved
+	// references are recorded for each stack in the output folder.
+	Digest bool `yaml:"digest,omitempty"`
 }
 
 // ContainerManifest associates "shortcut" image names to actual container
@@ -158,7 +255,32 @@ type ContainerManifest map[string]ContainerManifestEntry
 // ContainerManifestEntry is an entry in the container manager.
 type ContainerManifestEntry struct {
 	// Ref is a reference to an actual image in a container registry.
+	// When Build is given, Ref is the name of the image to build, and
+	// the image is tagged with a content hash.
 	Ref string `json:"ref"`
+
+	// Build optionally describes how to build the image from a
+	// Dockerfile.
+	Build *ContainerBuild `json:"build,omitempty"`
+}
+
+// ContainerBuild describes how to build a container image from a
+// Dockerfile.
+type ContainerBuild struct {
+	// Context is the path to the build context, relative to the
+	// workspace root (see config.Config.WorkspaceDir).
+	Context string `json:"context"`
+
+	// Dockerfile is the path to the Dockerfile, relative to the build
+	// context.  It defaults to "Dockerfile".
+	Dockerfile string `json:"dockerfile,omitempty"`
+
+	// Args are build arguments.  The values are subject to environment
+	// variable expansion.
+	Args map[string]string `json:"args,omitempty"`
+
+	// Target is the build stage to build, for multi-stage builds.
+	Target string `json:"target,omitempty"`
 }
 
 // Helm describe the Help config to use to deploy to a Kubernetes cluster.
@@ -174,6 +296,95 @@ type Helm struct {
 	// If not specified, it defaults to `warp.stack=<stack name>`, where
 	// `<stack name>` is the name of the stack.
 	LabelSelector string `yaml:"labelSelector"`
+
+	// Images maps image reference placeholders, as given in the
+	// container manifest, to the paths of the chart values to set
+	// to the actual image references (see Container).  A values path
+	// can either be a single path, e.g. "image", that is set to the full
+	// image reference, or a pair of paths, e.g. "image.repository/image.tag",
+	// that are set to the image name and the image tag, respectively.
+	// A third path, e.g. "image.repository/image.tag/image.digest", is
+	// set to the image digest instead of the image tag when the image
+	// references have a digest (see Container.Digest).  Without a third
+	// path, the digest is appended to the tag, as in "1.0@sha256:...".
+	Images map[string]string `yaml:"images,omitempty"`
+
+	// ValuesFiles are paths to values files, relative to the workspace
+	// dir, to pass to the Helm CLI.  The values files of the bases are
+	// passed first.
+	ValuesFiles []string `yaml:"valuesFiles,omitempty" patchStrategy:"append"`
+
+	// Values are values to pass to the Helm CLI.  They take precedence
+	// over the values in ValuesFiles.  The strings they contain are
+	// subject to template expansion.
+	Values map[string]interface{} `yaml:"values,omitempty"`
+
+	// Release is true if the chart must be deployed as a Helm release,
+	// with "helm upgrade --install", instead of being expanded with
+	// "helm template" and applied with kubectl.  Release mode gives
+	// chart hooks, release history, and "helm test".  The release is
+	// named after the stack and the deploy step, and is uninstalled when
+	// the stack is garbage-collected.
+	Release bool `yaml:"release,omitempty"`
+
+	// Test is true if "helm test" must be run on the release after it
+	// is deployed.  The deployment fails if the tests fail.  Test is
+	// only used in release mode.
+	Test bool `yaml:"test,omitempty"`
+
+	// Timeout is the time to wait for the release to be ready, and for
+	// the tests to complete, e.g., "5m".  It defaults to the Helm default.
+	// Timeout is only used in release mode.
+	Timeout string `yaml:"timeout,omitempty"`
+}
+
+// Manifests describes raw Kubernetes manifests to deploy to
+// a Kubernetes cluster.
+//
+// The manifests are subject to template expansion.  The template
+// data has the following fields: "StackName" (the name of the stack),
+// "DNSName" (the name of the stack, usable in DNS names), "Family"
+// (the family of the stack), "RunID" (the ID of the current run of
+// warp), and "Images" (the image references, keyed by image reference
+// placeholder, see Container).
+type Manifests struct {
+	// Paths are glob patterns matching the manifest files, relative
+	// to the workspace dir.  A manifest file can contain multiple
+	// YAML documents.
+	Paths []string `yaml:"paths" validate:"required" patchStrategy:"append"`
+
+	// NamePrefix is true if the names of Kubernetes resources
+	// must be prefixed with the name of the stack.  Contrary to
+	// Kustomize, the references to the resources are not updated.
+	NamePrefix bool `yaml:"namePrefix,omitempty"`
+}
+
+// Jsonnet describes a Jsonnet program that evaluates to Kubernetes
+// resources to deploy to a Kubernetes cluster.
+//
+// The program is given the external variables "stackName", "dnsName",
+// "family", and "runID", as strings, and "images", as an object that
+// maps the image reference placeholders to the actual image references
+// (see Container).  The program can evaluate to a resource, a list
+// of resources ("kind: List"), or arrays and objects that contain
+// resources, at any depth.
+type Jsonnet struct {
+	// Path is the path to the Jsonnet file, relative to the workspace
+	// dir.
+	Path string `yaml:"path" validate:"required"`
+
+	// JPath is a list of library search paths, relative to the
+	// workspace dir.
+	JPath []string `yaml:"jpath,omitempty" patchStrategy:"append"`
+
+	// ExtVars are additional external variables, as strings.  The
+	// values are subject to environment variable expansion.
+	ExtVars map[string]string `yaml:"extVars,omitempty"`
+
+	// NamePrefix is true if the names of Kubernetes resources
+	// must be prefixed with the name of the stack.  The references
+	// to the resources are not updated.
+	NamePrefix bool `yaml:"namePrefix,omitempty"`
 }
 
 // Kustomize describes the Kustomize config to use to
@@ -191,6 +402,66 @@ type Kustomize struct {
 	// with a strategic merge.  The patches are subject to template
 	// expansion.
 	PatchesStrategicMerge []string `yaml:"patchesStrategicMerge,omitempty" patchStrategy:"append"`
+
+	// Namespace is the namespace to put all the resources in.  Stacks
+	// live in the "default" namespace, where they are garbage-collected,
+	// waited for, and diffed, so this is the only namespace accepted.
+	// Giving it moves into this namespace the resources of the base that
+	// are in other namespaces.
+	Namespace string `yaml:"namespace,omitempty"`
+
+	// CommonAnnotations are annotations to add to all the resources.
+	// The values are subject to template expansion.
+	CommonAnnotations map[string]string `yaml:"commonAnnotations,omitempty"`
+
+	// PatchesJSON6902 contains additional JSON patches (RFC 6902) to
+	// apply.  The patches are subject to template expansion.
+	PatchesJSON6902 []KustomizeJSONPatch `yaml:"patchesJson6902,omitempty" patchStrategy:"append" validate:"dive"`
+
+	// ConfigMapGenerator generates ConfigMaps.
+	ConfigMapGenerator []KustomizeGenerator `yaml:"configMapGenerator,omitempty" patchStrategy:"append" validate:"dive"`
+
+	// SecretGenerator generates Secrets.
+	SecretGenerator []KustomizeGenerator `yaml:"secretGenerator,omitempty" patchStrategy:"append" validate:"dive"`
+
+	// Components are paths to Kustomize components, relative to the
+	// workspace root (see config.Config.WorkspaceDir).
+	Components []string `yaml:"components,omitempty" patchStrategy:"append"`
+}
+
+// KustomizeJSONPatch is a JSON patch (RFC 6902) to apply to a resource.
+type KustomizeJSONPatch struct {
+	// Target identifies the resource to patch.
+	Target KustomizePatchTarget `yaml:"target"`
+
+	// Patch is the list of patch operations, in YAML or JSON.
+	Patch string `yaml:"patch" validate:"required"`
+}
+
+// KustomizePatchTarget identifies a resource to patch.
+type KustomizePatchTarget struct {
+	Group     string `yaml:"group,omitempty"`
+	Version   string `yaml:"version,omitempty"`
+	Kind      string `yaml:"kind" validate:"required"`
+	Name      string `yaml:"name" validate:"required"`
+	Namespace string `yaml:"namespace,omitempty"`
+}
+
+// KustomizeGenerator generates a ConfigMap or a Secret.  As usual
+// with Kustomize, a hash of the content is appended to the name of
+// the resource, and the references to the resource are updated
+// accordingly.
+type KustomizeGenerator struct {
+	// Name is the name of the resource, before prefixing and hashing.
+	Name string `yaml:"name" validate:"required"`
+
+	// Literals are the data of the resource, specified as "key=value"
+	// strings.  The values are subject to template expansion.
+	Literals []string `yaml:"literals,omitempty" patchStrategy:"append"`
+
+	// Type is the type of the Secret.  It defaults to "Opaque".  It
+	// is ignored for ConfigMaps.
+	Type string `yaml:"type,omitempty"`
 }
 
 // Setup defines the resources to set up and tear down around the
@@ -283,6 +554,17 @@ type CommandHook struct {
 	// HttpGet indicates that the hook must perform an HTTP Get.
 	HTTPGet *HTTPGet `yaml:"httpGet,omitempty"`
 
+	// Job indicates that the hook must run a Kubernetes Job in the stack.
+	Job *JobHook `yaml:"job,omitempty"`
+
+	// Exec indicates that the hook must execute a command in a container
+	// of the stack.
+	Exec *ExecHook `yaml:"exec,omitempty"`
+
+	// Copy indicates that the hook must copy files to or from a container
+	// of the stack.
+	Copy *CopyHook `yaml:"copy,omitempty"`
+
 	// Timeout in seconds after which the hook is considered to have failed.
 	// 0 indicates no timeout.
 	TimeoutSeconds int `yaml:"timeoutSeconds,omitempty"`
@@ -328,6 +610,82 @@ type HTTPGet struct {
 	HTTPHeaders []HTTPHeader `yaml:"httpHeaders"`
 }
 
+// JobHook is a hook that runs a container as a Kubernetes Job in the
+// stack, for instance to migrate or seed a database from within the
+// cluster.  The hook fails if the Job fails.
+type JobHook struct {
+	// Image is the container image to run.
+	Image string `yaml:"image" validate:"required"`
+
+	// Command is the entrypoint of the container.  If it is omitted, the
+	// entrypoint of the image is used.
+	Command []string `yaml:"command,omitempty"`
+
+	// Env is a list of environment variables, specified as "name=value"
+	// strings.  The values are subject to template substitution.  Note that
+	// "serviceAddress" gives an address that can only be reached locally:
+	// "k8sServiceName" must be used to reach a service from the Job.
+	Env []string `yaml:"env,omitempty"`
+
+	// ServiceAccountName is the service account to run the Job as.  If it
+	// is omitted, the default service account is used.
+	ServiceAccountName string `yaml:"serviceAccountName,omitempty"`
+}
+
+// ExecHook is a hook that executes a command in a running container of
+// the stack, as with "kubectl exec".  The hook fails if the command exits
+// with a non-zero status.
+type ExecHook struct {
+	// ServiceSelector selects the service whose pods are candidates for
+	// the execution.  The syntax is the same as for the service argument of
+	// the "serviceAddress" template function.
+	ServiceSelector string `yaml:"serviceSelector" validate:"required"`
+
+	// Container is the name of the container to execute the command in.
+	// If it is omitted, the first ready container is used.
+	Container string `yaml:"container,omitempty"`
+
+	// Command is the command to execute.  It is not run in a shell.
+	Command []string `yaml:"command" validate:"required,min=1"`
+}
+
+// CopyHook is a hook that copies a file or a folder between the local
+// file system and a running container of the stack, as with "kubectl cp".
+// The container must have a "tar" binary.
+type CopyHook struct {
+	// Direction is the direction of the copy.
+	Direction CopyDirection `yaml:"direction" validate:"required,oneof=upload download"`
+
+	// ServiceSelector selects the service whose pods are candidates for
+	// the copy.  The syntax is the same as for the service argument of
+	// the "serviceAddress" template function.
+	ServiceSelector string `yaml:"serviceSelector" validate:"required"`
+
+	// Container is the name of the container to copy to or from.  If it
+	// is omitted, the first ready container is used.
+	Container string `yaml:"container,omitempty"`
+
+	// Local is the local path.  It is given relative to the workspace
+	// dir, except for the steps executed after batch commands (see
+	// batches.AfterStep).
+	Local string `yaml:"local" validate:"required"`
+
+	// Remote is the absolute path in the container.  For uploads, the
+	// parent folder must exist.
+	Remote string `yaml:"remote" validate:"required"`
+}
+
+// CopyDirection is the direction of a copy.
+type CopyDirection string
+
+const (
+	// Upload copies from the local file system to the container.
+	Upload = CopyDirection("upload")
+
+	// Download copies from the container to the local file system.
+	Download = CopyDirection("download")
+)
+
 // HTTPHeader specifies the name and value of an HTTP header.
 type HTTPHeader struct {
 	// Name of the HTTP header
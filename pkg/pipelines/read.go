@@ -4,7 +4,6 @@
 package pipelines
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/hchauvin/warp/pkg/config"
 	"github.com/imdario/mergo"
@@ -40,6 +39,11 @@ func ReadFs(config *config.Config, path string, fs afero.Fs) (*Pipeline, error)
 		return nil, err
 	}
 
+	// Let's merge the deploy steps by the 'name' key
+	if err := p.mergeDeploySteps(); err != nil {
+		return nil, err
+	}
+
 	// Let's expand the setups with the content of their bases
 	if err := expandSetups(p); err != nil {
 		return nil, err
@@ -61,13 +65,26 @@ func ReadFs(config *config.Config, path string, fs afero.Fs) (*Pipeline, error)
 		}
 		p.Setups[i].Before = dedupedHooks
 	}
+	if err := validateDeploySteps(p.Deploy.AllSteps()); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	aliases := make(map[string]struct{}, len(p.Requires))
+	for _, req := range p.Requires {
+		if _, ok := aliases[req.Alias]; ok {
+			return nil, fmt.Errorf("%s: multiple required pipelines have the alias '%s'", path, req.Alias)
+		}
+		aliases[req.Alias] = struct{}{}
+	}
 
 	// Manifest parsing
-	if p.Deploy.Container != nil && p.Deploy.Container.Manifest != "" {
-		manifestPath := config.Path(p.Deploy.Container.Manifest)
-		p.Deploy.Container.ParsedManifest, err = parseContainerManifest(fs, manifestPath)
-		if err != nil {
-			return nil, fmt.Errorf("pipeline %s: cannot parse container manifest '%s': %v", path, manifestPath, err)
+	for _, step := range p.Deploy.AllSteps() {
+		c := step.Container
+		if c != nil && c.Manifest != "" {
+			manifestPath := config.Path(c.Manifest)
+			c.ParsedManifest, err = parseContainerManifest(fs, manifestPath, c.Format)
+			if err != nil {
+				return nil, fmt.Errorf("pipeline %s: cannot parse container manifest '%s': %v", path, manifestPath, err)
+			}
 		}
 	}
 
@@ -129,20 +146,6 @@ func read(
 	return pipeline, nil
 }
 
-func parseContainerManifest(fs afero.Fs, path string) (ContainerManifest, error) {
-	b, err := afero.ReadFile(fs, path)
-	if err != nil {
-		return nil, err
-	}
-
-	var manifest ContainerManifest
-	if err := json.Unmarshal(b, &manifest); err != nil {
-		return nil, err
-	}
-
-	return manifest, nil
-}
-
 func mergePipelines(dest, patch *Pipeline) error {
 	err := mergo.Merge(dest, patch, mergo.WithOverride, mergo.WithAppendSlice)
 	if err != nil {
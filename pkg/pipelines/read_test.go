@@ -68,6 +68,32 @@ func TestParseInvalidContainerManifest(t *testing.T) {
 	assert.Contains(t, err.Error(), "cannot parse container manifest")
 }
 
+func TestParseContainerManifestBuild(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	err := afero.WriteFile(fs, "/manifest.json", []byte(`{
+		"api": {"ref": "api", "build": {"context": "api", "target": "release"}}
+	}`), 0666)
+	assert.NoError(t, err)
+
+	manifest, err := parseContainerManifest(fs, "/manifest.json", "")
+	assert.NoError(t, err)
+	assert.Equal(t, ContainerManifest{
+		"api": {Ref: "api", Build: &ContainerBuild{Context: "api", Target: "release"}},
+	}, manifest)
+
+	err = afero.WriteFile(fs, "/manifest.json", []byte(`{"api": {"build": {"context": "api"}}}`), 0666)
+	assert.NoError(t, err)
+	_, err = parseContainerManifest(fs, "/manifest.json", "")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "the ref must be given")
+
+	err = afero.WriteFile(fs, "/manifest.json", []byte(`{"api": {"ref": "api", "build": {}}}`), 0666)
+	assert.NoError(t, err)
+	_, err = parseContainerManifest(fs, "/manifest.json", "")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "the build context must be given")
+}
+
 func TestCycle(t *testing.T) {
 	var base = []byte(`
 bases: ['overlay']
@@ -90,6 +116,28 @@ bases: ['base']
 	assert.Contains(t, err.Error(), "cycle detected")
 }
 
+func TestDuplicateRequirementAlias(t *testing.T) {
+	var pipeline = []byte(`
+stack:
+  name: foo
+requires:
+- pipeline: db
+  alias: db
+- pipeline: other_db
+  alias: db
+`)
+
+	cfg := &config.Config{WorkspaceDir: "/workspace"}
+
+	fs := afero.NewMemMapFs()
+	err := afero.WriteFile(fs, "/workspace/base/pipeline.yml", pipeline, 0666)
+	assert.NoError(t, err)
+
+	_, err = ReadFs(cfg, "base/pipeline.yml", fs)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "multiple required pipelines have the alias 'db'")
+}
+
 func TestPipelineFileNotExist(t *testing.T) {
 	cfg := &config.Config{WorkspaceDir: "/workspace"}
 	fs := afero.NewMemMapFs()
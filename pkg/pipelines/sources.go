@@ -0,0 +1,81 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package pipelines
+
+import (
+	"github.com/hchauvin/warp/pkg/config"
+	"path/filepath"
+	"sort"
+)
+
+// Sources gives the paths of the files and folders the pipeline is built
+// from: the pipeline file itself, its bases, the required pipelines, and,
+// for all the deploy steps, the Kustomize and Helm paths, the Helm values
+// files, the raw manifests, the Jsonnet files and library paths, the
+// container manifests, the build contexts of the container images, and the
+// working dirs of the commands.  The paths are given relative to the
+// workspace dir, with forward slashes.
+func (pipeline *Pipeline) Sources(cfg *config.Config) []string {
+	var sources []string
+	add := func(path string) {
+		if path == "" {
+			return
+		}
+		if filepath.IsAbs(path) {
+			rel, err := filepath.Rel(cfg.WorkspaceDir, path)
+			if err != nil {
+				return
+			}
+			path = rel
+		}
+		sources = append(sources, filepath.ToSlash(path))
+	}
+
+	add(pipeline.Path)
+	for _, base := range pipeline.Bases {
+		add(base)
+	}
+	for _, req := range pipeline.Requires {
+		add(req.Pipeline)
+	}
+	for _, step := range pipeline.Deploy.AllSteps() {
+		if step.Container != nil {
+			add(step.Container.Manifest)
+			var contexts []string
+			for _, entry := range step.Container.ParsedManifest {
+				if entry.Build != nil {
+					contexts = append(contexts, entry.Build.Context)
+				}
+			}
+			sort.Strings(contexts)
+			for _, context := range contexts {
+				add(context)
+			}
+		}
+		if step.Helm != nil {
+			add(step.Helm.Path)
+			for _, valuesFile := range step.Helm.ValuesFiles {
+				add(valuesFile)
+			}
+		}
+		if step.Kustomize != nil {
+			add(step.Kustomize.Path)
+		}
+		if step.Manifests != nil {
+			for _, p := range step.Manifests.Paths {
+				add(p)
+			}
+		}
+		if step.Jsonnet != nil {
+			add(step.Jsonnet.Path)
+			for _, p := range step.Jsonnet.JPath {
+				add(p)
+			}
+		}
+		if step.Run != nil {
+			add(step.Run.WorkingDir)
+		}
+	}
+	return sources
+}
@@ -0,0 +1,57 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package pipelines
+
+import (
+	"github.com/hchauvin/warp/pkg/config"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSources(t *testing.T) {
+	cfg := &config.Config{WorkspaceDir: "/workspace"}
+
+	fs := afero.NewMemMapFs()
+	err := afero.WriteFile(fs, "/workspace/base/pipeline.yml", []byte(`
+stack:
+  family: foo
+deploy:
+  container:
+    manifest: container/manifest.json
+  helm:
+    path: charts/foo
+    valuesFiles: ['charts/values.yml']
+`), 0666)
+	assert.NoError(t, err)
+	err = afero.WriteFile(fs, "/workspace/container/manifest.json", containerManifestBytes, 0666)
+	assert.NoError(t, err)
+	err = afero.WriteFile(fs, "/workspace/overlay/pipeline.yml", []byte(`
+bases: ['base']
+deploy:
+  kustomize:
+    path: overlay
+  jsonnet:
+    path: jsonnet/stack.jsonnet
+    jpath: ['jsonnet/lib']
+`), 0666)
+	assert.NoError(t, err)
+
+	p, err := ReadFs(cfg, "overlay", fs)
+	assert.NoError(t, err)
+
+	assert.Equal(
+		t,
+		[]string{
+			"overlay/pipeline.yml",
+			"base",
+			"container/manifest.json",
+			"charts/foo",
+			"charts/values.yml",
+			"overlay",
+			"jsonnet/stack.jsonnet",
+			"jsonnet/lib",
+		},
+		p.Sources(cfg))
+}
@@ -0,0 +1,225 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package pipelines
+
+import (
+	"fmt"
+	"github.com/imdario/mergo"
+	"regexp"
+	"strings"
+)
+
+// stackNamespace is the namespace of the stacks (see k8s.StackNamespace).
+const stackNamespace = "default"
+
+// stepNameRe matches the step names that are valid Kubernetes label
+// values.
+var stepNameRe = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9_-]{0,61}[A-Za-z0-9])?$`)
+
+// DeployStep is a named deployment step.  A step has one action
+// (Container, Helm, Kustomize, Manifests, Jsonnet, or Run), an optional WaitFor,
+// or both, in which case the step waits after the action completes.
+type DeployStep struct {
+	// Name is the name of the step.  It is used to reference the
+	// step in the dependencies of other steps.  It is also the value of
+	// a Kubernetes label on the resources the step applies, and must then
+	// start and end with an alphanumeric character, and have at most 63
+	// characters.
+	Name string `yaml:"name" validate:"required,name"`
+
+	// DependsOn lists the name of other steps that must be executed
+	// successfully before this step is executed.  The image references
+	// produced by the Container steps are available to the steps
+	// that depend on them, directly or indirectly.
+	DependsOn []string `yaml:"dependsOn,omitempty" validate:"name"`
+
+	// Container gives the deployment operations relative to
+	// containerization.
+	Container *Container `yaml:"container,omitempty"`
+
+	// Helm gives a Helm chart to deploy.
+	Helm *Helm `yaml:"helm,omitempty"`
+
+	// Kustomize gives a Kustomize config to deploy.
+	Kustomize *Kustomize `yaml:"kustomize,omitempty"`
+
+	// Manifests gives raw Kubernetes manifests to deploy.
+	Manifests *Manifests `yaml:"manifests,omitempty"`
+
+	// Jsonnet gives a Jsonnet program that evaluates to Kubernetes
+	// resources to deploy.
+	Jsonnet *Jsonnet `yaml:"jsonnet,omitempty"`
+
+	// Run gives a command to execute.
+	Run *BaseCommand `yaml:"run,omitempty"`
+
+	// WaitFor indicates that the step must wait for the resources
+	// of the stack to be in a certain state.
+	WaitFor *WaitForHook `yaml:"waitFor,omitempty"`
+}
+
+// Names of the steps that are created from the Container, Helm,
+// Kustomize, Manifests, and Jsonnet fields of Deploy.
+const (
+	ContainerStep = "container"
+	HelmStep      = "helm"
+	KustomizeStep = "kustomize"
+	ManifestsStep = "manifests"
+	JsonnetStep   = "jsonnet"
+)
+
+// AllSteps gives all the deployment steps.  The Container, Helm,
+// Kustomize, Manifests, and Jsonnet fields of Deploy are turned into steps
+// named after their kind, that are executed in this order, and
+// before the steps in Steps.
+func (deploy *Deploy) AllSteps() []DeployStep {
+	var steps []DeployStep
+	var last string
+	add := func(step DeployStep) {
+		if last != "" {
+			step.DependsOn = []string{last}
+		}
+		last = step.Name
+		steps = append(steps, step)
+	}
+	if deploy.Container != nil {
+		add(DeployStep{Name: ContainerStep, Container: deploy.Container})
+	}
+	if deploy.Helm != nil {
+		add(DeployStep{Name: HelmStep, Helm: deploy.Helm})
+	}
+	if deploy.Kustomize != nil {
+		add(DeployStep{Name: KustomizeStep, Kustomize: deploy.Kustomize})
+	}
+	if deploy.Manifests != nil {
+		add(DeployStep{Name: ManifestsStep, Manifests: deploy.Manifests})
+	}
+	if deploy.Jsonnet != nil {
+		add(DeployStep{Name: JsonnetStep, Jsonnet: deploy.Jsonnet})
+	}
+
+	for _, step := range deploy.Steps {
+		if last != "" {
+			step.DependsOn = append([]string{last}, step.DependsOn...)
+		}
+		steps = append(steps, step)
+	}
+	return steps
+}
+
+// validateDeploySteps validates the deployment steps as an acyclic
+// dependency graph.
+func validateDeploySteps(steps []DeployStep) error {
+	stepsByName := make(map[string]*DeployStep, len(steps))
+	for i := range steps {
+		step := &steps[i]
+		if _, ok := stepsByName[step.Name]; ok {
+			return fmt.Errorf("multiple deploy steps are named '%s'", step.Name)
+		}
+		stepsByName[step.Name] = step
+		if !stepNameRe.MatchString(step.Name) {
+			return fmt.Errorf(
+				"deploy step '%s': the name must start and end with an alphanumeric character, and have at most 63 characters",
+				step.Name)
+		}
+
+		actionCount := 0
+		for _, action := range []bool{
+			step.Container != nil,
+			step.Helm != nil,
+			step.Kustomize != nil,
+			step.Manifests != nil,
+			step.Jsonnet != nil,
+			step.Run != nil,
+		} {
+			if action {
+				actionCount++
+			}
+		}
+		if actionCount > 1 || (actionCount == 0 && step.WaitFor == nil) {
+			return fmt.Errorf("deploy step '%s': there must be one action, a waitFor, or both", step.Name)
+		}
+		if step.Kustomize != nil && step.Kustomize.Namespace != "" && step.Kustomize.Namespace != stackNamespace {
+			return fmt.Errorf(
+				"deploy step '%s': namespace '%s' is not supported, as stacks live in the '%s' namespace",
+				step.Name,
+				step.Kustomize.Namespace,
+				stackNamespace)
+		}
+		if step.WaitFor != nil {
+			if err := step.WaitFor.validate(); err != nil {
+				return fmt.Errorf("deploy step '%s': invalid waitFor: %v", step.Name, err)
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(steps))
+	var visit func(step *DeployStep, path []string) error
+	visit = func(step *DeployStep, path []string) error {
+		switch state[step.Name] {
+		case visiting:
+			return fmt.Errorf("deploy steps: cycle detected: %s", strings.Join(append(path, step.Name), " -> "))
+		case visited:
+			return nil
+		}
+		state[step.Name] = visiting
+		for _, dep := range step.DependsOn {
+			depStep, ok := stepsByName[dep]
+			if !ok {
+				return fmt.Errorf(
+					"deploy step '%s' depends on step '%s', but this step does not exist",
+					step.Name,
+					dep)
+			}
+			if err := visit(depStep, append(path, step.Name)); err != nil {
+				return err
+			}
+		}
+		state[step.Name] = visited
+		return nil
+	}
+	for i := range steps {
+		if err := visit(&steps[i], nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeDeploySteps merges the deploy steps by the 'name' key
+func (p *Pipeline) mergeDeploySteps() error {
+	var stepNames []string
+	stepsByName := make(map[string]DeployStep)
+	hasMerged := false
+	for _, step := range p.Deploy.Steps {
+		prev, ok := stepsByName[step.Name]
+		if !ok {
+			stepsByName[step.Name] = step
+			stepNames = append(stepNames, step.Name)
+		} else {
+			hasMerged = true
+			err := mergo.Merge(
+				&prev,
+				&step,
+				mergo.WithOverride,
+				mergo.WithAppendSlice)
+			if err != nil {
+				return err
+			}
+			stepsByName[step.Name] = prev
+		}
+	}
+	if hasMerged {
+		p.Deploy.Steps = nil
+		for _, name := range stepNames {
+			p.Deploy.Steps = append(p.Deploy.Steps, stepsByName[name])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package pipelines
+
+import (
+	"github.com/hchauvin/warp/pkg/config"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestAllSteps(t *testing.T) {
+	deploy := Deploy{
+		Container: &Container{Manifest: "manifest.json"},
+		Kustomize: &Kustomize{Path: "kustomize"},
+		Steps: []DeployStep{
+			{Name: "crds", Helm: &Helm{Path: "crds"}},
+			{Name: "operator", Helm: &Helm{Path: "operator"}, DependsOn: []string{"crds"}},
+		},
+	}
+
+	assert.Equal(t, []DeployStep{
+		{Name: ContainerStep, Container: deploy.Container},
+		{Name: KustomizeStep, Kustomize: deploy.Kustomize, DependsOn: []string{ContainerStep}},
+		{Name: "crds", Helm: deploy.Steps[0].Helm, DependsOn: []string{KustomizeStep}},
+		{Name: "operator", Helm: deploy.Steps[1].Helm, DependsOn: []string{KustomizeStep, "crds"}},
+	}, deploy.AllSteps())
+
+	assert.Empty(t, (&Deploy{}).AllSteps())
+}
+
+func TestValidateDeploySteps(t *testing.T) {
+	waitFor := &WaitForHook{Resources: []WaitForResourceKind{Pods}}
+	cases := []struct {
+		steps []DeployStep
+		err   string
+	}{
+		{
+			steps: []DeployStep{
+				{Name: "a", Run: &BaseCommand{Command: []string{"true"}}, WaitFor: waitFor},
+				{Name: "b", WaitFor: waitFor, DependsOn: []string{"a"}},
+			},
+		},
+		{
+			steps: []DeployStep{{Name: "a"}},
+			err:   "deploy step 'a': there must be one action, a waitFor, or both",
+		},
+		{
+			steps: []DeployStep{{Name: "a", Helm: &Helm{}, Kustomize: &Kustomize{}}},
+			err:   "deploy step 'a': there must be one action, a waitFor, or both",
+		},
+		{
+			steps: []DeployStep{{Name: "a", WaitFor: &WaitForHook{}}},
+			err:   "deploy step 'a': invalid waitFor: expected at least one resource kind to wait for",
+		},
+		{
+			steps: []DeployStep{{Name: "a", Helm: &Helm{}}, {Name: "a", Helm: &Helm{}}},
+			err:   "multiple deploy steps are named 'a'",
+		},
+		{
+			steps: []DeployStep{{Name: "a", Helm: &Helm{}, DependsOn: []string{"b"}}},
+			err:   "deploy step 'a' depends on step 'b', but this step does not exist",
+		},
+		{
+			steps: []DeployStep{
+				{Name: "a", Helm: &Helm{}, DependsOn: []string{"b"}},
+				{Name: "b", Helm: &Helm{}, DependsOn: []string{"a"}},
+			},
+			err: "deploy steps: cycle detected: a -> b -> a",
+		},
+		{
+			steps: []DeployStep{{Name: "a", Kustomize: &Kustomize{Namespace: "default"}}},
+		},
+		{
+			steps: []DeployStep{{Name: "a", Kustomize: &Kustomize{Namespace: "other"}}},
+			err:   "deploy step 'a': namespace 'other' is not supported, as stacks live in the 'default' namespace",
+		},
+		{
+			steps: []DeployStep{{Name: "a-", Helm: &Helm{}}},
+			err:   "deploy step 'a-': the name must start and end with an alphanumeric character, and have at most 63 characters",
+		},
+	}
+	for _, c := range cases {
+		err := validateDeploySteps(c.steps)
+		if c.err == "" {
+			assert.NoError(t, err)
+		} else {
+			assert.EqualError(t, err, c.err)
+		}
+	}
+}
+
+func TestMergeDeploySteps(t *testing.T) {
+	p := &Pipeline{
+		Deploy: Deploy{
+			Steps: []DeployStep{
+				{Name: "foo", Helm: &Helm{Path: "foo"}, DependsOn: []string{"bar"}},
+				{Name: "bar", Helm: &Helm{Path: "bar"}},
+				{Name: "foo", WaitFor: &WaitForHook{Resources: []WaitForResourceKind{Pods}}},
+			},
+		},
+	}
+
+	err := p.mergeDeploySteps()
+	assert.NoError(t, err)
+
+	assert.Equal(t, []DeployStep{
+		{
+			Name:      "foo",
+			Helm:      &Helm{Path: "foo"},
+			DependsOn: []string{"bar"},
+			WaitFor:   &WaitForHook{Resources: []WaitForResourceKind{Pods}},
+		},
+		{Name: "bar", Helm: &Helm{Path: "bar"}},
+	}, p.Deploy.Steps)
+}
+
+func TestReadDeploySteps(t *testing.T) {
+	cfg := &config.Config{WorkspaceDir: "/workspace"}
+
+	fs := afero.NewMemMapFs()
+	err := afero.WriteFile(fs, "/workspace/folder/pipeline.yml", []byte(`
+stack:
+  family: foo
+deploy:
+  kustomize:
+    path: kustomize
+  steps:
+    - name: images
+      container:
+        manifest: container/manifest.json
+    - name: app
+      dependsOn: [images]
+      helm:
+        path: chart
+      waitFor:
+        resources: [pods]
+`), 0666)
+	assert.NoError(t, err)
+	err = afero.WriteFile(fs, "/workspace/container/manifest.json", containerManifestBytes, 0666)
+	assert.NoError(t, err)
+
+	p, err := ReadFs(cfg, "folder/pipeline.yml", fs)
+	assert.NoError(t, err)
+	assert.Len(t, p.Deploy.Steps, 2)
+	assert.NotNil(t, p.Deploy.Steps[0].Container.ParsedManifest)
+
+	err = afero.WriteFile(fs, "/workspace/invalid/pipeline.yml", []byte(`
+stack:
+  family: foo
+deploy:
+  kustomize:
+    path: kustomize
+  steps:
+    - name: kustomize
+      helm:
+        path: chart
+`), 0666)
+	assert.NoError(t, err)
+
+	_, err = ReadFs(cfg, "invalid/pipeline.yml", fs)
+	assert.EqualError(t, err, "invalid/pipeline.yml: multiple deploy steps are named 'kustomize'")
+}
@@ -12,40 +12,19 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
-	"time"
 )
 
 // GracefulCommandContext has the same purpose as exec.CommandContext,
 // but the cancellation is done gracefully, and the process descendants
 // are killed as well.
 func GracefulCommandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
-	cmd := exec.Command(name, args...)
-	go func() {
-		<-ctx.Done()
-
-		// cmd.Process is not nil when the process has been started.
-		// cmd.ProcessState is nil when the process has not yet exited.
-		if cmd.Process != nil && cmd.ProcessState == nil {
-			pid := cmd.Process.Pid
-			// We launch a go routine to forcefully kill the process if
-			// it couldn't be killed gracefully.
-			done := make(chan struct{}, 1)
-			go func() {
-				select {
-				case <-done:
-					// The process was gracefully killed
-				case <-time.After(3 * time.Second):
-					// After a timeout, we forcefully kill the process
-					if cmd.ProcessState == nil {
-						Kill(pid, true)
-					}
-				}
-			}()
-			Kill(pid, true)
-			done <- struct{}{}
-		}
-	}()
-
+	cmd := exec.CommandContext(ctx, name, args...)
+	// Cancel is only called once the process has been started, and
+	// before it is waited for, so that cmd.Process can be accessed
+	// without a data race.
+	cmd.Cancel = func() error {
+		return Kill(cmd.Process.Pid, true)
+	}
 	return cmd
 }
 
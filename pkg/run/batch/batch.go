@@ -25,6 +25,7 @@ import (
 	"golang.org/x/sync/semaphore"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -39,7 +40,21 @@ type RunBatchOptions struct {
 	Bail                 bool
 	Advisory             bool
 	Reporter             Reporter
-	Events               chan<- interface{}
+	// Reporters are additional reporters.  RunBatch reports to
+	// Reporter and Reporters through a MultiReporter.
+	Reporters []Reporter
+	// ReportDir is the report folder.  The files downloaded by the steps
+	// executed after the commands (see batches.AfterStep) are put there.
+	ReportDir string
+	Events    chan<- interface{}
+	// Watch is true if, after the commands are run, RunBatch must
+	// watch for file changes and rerun the affected commands,
+	// until the context is cancelled.  The stacks stay held in
+	// between.
+	Watch bool
+	// WatchInterval is the interval at which the files are polled
+	// for changes in watch mode.  It defaults to DefaultWatchInterval.
+	WatchInterval time.Duration
 }
 
 // Reporter is used by RunBatch to report on batch execution.
@@ -111,25 +126,21 @@ func RunBatch(
 	options *RunBatchOptions,
 	k8sClient *k8s.K8s,
 ) error {
+	reporter := NewMultiReporter(
+		cfg.Logger(),
+		append([]Reporter{options.Reporter}, options.Reporters...)...)
 	defer func() {
-		if err := options.Reporter.Finalize(); err != nil {
+		if err := reporter.Finalize(); err != nil {
 			cfg.Logger().Error(logDomain, "cannot finalize report: %v", err)
 		}
 	}()
 
-	completed := make(map[string]chan struct{})
-	completionStatus := make(map[string]completionStatus)
-	var completionMut sync.RWMutex
-	for _, cmd := range batch.Commands {
-		completionStatus[cmd.Name] = pending
-		completed[cmd.Name] = make(chan struct{})
-	}
-
 	batchID := petname.Generate(2, "-")
 	runner := &runner{
 		cfg:       cfg,
 		k8sClient: k8sClient,
 		options:   options,
+		reporter:  reporter,
 		pipelines: make(map[string]*pipeline),
 		trans:     make(map[string]*env.Transformer),
 		sharedEnv: []string{
@@ -171,7 +182,40 @@ func RunBatch(
 		len(batch.Commands),
 		options.Parallelism)
 
+	err := runner.run(ctx, batch)
+	if !options.Watch {
+		return err
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		cfg.Logger().Error(logDomain, "%v", err)
+	}
+	return runner.watch(ctx, batch)
+}
+
+// run runs the commands of a batch.  The stacks that are held
+// stay held after run returns, and are released by clean.
+func (runner *runner) run(ctx context.Context, batch *batches.Batch) error {
+	cfg := runner.cfg
+	k8sClient := runner.k8sClient
+	options := runner.options
+
+	completed := make(map[string]chan struct{})
+	completionStatus := make(map[string]completionStatus)
+	var completionMut sync.RWMutex
+	for _, cmd := range batch.Commands {
+		completionStatus[cmd.Name] = pending
+		completed[cmd.Name] = make(chan struct{})
+	}
+
+	runner.erroredMut.Lock()
+	runner.errored = nil
+	runner.erroredMut.Unlock()
+
 	cmdSema := semaphore.NewWeighted(int64(options.Parallelism))
+	cmdLocks := newLocks(batch)
 
 	g, gctx := errgroup.WithContext(ctx)
 	for _, cmd := range batch.Commands {
@@ -205,6 +249,15 @@ func RunBatch(
 				}
 			}
 
+			// The locks are acquired before the parallelism slot, so
+			// that a command waiting for a lock does not prevent
+			// other commands from executing.
+			releaseLocks, err := cmdLocks.acquire(gctx, cfg.Logger(), cmd.Name, cmd.Locks)
+			if err != nil {
+				return err
+			}
+			defer releaseLocks()
+
 			if err := cmdSema.Acquire(gctx, 1); err != nil {
 				return err
 			}
@@ -247,6 +300,7 @@ type runner struct {
 	cfg        *config.Config
 	k8sClient  *k8s.K8s
 	options    *RunBatchOptions
+	reporter   Reporter
 	pipelines  map[string]*pipeline
 	stacksMut  sync.Mutex
 	trans      map[string]*env.Transformer
@@ -268,10 +322,16 @@ func (runner *runner) clean() {
 	var g sync.WaitGroup
 	for _, pipeline := range runner.pipelines {
 		for _, stack := range pipeline.stackHolder.stacks {
+			stack := stack
 			g.Add(1)
 			go func() {
 				defer g.Done()
-				stack.release()
+				if err := stack.release(); err != nil {
+					runner.cfg.Logger().Warning(logDomain, "could not release stack %s: %v", stack.name.DNSName(), err)
+				}
+				if stack.releaseRequired != nil {
+					stack.releaseRequired()
+				}
 			}()
 		}
 	}
@@ -293,6 +353,19 @@ func (runner *runner) hold(ctx context.Context, pipelineName string, exclusive b
 	})
 }
 
+// holdRequired holds the stacks required by a stack, and deploys them.
+// They are released by clean.
+func (runner *runner) holdRequired(ctx context.Context, stack *stackInfo, pipeline *pipelines.Pipeline) error {
+	required, requiredErrc, releaseRequired, err := stacks.HoldRequired(ctx, runner.cfg, pipeline, runner.k8sClient)
+	if err != nil {
+		return fmt.Errorf("cannot hold the stacks required by stack %s: %v", stack.name, err)
+	}
+	stack.required = required
+	stack.requiredErrc = requiredErrc
+	stack.releaseRequired = releaseRequired
+	return nil
+}
+
 func (runner *runner) release(pipelineName, stackName string) {
 	runner.pipelines[pipelineName].stackHolder.release(stackName)
 }
@@ -340,11 +413,14 @@ func (runner *runner) execCommand(
 				pipeline, err := runner.pipeline(stack.pipelineName)
 				result.Completed = time.Now()
 				result.Err = errToStringPtr(err)
-				runner.options.Reporter.EnvironmentSetupResult(&result)
+				runner.reporter.EnvironmentSetupResult(&result)
 				if err != nil {
 					return err
 				}
-				if err := deploy.Exec(gctx, cfg, pipeline.pipeline, stack.name, k8sClient); err != nil {
+				if err := runner.holdRequired(gctx, stack, pipeline.pipeline); err != nil {
+					return err
+				}
+				if err := deploy.Exec(env.WithRequiredStacks(gctx, stack.required), cfg, pipeline.pipeline, stack.name, k8sClient); err != nil {
 					return fmt.Errorf("deploy failed for stack %s: %v", stack.name, err)
 				}
 				close(stack.deployedc)
@@ -370,7 +446,7 @@ func (runner *runner) execCommand(
 				pipeline, err := runner.pipeline(stack.pipelineName)
 				result.Completed = time.Now()
 				result.Err = errToStringPtr(err)
-				runner.options.Reporter.EnvironmentSetupResult(&result)
+				runner.reporter.EnvironmentSetupResult(&result)
 				if err != nil {
 					return err
 				}
@@ -380,7 +456,7 @@ func (runner *runner) execCommand(
 						return err
 					}
 					err = run.ExecHooks(
-						ctx,
+						env.WithRequiredStacks(ctx, stack.required),
 						cfg,
 						stack.name,
 						"before",
@@ -436,6 +512,11 @@ func (runner *runner) execCommand(
 						runner.cfg.Logger().Error(logDomain, "detached error: %v", err)
 						cancelDetached()
 					}
+				case err := <-stack.requiredErrc:
+					if err != nil {
+						runner.cfg.Logger().Error(logDomain, "detached error: %v", err)
+						cancelDetached()
+					}
 				}
 			}()
 		}
@@ -467,7 +548,7 @@ func (runner *runner) execCommand(
 				for _, e := range setup.Env {
 					e := e
 					genv.Go(func() error {
-						s, err := runner.transGet(genvctx, stack.name, e)
+						s, err := runner.transGet(env.WithRequiredStacks(genvctx, stack.required), stack.name, e)
 						if err != nil {
 							return err
 						}
@@ -539,7 +620,7 @@ func (runner *runner) execCommand(
 			}
 			combinedOutput := io.MultiReader(stdout, stderr)
 			go func() {
-				w, err := runner.options.Reporter.CommandOutput(&info)
+				w, err := runner.reporter.CommandOutput(&info)
 				if err != nil {
 					cfg.Logger().Error("run:"+cmd.Name, "%v", err)
 					return
@@ -549,6 +630,7 @@ func (runner *runner) execCommand(
 				scanner := bufio.NewScanner(combinedOutput)
 				for scanner.Scan() {
 					cfg.Logger().Info("run:"+cmd.Name, "%s", scanner.Text())
+					runner.logEvent(cmd.Name, scanner.Text())
 					if _, err := w.Write(append(scanner.Bytes(), '\n')); err != nil {
 						cfg.Logger().Error("run:"+cmd.Name, "could not write to log file: %v", err)
 						return
@@ -570,18 +652,25 @@ func (runner *runner) execCommand(
 
 		if err == nil {
 			cfg.Logger().Info("run:"+cmd.Name, "SUCCESS")
-			runner.options.Reporter.CommandResult(&result)
+			runner.reporter.CommandResult(&result)
 			break
 		}
 		cfg.Logger().Error("run:"+cmd.Name, "%v", err)
 		result.Err = errToStringPtr(err)
-		runner.options.Reporter.CommandResult(&result)
+		runner.reporter.CommandResult(&result)
 		if tries == maxTries {
 			break
 		}
 		tries++
 	}
 
+	if afterErr := runner.execAfterSteps(stackCtx, cmd, stacks); afterErr != nil {
+		cfg.Logger().Error("run:"+cmd.Name, "%v", afterErr)
+		if err == nil {
+			err = afterErr
+		}
+	}
+
 	if err != nil {
 		if runner.options.Bail {
 			return fmt.Errorf("could not run '%s': %v", cmd.Name, err)
@@ -597,6 +686,35 @@ func (runner *runner) execCommand(
 	return nil
 }
 
+// execAfterSteps executes the steps that follow a batch command.
+func (runner *runner) execAfterSteps(ctx context.Context, cmd *batches.BatchCommand, stacks []*stackInfo) error {
+	if len(cmd.After) == 0 {
+		return nil
+	}
+	if runner.options.ReportDir == "" {
+		runner.cfg.Logger().Warning("run:"+cmd.Name, "no report folder: skipping the steps after the command")
+		return nil
+	}
+	for i, step := range cmd.After {
+		var stack *stackInfo
+		for _, s := range stacks {
+			if s.pipelineName == step.Pipeline {
+				stack = s
+				break
+			}
+		}
+		if stack == nil {
+			// Pipelines were checked when the batch was read.
+			panic(fmt.Sprintf("no stack for pipeline '%s'", step.Pipeline))
+		}
+		localPath := filepath.Join(runner.options.ReportDir, "files", CommandNameToPath(cmd.Name), step.Copy.Local)
+		if err := run.Copy(ctx, runner.cfg, stack.name, step.Copy, localPath, runner.k8sClient); err != nil {
+			return fmt.Errorf("after[%d]: %v", i, err)
+		}
+	}
+	return nil
+}
+
 func (runner *runner) transGet(ctx context.Context, name names.Name, tplStr string) (string, error) {
 	runner.transMut.Lock()
 	trans, ok := runner.trans[name.DNSName()]
@@ -624,6 +742,14 @@ func (runner *runner) event(event interface{}) {
 	}
 }
 
+// logEvent reports a line of output.  Contrary to event, it is a no-op
+// when there is no event channel: the line is already logged.
+func (runner *runner) logEvent(name string, line string) {
+	if runner.options.Events != nil {
+		runner.options.Events <- interactive.LogEvent{Name: name, Line: line}
+	}
+}
+
 func errToStringPtr(err error) *string {
 	if err == nil {
 		return nil
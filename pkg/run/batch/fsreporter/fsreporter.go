@@ -11,10 +11,18 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"strings"
 	"sync"
 )
 
+// Format is the report format under which FsReporter is registered.
+const Format = "fs"
+
+func init() {
+	batch.RegisterReporter(Format, func(path string) (batch.Reporter, error) {
+		return New(path)
+	})
+}
+
 // FsReporter implements batch.Reporter.
 type FsReporter struct {
 	Path   string
@@ -79,38 +87,5 @@ func (reporter *FsReporter) Finalize() error {
 }
 
 func (reporter *FsReporter) commandOutputPath(info *batch.CommandInfo) string {
-	return filepath.Join(reporter.Path, "log", fmt.Sprintf("%s.%d.txt", commandNameToPath(info.Name), info.Tries))
-}
-
-const allowedRunes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_"
-
-func commandNameToPath(commandName string) string {
-	var baseName strings.Builder
-	var tags []string
-	var curTag strings.Builder
-	var inTag bool
-	for _, c := range commandName {
-		if c == '[' {
-			inTag = true
-		} else if c == ']' {
-			tags = append(tags, curTag.String())
-			curTag.Reset()
-			inTag = false
-		} else if inTag {
-			curTag.WriteRune(c)
-		} else if strings.ContainsRune(allowedRunes, c) {
-			baseName.WriteRune(c)
-		} else {
-			baseName.WriteRune('_')
-		}
-	}
-
-	var path strings.Builder
-	for _, tag := range tags {
-		path.WriteString(tag)
-		path.WriteRune(os.PathSeparator)
-	}
-	path.WriteString(baseName.String())
-
-	return path.String()
+	return filepath.Join(reporter.Path, "log", fmt.Sprintf("%s.%d.txt", batch.CommandNameToPath(info.Name), info.Tries))
 }
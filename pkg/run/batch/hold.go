@@ -31,6 +31,12 @@ type stackInfo struct {
 	before        atomic.Bool
 	deployedc     chan struct{}
 	initializedc  chan struct{}
+	// required are the names of the stacks required by the stack, by
+	// alias.  They are held when the stack is deployed, and released
+	// with releaseRequired.
+	required        map[string]names.Name
+	requiredErrc    <-chan error
+	releaseRequired func()
 }
 
 func newStackHolder() stackHolder {
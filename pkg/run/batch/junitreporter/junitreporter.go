@@ -0,0 +1,189 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+// Package junitreporter implements a batch reporter producing JUnit XML
+// reports, as understood by most Continuous Integration systems.
+package junitreporter
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"github.com/hchauvin/warp/pkg/run/batch"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Format is the report format under which JUnitReporter is registered.
+const Format = "junit"
+
+// FileName is the name of the JUnit XML file within the report folder.
+const FileName = "junit.xml"
+
+func init() {
+	batch.RegisterReporter(Format, func(path string) (batch.Reporter, error) {
+		return New(path), nil
+	})
+}
+
+// JUnitReporter implements batch.Reporter.  Every command is reported
+// as a test case.  Only the last try of a command is reported.  Environment
+// setups are reported as test cases as well, in a separate test suite.
+type JUnitReporter struct {
+	Path               string
+	mut                sync.Mutex
+	environmentResults []batch.EnvironmentSetupResult
+	results            map[string]batch.CommandResult
+	resultNames        []string
+	outputs            map[string]*bytes.Buffer
+}
+
+// New creates a JUnitReporter.  The report is written, upon finalization,
+// to the FileName file in the folder at path.
+func New(path string) *JUnitReporter {
+	return &JUnitReporter{
+		Path:    path,
+		results: make(map[string]batch.CommandResult),
+		outputs: make(map[string]*bytes.Buffer),
+	}
+}
+
+// EnvironmentSetupResult implements batch.Reporter.
+func (reporter *JUnitReporter) EnvironmentSetupResult(result *batch.EnvironmentSetupResult) {
+	reporter.mut.Lock()
+	defer reporter.mut.Unlock()
+	reporter.environmentResults = append(reporter.environmentResults, *result)
+}
+
+// CommandOutput implements batch.Reporter.
+func (reporter *JUnitReporter) CommandOutput(info *batch.CommandInfo) (io.WriteCloser, error) {
+	reporter.mut.Lock()
+	defer reporter.mut.Unlock()
+	buf := &bytes.Buffer{}
+	reporter.outputs[info.Name] = buf
+	return &outputWriter{reporter, buf}, nil
+}
+
+// CommandResult implements batch.Reporter.
+func (reporter *JUnitReporter) CommandResult(result *batch.CommandResult) {
+	reporter.mut.Lock()
+	defer reporter.mut.Unlock()
+	if _, ok := reporter.results[result.Name]; !ok {
+		reporter.resultNames = append(reporter.resultNames, result.Name)
+	}
+	reporter.results[result.Name] = *result
+}
+
+// Finalize implements batch.Reporter.
+func (reporter *JUnitReporter) Finalize() error {
+	reporter.mut.Lock()
+	defer reporter.mut.Unlock()
+
+	suites := testSuites{}
+
+	commands := testSuite{Name: "commands"}
+	for _, name := range reporter.resultNames {
+		result := reporter.results[name]
+		tc := testCase{
+			Name:      name,
+			ClassName: "batch." + result.BatchID,
+			Time:      seconds(result.Completed.Sub(result.Started).Seconds()),
+		}
+		if output, ok := reporter.outputs[name]; ok {
+			tc.SystemOut = output.String()
+		}
+		if result.Err != nil {
+			tc.Failure = &failure{
+				Message: *result.Err,
+				Text:    fmt.Sprintf("failed after %d tries: %s", result.Tries, *result.Err),
+			}
+			commands.Failures++
+		}
+		commands.Tests++
+		commands.Time += tc.Time
+		commands.TestCases = append(commands.TestCases, tc)
+	}
+	suites.TestSuites = append(suites.TestSuites, commands)
+
+	if len(reporter.environmentResults) > 0 {
+		environments := testSuite{Name: "environments"}
+		for _, result := range reporter.environmentResults {
+			tc := testCase{
+				Name:      fmt.Sprintf("%s %s", result.StackName, result.SetupType),
+				ClassName: "batch." + result.BatchID,
+				Time:      seconds(result.Completed.Sub(result.Started).Seconds()),
+			}
+			if result.Err != nil {
+				tc.Failure = &failure{Message: *result.Err, Text: *result.Err}
+				environments.Failures++
+			}
+			environments.Tests++
+			environments.Time += tc.Time
+			environments.TestCases = append(environments.TestCases, tc)
+		}
+		suites.TestSuites = append(suites.TestSuites, environments)
+	}
+
+	b, err := xml.MarshalIndent(suites, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(reporter.Path, 0777); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(
+		filepath.Join(reporter.Path, FileName),
+		append([]byte(xml.Header), b...),
+		0777)
+}
+
+type outputWriter struct {
+	reporter *JUnitReporter
+	buf      *bytes.Buffer
+}
+
+func (w *outputWriter) Write(p []byte) (int, error) {
+	w.reporter.mut.Lock()
+	defer w.reporter.mut.Unlock()
+	return w.buf.Write(p)
+}
+
+func (w *outputWriter) Close() error {
+	return nil
+}
+
+type seconds float64
+
+func (s seconds) MarshalXMLAttr(name xml.Name) (xml.Attr, error) {
+	return xml.Attr{Name: name, Value: fmt.Sprintf("%.3f", float64(s))}, nil
+}
+
+type testSuites struct {
+	XMLName    xml.Name    `xml:"testsuites"`
+	TestSuites []testSuite `xml:"testsuite"`
+}
+
+type testSuite struct {
+	Name      string     `xml:"name,attr"`
+	Tests     int        `xml:"tests,attr"`
+	Failures  int        `xml:"failures,attr"`
+	Time      seconds    `xml:"time,attr"`
+	TestCases []testCase `xml:"testcase"`
+}
+
+type testCase struct {
+	Name      string   `xml:"name,attr"`
+	ClassName string   `xml:"classname,attr"`
+	Time      seconds  `xml:"time,attr"`
+	Failure   *failure `xml:"failure,omitempty"`
+	SystemOut string   `xml:"system-out,omitempty"`
+}
+
+type failure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
@@ -0,0 +1,63 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package junitreporter
+
+import (
+	"github.com/hchauvin/warp/pkg/run/batch"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestJUnitReporter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "junitreporter")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	r := New(dir)
+
+	started := time.Unix(0, 0)
+	errStr := "__error__"
+
+	for tries := 1; tries <= 2; tries++ {
+		info := batch.CommandInfo{BatchID: "id", Name: "flaky", Tries: tries}
+		w, err := r.CommandOutput(&info)
+		assert.NoError(t, err)
+		_, err = w.Write([]byte("try " + strconv.Itoa(tries) + "\n"))
+		assert.NoError(t, err)
+		assert.NoError(t, w.Close())
+		result := batch.CommandResult{
+			CommandInfo: info,
+			Started:     started,
+			Completed:   started.Add(time.Second),
+		}
+		if tries == 1 {
+			result.Err = &errStr
+		}
+		r.CommandResult(&result)
+	}
+	r.CommandResult(&batch.CommandResult{
+		CommandInfo: batch.CommandInfo{BatchID: "id", Name: "fail", Tries: 1},
+		Err:         &errStr,
+		Started:     started,
+		Completed:   started.Add(2 * time.Second),
+	})
+
+	assert.NoError(t, r.Finalize())
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, FileName))
+	assert.NoError(t, err)
+	report := string(b)
+
+	assert.Contains(t, report, `<testsuite name="commands" tests="2" failures="1" time="3.000">`)
+	assert.Contains(t, report, `<testcase name="flaky" classname="batch.id" time="1.000">`)
+	assert.Contains(t, report, `<system-out>try 2`)
+	assert.NotContains(t, report, `try 1`)
+	assert.Contains(t, report, `<failure message="__error__">failed after 1 tries: __error__</failure>`)
+	assert.NotContains(t, report, `name="environments"`)
+}
@@ -0,0 +1,90 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package batch
+
+import (
+	"context"
+	"github.com/hchauvin/warp/pkg/batches"
+	"github.com/hchauvin/warp/pkg/log"
+	"golang.org/x/sync/semaphore"
+	"sort"
+	"strings"
+)
+
+// locks holds the named locks of a batch (see batches.Batch.Locks).
+type locks struct {
+	semas map[string]*semaphore.Weighted
+}
+
+func newLocks(batch *batches.Batch) *locks {
+	counts := make(map[string]int)
+	for _, cmd := range batch.Commands {
+		for _, name := range cmd.Locks {
+			counts[name] = 1
+		}
+	}
+	for _, lock := range batch.Locks {
+		if lock.Count > 0 {
+			counts[lock.Name] = lock.Count
+		} else {
+			counts[lock.Name] = 1
+		}
+	}
+
+	semas := make(map[string]*semaphore.Weighted, len(counts))
+	for name, count := range counts {
+		semas[name] = semaphore.NewWeighted(int64(count))
+	}
+	return &locks{semas}
+}
+
+// acquire acquires the given locks on behalf of a command.  The locks
+// are always acquired in the same order, to prevent deadlocks.  The
+// returned function releases them.
+func (l *locks) acquire(
+	ctx context.Context,
+	logger *log.Logger,
+	cmdName string,
+	names []string,
+) (func(), error) {
+	sorted := sortedUnique(names)
+
+	var acquired []*semaphore.Weighted
+	release := func() {
+		for _, sema := range acquired {
+			sema.Release(1)
+		}
+	}
+
+	for _, name := range sorted {
+		sema := l.semas[name]
+		if !sema.TryAcquire(1) {
+			logger.Info(logDomain, "command %s: waiting for lock %s", cmdName, name)
+			if err := sema.Acquire(ctx, 1); err != nil {
+				release()
+				return nil, err
+			}
+		}
+		acquired = append(acquired, sema)
+	}
+
+	if len(sorted) > 0 {
+		logger.Info(logDomain, "command %s: acquired locks %s", cmdName, strings.Join(sorted, ", "))
+	}
+	return release, nil
+}
+
+func sortedUnique(names []string) []string {
+	set := make(map[string]struct{}, len(names))
+	var unique []string
+	for _, name := range names {
+		if _, ok := set[name]; ok {
+			continue
+		}
+		set[name] = struct{}{}
+		unique = append(unique, name)
+	}
+	sort.Strings(unique)
+	return unique
+}
@@ -0,0 +1,65 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package batch
+
+import (
+	"context"
+	"github.com/hchauvin/warp/pkg/batches"
+	"github.com/hchauvin/warp/pkg/log"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestLocksMutex(t *testing.T) {
+	l := newLocks(&batches.Batch{
+		Commands: []batches.BatchCommand{
+			{Name: "a", Locks: []string{"db"}},
+		},
+	})
+
+	release, err := l.acquire(context.Background(), &log.Logger{}, "a", []string{"db", "db"})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = l.acquire(ctx, &log.Logger{}, "b", []string{"db"})
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	release()
+	release, err = l.acquire(context.Background(), &log.Logger{}, "b", []string{"db"})
+	assert.NoError(t, err)
+	release()
+}
+
+func TestLocksCount(t *testing.T) {
+	l := newLocks(&batches.Batch{
+		Locks: []batches.Lock{{Name: "quota", Count: 2}},
+		Commands: []batches.BatchCommand{
+			{Name: "a", Locks: []string{"quota", "db"}},
+		},
+	})
+
+	release1, err := l.acquire(context.Background(), &log.Logger{}, "a", []string{"quota"})
+	assert.NoError(t, err)
+	release2, err := l.acquire(context.Background(), &log.Logger{}, "b", []string{"quota", "db"})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = l.acquire(ctx, &log.Logger{}, "c", []string{"quota"})
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// A failed acquisition releases the locks it already acquired.
+	release1()
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel2()
+	_, err = l.acquire(ctx2, &log.Logger{}, "d", []string{"db", "quota"})
+	assert.Equal(t, context.DeadlineExceeded, err)
+	release3, err := l.acquire(context.Background(), &log.Logger{}, "e", []string{"quota"})
+	assert.NoError(t, err)
+
+	release2()
+	release3()
+}
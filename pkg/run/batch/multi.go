@@ -0,0 +1,160 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package batch
+
+import (
+	"errors"
+	"fmt"
+	"github.com/hchauvin/warp/pkg/log"
+	"io"
+	"strings"
+	"sync"
+)
+
+const multiReporterLogDomain = logDomain + ".reporter"
+
+// MultiReporter fans out the Reporter calls to multiple reporters.
+// The failure of one reporter, be it an error or a panic, does not
+// affect the other reporters.  Failures are logged, except for the
+// errors returned by Finalize.
+type MultiReporter struct {
+	logger    *log.Logger
+	reporters []Reporter
+}
+
+// NewMultiReporter creates a MultiReporter.  Nil reporters are ignored.
+func NewMultiReporter(logger *log.Logger, reporters ...Reporter) *MultiReporter {
+	r := &MultiReporter{logger: logger}
+	for _, reporter := range reporters {
+		if reporter != nil {
+			r.reporters = append(r.reporters, reporter)
+		}
+	}
+	return r
+}
+
+// EnvironmentSetupResult implements Reporter.
+func (r *MultiReporter) EnvironmentSetupResult(result *EnvironmentSetupResult) {
+	for i, reporter := range r.reporters {
+		reporter := reporter
+		r.isolate(i, "EnvironmentSetupResult", func() error {
+			reporter.EnvironmentSetupResult(result)
+			return nil
+		})
+	}
+}
+
+// CommandOutput implements Reporter.  The writers of the individual
+// reporters that fail to open are skipped.
+func (r *MultiReporter) CommandOutput(info *CommandInfo) (io.WriteCloser, error) {
+	w := &multiWriteCloser{
+		reporter: r,
+		writers:  make(map[int]io.WriteCloser, len(r.reporters)),
+	}
+	for i, reporter := range r.reporters {
+		i, reporter := i, reporter
+		r.isolate(i, "CommandOutput", func() error {
+			rw, err := reporter.CommandOutput(info)
+			if err != nil {
+				return err
+			}
+			w.writers[i] = rw
+			return nil
+		})
+	}
+	return w, nil
+}
+
+// CommandResult implements Reporter.
+func (r *MultiReporter) CommandResult(result *CommandResult) {
+	for i, reporter := range r.reporters {
+		reporter := reporter
+		r.isolate(i, "CommandResult", func() error {
+			reporter.CommandResult(result)
+			return nil
+		})
+	}
+}
+
+// Finalize implements Reporter.  All the reporters are finalized, and
+// their errors are combined.  The errors are not logged, as it is up to
+// the caller to report the combined error.
+func (r *MultiReporter) Finalize() error {
+	var errs []string
+	for i, reporter := range r.reporters {
+		reporter := reporter
+		if err := r.recoverError(i, "Finalize", reporter.Finalize); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// isolate calls f, recovering from panics.  Errors are logged and returned.
+func (r *MultiReporter) isolate(i int, method string, f func() error) error {
+	err := r.recoverError(i, method, f)
+	if err != nil {
+		r.logger.Error(multiReporterLogDomain, "%v", err)
+	}
+	return err
+}
+
+// recoverError calls f, recovering from panics.  Errors are returned,
+// and not logged.
+func (r *MultiReporter) recoverError(i int, method string, f func() error) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+		if err != nil {
+			err = fmt.Errorf("reporter #%d (%T): %s: %v", i, r.reporters[i], method, err)
+		}
+	}()
+	return f()
+}
+
+type multiWriteCloser struct {
+	reporter *MultiReporter
+	mut      sync.Mutex
+	writers  map[int]io.WriteCloser
+}
+
+// Write writes to all the writers.  A writer that errors is closed and
+// no longer written to.  Write never errors.
+func (w *multiWriteCloser) Write(p []byte) (int, error) {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	for i, rw := range w.writers {
+		i, rw := i, rw
+		err := w.reporter.isolate(i, "CommandOutput.Write", func() error {
+			_, err := rw.Write(p)
+			return err
+		})
+		if err != nil {
+			rw.Close()
+			delete(w.writers, i)
+		}
+	}
+	return len(p), nil
+}
+
+// Close closes all the writers.
+func (w *multiWriteCloser) Close() error {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	var errs []string
+	for i, rw := range w.writers {
+		if err := w.reporter.isolate(i, "CommandOutput.Close", rw.Close); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	w.writers = nil
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
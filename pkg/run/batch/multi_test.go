@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package batch
+
+import (
+	"bytes"
+	"errors"
+	"github.com/hchauvin/warp/pkg/log"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+type recordingReporter struct {
+	output  bytes.Buffer
+	results []CommandResult
+}
+
+func (r *recordingReporter) EnvironmentSetupResult(result *EnvironmentSetupResult) {}
+
+func (r *recordingReporter) CommandOutput(info *CommandInfo) (io.WriteCloser, error) {
+	return &nopCloser{&r.output}, nil
+}
+
+func (r *recordingReporter) CommandResult(result *CommandResult) {
+	r.results = append(r.results, *result)
+}
+
+func (r *recordingReporter) Finalize() error { return nil }
+
+type failingReporter struct{}
+
+func (r *failingReporter) EnvironmentSetupResult(result *EnvironmentSetupResult) {
+	panic("__panic__")
+}
+
+func (r *failingReporter) CommandOutput(info *CommandInfo) (io.WriteCloser, error) {
+	return nil, errors.New("__output_error__")
+}
+
+func (r *failingReporter) CommandResult(result *CommandResult) {
+	panic("__panic__")
+}
+
+func (r *failingReporter) Finalize() error {
+	return errors.New("__finalize_error__")
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+func TestMultiReporterIsolatesFailures(t *testing.T) {
+	logs := &bytes.Buffer{}
+	recording := &recordingReporter{}
+	r := NewMultiReporter(&log.Logger{Writer: logs}, &failingReporter{}, nil, recording)
+
+	r.EnvironmentSetupResult(&EnvironmentSetupResult{})
+
+	w, err := r.CommandOutput(&CommandInfo{Name: "cmd"})
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("hello"))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	assert.Equal(t, "hello", recording.output.String())
+
+	r.CommandResult(&CommandResult{CommandInfo: CommandInfo{Name: "cmd"}})
+	assert.Len(t, recording.results, 1)
+
+	err = r.Finalize()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "__finalize_error__")
+
+	assert.Contains(t, logs.String(), "__panic__")
+	assert.Contains(t, logs.String(), "__output_error__")
+	// The caller of Finalize logs the error.
+	assert.NotContains(t, logs.String(), "__finalize_error__")
+}
+
+func TestMultiReporterDropsFailingWriters(t *testing.T) {
+	r := NewMultiReporter(&log.Logger{Writer: ioutil.Discard}, &writeFailingReporter{})
+
+	w, err := r.CommandOutput(&CommandInfo{Name: "cmd"})
+	assert.NoError(t, err)
+	n, err := w.Write([]byte("hello"))
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.Len(t, w.(*multiWriteCloser).writers, 0)
+}
+
+type writeFailingReporter struct {
+	NoopReporter
+}
+
+func (r *writeFailingReporter) CommandOutput(info *CommandInfo) (io.WriteCloser, error) {
+	return &nopCloser{&failingWriter{}}, nil
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("__write_error__")
+}
+
+func TestReporterRegistry(t *testing.T) {
+	RegisterReporter("__test__", func(path string) (Reporter, error) {
+		return &NoopReporter{}, nil
+	})
+
+	r, err := NewReporter("__test__", "path")
+	assert.NoError(t, err)
+	assert.IsType(t, &NoopReporter{}, r)
+	assert.Contains(t, ReporterFormats(), "__test__")
+
+	assert.Panics(t, func() {
+		RegisterReporter("__test__", nil)
+	})
+
+	_, err = NewReporter("__unknown__", "path")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown report format '__unknown__'")
+}
@@ -0,0 +1,47 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package batch
+
+import (
+	"os"
+	"strings"
+)
+
+const allowedRunes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_"
+
+// CommandNameToPath gives a relative path for a batch command.  The
+// tags of the command name ("[tag]") become folders, and the characters
+// of the rest of the name that are not alphanumeric, "-" or "_" are
+// replaced by "_".  The reports of the command are organized along this
+// path.
+func CommandNameToPath(commandName string) string {
+	var baseName strings.Builder
+	var tags []string
+	var curTag strings.Builder
+	var inTag bool
+	for _, c := range commandName {
+		if c == '[' {
+			inTag = true
+		} else if c == ']' {
+			tags = append(tags, curTag.String())
+			curTag.Reset()
+			inTag = false
+		} else if inTag {
+			curTag.WriteRune(c)
+		} else if strings.ContainsRune(allowedRunes, c) {
+			baseName.WriteRune(c)
+		} else {
+			baseName.WriteRune('_')
+		}
+	}
+
+	var path strings.Builder
+	for _, tag := range tags {
+		path.WriteString(tag)
+		path.WriteRune(os.PathSeparator)
+	}
+	path.WriteString(baseName.String())
+
+	return path.String()
+}
@@ -0,0 +1,17 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package batch
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCommandNameToPath(t *testing.T) {
+	assert.Equal(t, "foo_bar", CommandNameToPath("foo/bar"))
+	assert.Equal(t, "__foo_bar", CommandNameToPath("./foo/bar"))
+	assert.Equal(t, "tag/foo_bar", CommandNameToPath("[tag]foo/bar"))
+	assert.Equal(t, "tag1/tag2/foo_bar", CommandNameToPath("[tag1][tag2]foo/bar"))
+	assert.Equal(t, "tag/Hello_world", CommandNameToPath("Hello[tag] world"))
+}
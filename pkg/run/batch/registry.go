@@ -0,0 +1,57 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package batch
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ReporterFactory creates a reporter given the report path.
+type ReporterFactory func(path string) (Reporter, error)
+
+var (
+	reporterFactories    = make(map[string]ReporterFactory)
+	reporterFactoriesMut sync.RWMutex
+)
+
+// RegisterReporter registers a reporter factory for a report format.
+// Reporter packages typically call RegisterReporter in an init
+// function.  Panics if the format is already registered.
+func RegisterReporter(format string, factory ReporterFactory) {
+	reporterFactoriesMut.Lock()
+	defer reporterFactoriesMut.Unlock()
+	if _, ok := reporterFactories[format]; ok {
+		panic(fmt.Sprintf("reporter format '%s' is already registered", format))
+	}
+	reporterFactories[format] = factory
+}
+
+// NewReporter creates a reporter for a registered report format.
+func NewReporter(format string, path string) (Reporter, error) {
+	reporterFactoriesMut.RLock()
+	factory, ok := reporterFactories[format]
+	reporterFactoriesMut.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf(
+			"unknown report format '%s'; available formats: %s",
+			format,
+			strings.Join(ReporterFormats(), " "))
+	}
+	return factory(path)
+}
+
+// ReporterFormats gives the sorted list of registered report formats.
+func ReporterFormats() []string {
+	reporterFactoriesMut.RLock()
+	defer reporterFactoriesMut.RUnlock()
+	formats := make([]string, 0, len(reporterFactories))
+	for format := range reporterFactories {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
@@ -0,0 +1,293 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package batch
+
+import (
+	"context"
+	"fmt"
+	"github.com/hchauvin/warp/pkg/batches"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultWatchInterval is the default for RunBatchOptions.WatchInterval.
+const DefaultWatchInterval = time.Second
+
+// watch polls the files of the commands of a batch for changes, and
+// reruns the affected commands, until the context is cancelled.
+func (runner *runner) watch(ctx context.Context, batch *batches.Batch) error {
+	interval := runner.options.WatchInterval
+	if interval == 0 {
+		interval = DefaultWatchInterval
+	}
+
+	roots := watchRoots(batch)
+	runner.cfg.Logger().Info(
+		logDomain,
+		"watching %s for changes; press Ctrl-C to stop",
+		strings.Join(roots, ", "))
+
+	excluded, err := runner.watchExcluded()
+	if err != nil {
+		return err
+	}
+
+	files, err := snapshotFiles(runner.cfg.WorkspaceDir, roots, excluded)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		nextFiles, err := snapshotFiles(runner.cfg.WorkspaceDir, roots, excluded)
+		if err != nil {
+			return err
+		}
+		changed := changedFiles(files, nextFiles)
+		files = nextFiles
+		if len(changed) == 0 {
+			continue
+		}
+
+		affected, err := affectedCommands(batch, changed)
+		if err != nil {
+			return err
+		}
+		if len(affected.Commands) == 0 {
+			runner.cfg.Logger().Info(logDomain, "%d files changed; no command affected", len(changed))
+			continue
+		}
+
+		var names []string
+		for _, cmd := range affected.Commands {
+			names = append(names, cmd.Name)
+		}
+		runner.cfg.Logger().Info(
+			logDomain,
+			"%d files changed; rerunning %s",
+			len(changed),
+			strings.Join(names, ", "))
+
+		if err := runner.run(ctx, affected); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			runner.cfg.Logger().Error(logDomain, "%v", err)
+		}
+		runner.cfg.Logger().Info(logDomain, "waiting for changes")
+	}
+}
+
+// watchExcluded gives the absolute paths to the folders that must not
+// be watched, as the batch writes to them: the report folder and the
+// output root.  Otherwise, the commands could be rerun because of the
+// files they produce.
+func (runner *runner) watchExcluded() ([]string, error) {
+	var excluded []string
+	if runner.options.ReportDir != "" {
+		reportDir, err := filepath.Abs(runner.options.ReportDir)
+		if err != nil {
+			return nil, err
+		}
+		excluded = append(excluded, reportDir)
+	}
+	if runner.cfg.OutputRoot != "" {
+		outputRoot, err := filepath.Abs(runner.cfg.Path(runner.cfg.OutputRoot))
+		if err != nil {
+			return nil, err
+		}
+		excluded = append(excluded, outputRoot)
+	}
+	return excluded, nil
+}
+
+// watchPatterns gives the path patterns, relative to the workspace dir,
+// that affect a command: its working dir and its paths.
+func watchPatterns(cmd *batches.BatchCommand) []string {
+	patterns := append([]string(nil), cmd.Paths...)
+	if cmd.WorkingDir != "" {
+		patterns = append(patterns, cmd.WorkingDir)
+	}
+	return patterns
+}
+
+// affectedCommands gives the commands of a batch that are affected by
+// changed files.  The dependencies on commands that are not affected
+// are removed, as these commands already ran.
+func affectedCommands(batch *batches.Batch, changed []string) (*batches.Batch, error) {
+	var commands []batches.BatchCommand
+	selected := make(map[string]struct{})
+	for _, cmd := range batch.Commands {
+		affected, err := anyMatch(watchPatterns(&cmd), changed)
+		if err != nil {
+			return nil, fmt.Errorf("command '%s': %v", cmd.Name, err)
+		}
+		if affected {
+			commands = append(commands, cmd)
+			selected[cmd.Name] = struct{}{}
+		}
+	}
+
+	for i := range commands {
+		var dependsOn []string
+		for _, dep := range commands[i].DependsOn {
+			if _, ok := selected[dep]; ok {
+				dependsOn = append(dependsOn, dep)
+			}
+		}
+		commands[i].DependsOn = dependsOn
+	}
+
+	return &batches.Batch{
+		Pipelines: batch.Pipelines,
+		Commands:  commands,
+		Locks:     batch.Locks,
+	}, nil
+}
+
+func anyMatch(patterns []string, files []string) (bool, error) {
+	for _, pattern := range patterns {
+		for _, file := range files {
+			ok, err := batches.MatchPath(pattern, file)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// watchRoots gives the folders, relative to the workspace dir, that
+// must be walked to detect changes to the files of the commands of
+// a batch.  Nested folders are omitted.
+func watchRoots(batch *batches.Batch) []string {
+	var roots []string
+	for _, cmd := range batch.Commands {
+		for _, pattern := range watchPatterns(&cmd) {
+			roots = append(roots, staticPrefix(pattern))
+		}
+	}
+	sort.Strings(roots)
+
+	var pruned []string
+	for _, root := range roots {
+		if len(pruned) > 0 {
+			last := pruned[len(pruned)-1]
+			if root == last || last == "." || strings.HasPrefix(root, last+"/") {
+				continue
+			}
+		}
+		pruned = append(pruned, root)
+	}
+	return pruned
+}
+
+// staticPrefix gives the leading path components of a pattern that
+// do not contain any glob meta character.
+func staticPrefix(pattern string) string {
+	pattern = strings.TrimPrefix(path.Clean(filepath.ToSlash(pattern)), "./")
+	var prefix []string
+	for _, part := range strings.Split(pattern, "/") {
+		if strings.ContainsAny(part, `*?[\`) {
+			break
+		}
+		prefix = append(prefix, part)
+	}
+	if len(prefix) == 0 {
+		return "."
+	}
+	return path.Join(prefix...)
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// snapshotFiles gives the state of all the files under some roots, keyed
+// by path relative to the workspace dir.  Missing roots are ignored, and
+// so are the files under the excluded folders, given as absolute paths.
+func snapshotFiles(workspaceDir string, roots []string, excluded []string) (map[string]fileState, error) {
+	files := make(map[string]fileState)
+	for _, root := range roots {
+		err := filepath.Walk(filepath.Join(workspaceDir, root), func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if info.IsDir() {
+				if info.Name() == ".git" {
+					return filepath.SkipDir
+				}
+				isExcluded, err := underAny(p, excluded)
+				if err != nil {
+					return err
+				}
+				if isExcluded {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			rel, err := filepath.Rel(workspaceDir, p)
+			if err != nil {
+				return err
+			}
+			files[filepath.ToSlash(rel)] = fileState{info.ModTime(), info.Size()}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("cannot watch '%s': %v", root, err)
+		}
+	}
+	return files, nil
+}
+
+// underAny checks whether a path is one of some absolute folder paths,
+// or is under one of them.
+func underAny(p string, folders []string) (bool, error) {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return false, err
+	}
+	for _, folder := range folders {
+		if abs == folder || strings.HasPrefix(abs, folder+string(filepath.Separator)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// changedFiles gives the files that were added, removed, or modified
+// between two snapshots.
+func changedFiles(before, after map[string]fileState) []string {
+	var changed []string
+	for p, state := range after {
+		if prev, ok := before[p]; !ok || prev != state {
+			changed = append(changed, p)
+		}
+	}
+	for p := range before {
+		if _, ok := after[p]; !ok {
+			changed = append(changed, p)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
@@ -0,0 +1,130 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package batch
+
+import (
+	"github.com/hchauvin/warp/pkg/batches"
+	"github.com/hchauvin/warp/pkg/config"
+	"github.com/hchauvin/warp/pkg/pipelines"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStaticPrefix(t *testing.T) {
+	assert.Equal(t, "foo/bar", staticPrefix("foo/bar"))
+	assert.Equal(t, "foo", staticPrefix("./foo/*.go"))
+	assert.Equal(t, "foo", staticPrefix("foo/**/qux.go"))
+	assert.Equal(t, ".", staticPrefix("**/*.go"))
+	assert.Equal(t, ".", staticPrefix(""))
+}
+
+func TestWatchRoots(t *testing.T) {
+	batch := &batches.Batch{
+		Commands: []batches.BatchCommand{
+			{
+				Name:        "a",
+				BaseCommand: pipelines.BaseCommand{WorkingDir: "tests/a"},
+			},
+			{
+				Name:  "b",
+				Paths: []string{"tests/**/*.go", "lib/b"},
+			},
+			{Name: "c"},
+		},
+	}
+	assert.Equal(t, []string{"lib/b", "tests"}, watchRoots(batch))
+}
+
+func TestAffectedCommands(t *testing.T) {
+	batch := &batches.Batch{
+		Commands: []batches.BatchCommand{
+			{
+				Name:        "a",
+				BaseCommand: pipelines.BaseCommand{WorkingDir: "tests/a"},
+			},
+			{
+				Name:      "b",
+				Paths:     []string{"lib/**/*.go"},
+				DependsOn: []string{"a"},
+			},
+			{
+				Name:      "c",
+				Paths:     []string{"lib/c"},
+				DependsOn: []string{"a", "b"},
+			},
+			{Name: "d"},
+		},
+	}
+
+	affected, err := affectedCommands(batch, []string{"lib/c/main.go"})
+	assert.NoError(t, err)
+	assert.Len(t, affected.Commands, 2)
+	assert.Equal(t, "b", affected.Commands[0].Name)
+	assert.Empty(t, affected.Commands[0].DependsOn)
+	assert.Equal(t, "c", affected.Commands[1].Name)
+	assert.Equal(t, []string{"b"}, affected.Commands[1].DependsOn)
+
+	affected, err = affectedCommands(batch, []string{"tests/a/test.sh"})
+	assert.NoError(t, err)
+	assert.Len(t, affected.Commands, 1)
+	assert.Equal(t, "a", affected.Commands[0].Name)
+
+	affected, err = affectedCommands(batch, []string{"README.md"})
+	assert.NoError(t, err)
+	assert.Empty(t, affected.Commands)
+}
+
+func TestSnapshotFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "tests", ".git"), 0777))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "tests", "a.go"), []byte("a"), 0666))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "tests", "b.go"), []byte("b"), 0666))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "tests", ".git", "HEAD"), []byte("c"), 0666))
+
+	roots := []string{"tests", "missing"}
+	before, err := snapshotFiles(dir, roots, nil)
+	assert.NoError(t, err)
+	assert.Len(t, before, 2)
+	assert.Empty(t, changedFiles(before, before))
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "tests", "a.go"), []byte("aa"), 0666))
+	assert.NoError(t, os.Remove(filepath.Join(dir, "tests", "b.go")))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "tests", "c.go"), []byte("c"), 0666))
+	later := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(filepath.Join(dir, "tests", "a.go"), later, later))
+
+	after, err := snapshotFiles(dir, roots, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"tests/a.go", "tests/b.go", "tests/c.go"}, changedFiles(before, after))
+}
+
+func TestSnapshotFilesExcluded(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// The report folder and the output root are in the workspace.
+	for _, p := range []string{"tests/a.go", "report/files/a.txt", ".warp/manifests/a.yml"} {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, p)), 0777))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, p), []byte("a"), 0666))
+	}
+	runner := &runner{
+		cfg:     &config.Config{WorkspaceDir: dir, OutputRoot: ".warp"},
+		options: &RunBatchOptions{ReportDir: filepath.Join(dir, "report")},
+	}
+	excluded, err := runner.watchExcluded()
+	assert.NoError(t, err)
+
+	files, err := snapshotFiles(dir, []string{"."}, excluded)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+	assert.Contains(t, files, "tests/a.go")
+}
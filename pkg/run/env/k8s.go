@@ -56,6 +56,24 @@ func (funcs *k8sTemplateFuncs) TxtFuncMap(ctx context.Context) template.FuncMap
 				exposedTCPPort,
 			)
 		},
+		"requiredServiceAddress": func(alias, service string, exposedTCPPort int) (string, error) {
+			return funcs.memoize(
+				func() (string, error) {
+					return funcs.requiredServiceAddress(ctx, alias, service, exposedTCPPort)
+				},
+				"requiredServiceAddress",
+				alias,
+				service,
+				exposedTCPPort,
+			)
+		},
+		"requiredStackName": func(alias string) (string, error) {
+			name, err := requiredStack(ctx, alias)
+			if err != nil {
+				return "", err
+			}
+			return name.DNSName(), nil
+		},
 		"k8sServiceName": func(namespace, service string) (string, error) {
 			return funcs.memoize(
 				func() (string, error) {
@@ -99,13 +117,38 @@ func (funcs *k8sTemplateFuncs) serviceAddress(
 	return funcs.k8sServiceAddress(ctx, "default", service, exposedTCPPort)
 }
 
+func (funcs *k8sTemplateFuncs) requiredServiceAddress(
+	ctx context.Context,
+	alias string,
+	service string,
+	exposedTCPPort int,
+) (string, error) {
+	name, err := requiredStack(ctx, alias)
+	if err != nil {
+		return "", err
+	}
+	return funcs.stackServiceAddress(ctx, name, "default", service, exposedTCPPort)
+}
+
 func (funcs *k8sTemplateFuncs) k8sServiceAddress(
 	ctx context.Context,
 	namespace string,
 	service string,
 	exposedTCPPort int,
 ) (string, error) {
-	selector, err := serviceSelector(funcs.name, service)
+	return funcs.stackServiceAddress(ctx, funcs.name, namespace, service, exposedTCPPort)
+}
+
+// stackServiceAddress gives the local address of a service of a stack,
+// that is forwarded to.
+func (funcs *k8sTemplateFuncs) stackServiceAddress(
+	ctx context.Context,
+	name names.Name,
+	namespace string,
+	service string,
+	exposedTCPPort int,
+) (string, error) {
+	selector, err := ServiceSelector(name, service)
 	if err != nil {
 		return "", err
 	}
@@ -126,7 +169,7 @@ func (funcs *k8sTemplateFuncs) k8sServiceName(
 	namespace string,
 	service string,
 ) (string, error) {
-	selector, err := serviceSelector(funcs.name, service)
+	selector, err := ServiceSelector(funcs.name, service)
 	if err != nil {
 		return "", err
 	}
@@ -9,7 +9,11 @@ import (
 	"strings"
 )
 
-func serviceSelector(name names.Name, service string) (string, error) {
+// ServiceSelector gives the Kubernetes label selector for a service of a
+// stack.  The service is either the value of the k8s.ServiceLabel label, a
+// label selector that is combined with the stack label (e.g., "foo=bar"),
+// or, if prefixed with "::", a label selector that is used as is.
+func ServiceSelector(name names.Name, service string) (string, error) {
 	var selector string
 	if strings.Contains(service, "=") {
 		if strings.HasPrefix(service, "::") {
@@ -0,0 +1,30 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package env
+
+import (
+	"context"
+	"fmt"
+	"github.com/hchauvin/warp/pkg/stacks/names"
+)
+
+type requiredStacksKey struct{}
+
+// WithRequiredStacks gives a context that carries the names of the
+// required stacks (see pipelines.Pipeline.Requires), by alias.  These
+// names are used by the "requiredServiceAddress" and "requiredStackName"
+// template functions.
+func WithRequiredStacks(ctx context.Context, required map[string]names.Name) context.Context {
+	return context.WithValue(ctx, requiredStacksKey{}, required)
+}
+
+// requiredStack gives the name of a required stack given its alias.
+func requiredStack(ctx context.Context, alias string) (names.Name, error) {
+	required, _ := ctx.Value(requiredStacksKey{}).(map[string]names.Name)
+	name, ok := required[alias]
+	if !ok {
+		return names.Name{}, fmt.Errorf("no required stack has the alias '%s'", alias)
+	}
+	return name, nil
+}
@@ -0,0 +1,27 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package env
+
+import (
+	"context"
+	"github.com/hchauvin/warp/pkg/stacks/names"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRequiredStack(t *testing.T) {
+	ctx := WithRequiredStacks(context.Background(), map[string]names.Name{
+		"db": {ShortName: "db"},
+	})
+
+	name, err := requiredStack(ctx, "db")
+	assert.NoError(t, err)
+	assert.Equal(t, names.Name{ShortName: "db"}, name)
+
+	_, err = requiredStack(ctx, "broker")
+	assert.EqualError(t, err, "no required stack has the alias 'broker'")
+
+	_, err = requiredStack(context.Background(), "db")
+	assert.EqualError(t, err, "no required stack has the alias 'db'")
+}
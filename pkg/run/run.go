@@ -14,6 +14,8 @@ import (
 	"github.com/hchauvin/warp/pkg/run/env"
 	"github.com/hchauvin/warp/pkg/stacks/names"
 	"golang.org/x/sync/errgroup"
+	"io"
+	corev1 "k8s.io/api/core/v1"
 	"os"
 	"strings"
 	"time"
@@ -204,8 +206,132 @@ func execHook(
 		if err := httpGet(ctx, cfg.Logger(), hook.HTTPGet, trans, time.After); err != nil {
 			return err
 		}
+	} else if hook.Job != nil {
+		if err := execJobHook(ctx, cfg, name, fmt.Sprintf("run:%s:before(%d)", specName, i), hook, k8sClient); err != nil {
+			return err
+		}
+	} else if hook.Exec != nil {
+		if err := execPodHook(ctx, cfg, name, fmt.Sprintf("run:%s:before(%d)", specName, i), hook, k8sClient); err != nil {
+			return err
+		}
+	} else if hook.Copy != nil {
+		if err := Copy(ctx, cfg, name, hook.Copy, cfg.Path(hook.Copy.Local), k8sClient); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// execJobHook runs a job hook.  The logs of the Job are piped to
+// the logger under the given log domain.
+func execJobHook(
+	ctx context.Context,
+	cfg *config.Config,
+	name names.Name,
+	logDomain string,
+	hook *pipelines.CommandHook,
+	k8sClient *k8s.K8s,
+) error {
+	trans := env.NewTransformer(env.K8sTemplateFuncs(cfg, name, k8sClient))
+	jobEnv := make([]corev1.EnvVar, len(hook.Job.Env))
+	for i, e := range hook.Job.Env {
+		// The format was checked when the pipeline was parsed.
+		parts := strings.SplitN(e, "=", 2)
+		value, err := trans.Get(ctx, parts[1])
+		if err != nil {
+			return fmt.Errorf("cannot transform env var '%s': %v", e, err)
+		}
+		jobEnv[i] = corev1.EnvVar{Name: parts[0], Value: value}
 	}
 
+	return k8sClient.RunJob(ctx, name, &k8s.JobSpec{
+		Name:               hook.Name,
+		Image:              hook.Job.Image,
+		Command:            hook.Job.Command,
+		Env:                jobEnv,
+		ServiceAccountName: hook.Job.ServiceAccountName,
+	}, logDomain)
+}
+
+// execPodHook runs an exec hook.  The output of the command is piped to
+// the logger under the given log domain.
+func execPodHook(
+	ctx context.Context,
+	cfg *config.Config,
+	name names.Name,
+	logDomain string,
+	hook *pipelines.CommandHook,
+	k8sClient *k8s.K8s,
+) error {
+	selector, err := env.ServiceSelector(name, hook.Exec.ServiceSelector)
+	if err != nil {
+		return err
+	}
+	podName, containerName, err := k8sClient.ServicePod(ctx, k8s.ServiceSpec{
+		Namespace: k8s.StackNamespace,
+		Labels:    selector,
+	}, hook.Exec.Container)
+	if err != nil {
+		return err
+	}
+
+	stdout, stdoutWriter := io.Pipe()
+	defer stdoutWriter.Close()
+	stderr, stderrWriter := io.Pipe()
+	defer stderrWriter.Close()
+	cfg.Logger().PipeReader(logDomain, stdout)
+	cfg.Logger().PipeReader(logDomain, stderr)
+
+	return k8sClient.Exec(
+		ctx,
+		k8s.StackNamespace,
+		podName,
+		containerName,
+		hook.Exec.Command,
+		nil,
+		stdoutWriter,
+		stderrWriter,
+	)
+}
+
+// Copy copies a file or a folder between a local path and a container of
+// a stack.  The local path of the spec is ignored in favor of the one
+// given as argument, which must be resolved by the caller.
+func Copy(
+	ctx context.Context,
+	cfg *config.Config,
+	name names.Name,
+	spec *pipelines.CopyHook,
+	localPath string,
+	k8sClient *k8s.K8s,
+) error {
+	selector, err := env.ServiceSelector(name, spec.ServiceSelector)
+	if err != nil {
+		return err
+	}
+	podName, containerName, err := k8sClient.ServicePod(ctx, k8s.ServiceSpec{
+		Namespace: k8s.StackNamespace,
+		Labels:    selector,
+	}, spec.Container)
+	if err != nil {
+		return err
+	}
+
+	switch spec.Direction {
+	case pipelines.Upload:
+		if err := k8sClient.CopyToPod(ctx, k8s.StackNamespace, podName, containerName, localPath, spec.Remote); err != nil {
+			return err
+		}
+		cfg.Logger().Info("run:copy", "copied '%s' to %s[%s]:%s", localPath, podName, containerName, spec.Remote)
+	case pipelines.Download:
+		if err := k8sClient.CopyFromPod(ctx, k8s.StackNamespace, podName, containerName, spec.Remote, localPath); err != nil {
+			return err
+		}
+		cfg.Logger().Info("run:copy", "copied %s[%s]:%s to '%s'", podName, containerName, spec.Remote, localPath)
+	default:
+		return fmt.Errorf("invalid copy direction '%s'", spec.Direction)
+	}
 	return nil
 }
 
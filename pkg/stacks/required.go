@@ -0,0 +1,250 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package stacks
+
+import (
+	"context"
+	"fmt"
+	"github.com/hchauvin/warp/pkg/config"
+	"github.com/hchauvin/warp/pkg/deploy"
+	"github.com/hchauvin/warp/pkg/k8s"
+	"github.com/hchauvin/warp/pkg/pipelines"
+	"github.com/hchauvin/warp/pkg/stacks/names"
+	"golang.org/x/sync/errgroup"
+	"sync"
+)
+
+const logDomain = "stacks"
+
+// HoldRequired holds the stacks of the pipelines required by a pipeline
+// (see pipelines.Pipeline.Requires), and deploys them.  The resources
+// that did not change since they were last applied are not applied
+// again, so required stacks that are already deployed are left as is.
+//
+// Required stacks are held non-exclusively: within this process, all the
+// pipelines that require the same pipeline share the same stack, which
+// is held with Hold by the first of them, and released when the last of
+// them releases it.  The stacks are deployed one pipeline at a time.
+//
+// The names of the required stacks are given by alias.  The required
+// stacks are held until the release function is called.  Errors that
+// occur while holding the stacks are sent to the error channel, which
+// is closed on release.
+func HoldRequired(
+	ctx context.Context,
+	cfg *config.Config,
+	pipeline *pipelines.Pipeline,
+	k8sClient *k8s.K8s,
+) (required map[string]names.Name, errc <-chan error, release func(), err error) {
+	required = make(map[string]names.Name, len(pipeline.Requires))
+	holdErrc := make(chan error, len(pipeline.Requires))
+	var releases []func()
+	var mut sync.Mutex
+	var forwarders sync.WaitGroup
+	release = func() {
+		for _, r := range releases {
+			r()
+		}
+		forwarders.Wait()
+		close(holdErrc)
+	}
+
+	g, gctx := errgroup.WithContext(ctx)
+	for _, req := range pipeline.Requires {
+		req := req
+		g.Go(func() error {
+			requiredPipeline, err := pipelines.Read(cfg, req.Pipeline)
+			if err != nil {
+				return fmt.Errorf("required pipeline '%s': %v", req.Alias, err)
+			}
+			if len(requiredPipeline.Requires) > 0 {
+				return fmt.Errorf("required pipeline '%s': required pipelines cannot have requirements themselves", req.Alias)
+			}
+
+			hold, sub, err := holdShared(cfg, requiredPipeline)
+			if err != nil {
+				return fmt.Errorf("required pipeline '%s': %v", req.Alias, err)
+			}
+			forwarders.Add(1)
+			go func() {
+				defer forwarders.Done()
+				for err := range sub {
+					select {
+					case holdErrc <- fmt.Errorf("required pipeline '%s': %v", req.Alias, err):
+					default:
+					}
+				}
+			}()
+			mut.Lock()
+			releases = append(releases, func() {
+				if err := hold.release(sub); err != nil {
+					cfg.Logger().Warning(logDomain, "could not release required stack %s (%s): %v", hold.name.DNSName(), req.Alias, err)
+				}
+			})
+			required[req.Alias] = hold.name
+			mut.Unlock()
+
+			hold.deployMut.Lock()
+			defer hold.deployMut.Unlock()
+			cfg.Logger().Info(logDomain, "required stack %s (%s): deploying", hold.name.DNSName(), req.Alias)
+			if err := deploy.Exec(gctx, cfg, requiredPipeline, hold.name, k8sClient); err != nil {
+				return fmt.Errorf("required pipeline '%s': deploy step failed: %v", req.Alias, err)
+			}
+			cfg.Logger().Info(logDomain, "required stack %s (%s): deployed", hold.name.DNSName(), req.Alias)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		release()
+		return nil, nil, nil, err
+	}
+	return required, holdErrc, release, nil
+}
+
+// sharedHolds are the stacks currently held by HoldRequired, by key
+// (see sharedHoldKey).  The mutex only guards the map: the holds are
+// guarded by their own mutex.
+var sharedHolds = struct {
+	sync.Mutex
+	m map[string]*sharedHold
+}{m: make(map[string]*sharedHold)}
+
+// sharedHold is a stack held on behalf of one or more subscribers.
+type sharedHold struct {
+	key string
+	// ready is closed once the stack is held, or could not be held.
+	// The fields below are only set before that.
+	ready chan struct{}
+	// err is the error that occurred while holding the stack.
+	err  error
+	name names.Name
+	// releaseName releases the stack when there are no more subscribers.
+	releaseName func() error
+
+	// mut guards subscribers and released.
+	mut sync.Mutex
+	// subscribers receive the errors that occur while holding the stack.
+	subscribers map[chan error]struct{}
+	// released is true once the last subscriber has unsubscribed.
+	released bool
+
+	// deployMut serializes the deployments of the stack.
+	deployMut sync.Mutex
+}
+
+// sharedHoldKey identifies the stack of a pipeline among shared holds.
+// The pipelines that give the same stack name, or the same stack
+// family, share the same stack.
+func sharedHoldKey(pipeline *pipelines.Pipeline) string {
+	if pipeline.Stack.Name != "" {
+		return "name:" + pipeline.Stack.Name
+	}
+	return "family:" + pipeline.Stack.Family
+}
+
+// holdShared holds the stack of a pipeline, or subscribes to the hold
+// if the stack is already held.  The subscription channel receives the
+// errors that occur while holding the stack, and is closed by release.
+func holdShared(cfg *config.Config, pipeline *pipelines.Pipeline) (*sharedHold, chan error, error) {
+	key := sharedHoldKey(pipeline)
+	for {
+		sharedHolds.Lock()
+		hold, ok := sharedHolds.m[key]
+		if !ok {
+			hold = &sharedHold{
+				key:         key,
+				ready:       make(chan struct{}),
+				subscribers: make(map[chan error]struct{}),
+			}
+			sharedHolds.m[key] = hold
+		}
+		sharedHolds.Unlock()
+
+		if !ok {
+			sub, err := hold.hold(cfg, pipeline)
+			if err != nil {
+				return nil, nil, err
+			}
+			return hold, sub, nil
+		}
+
+		<-hold.ready
+		if hold.err != nil {
+			return nil, nil, hold.err
+		}
+		if sub, ok := hold.subscribe(); ok {
+			return hold, sub, nil
+		}
+		// The stack was released in the meantime, and is held again
+		// on the next iteration.
+	}
+}
+
+// hold holds the stack with Hold, and subscribes to the hold.  On
+// error, the hold is removed from sharedHolds.
+func (hold *sharedHold) hold(cfg *config.Config, pipeline *pipelines.Pipeline) (chan error, error) {
+	defer close(hold.ready)
+	name, errc, releaseName, err := Hold(cfg, pipeline)
+	if err != nil {
+		hold.err = err
+		sharedHolds.Lock()
+		delete(sharedHolds.m, hold.key)
+		sharedHolds.Unlock()
+		return nil, err
+	}
+	hold.name = *name
+	hold.releaseName = releaseName
+	// The first subscriber subscribes before the hold is ready, so
+	// that the other subscribers cannot release the stack before.
+	sub, _ := hold.subscribe()
+	go hold.broadcast(errc)
+	return sub, nil
+}
+
+// subscribe subscribes to the hold.  It returns false if the stack was
+// released.
+func (hold *sharedHold) subscribe() (chan error, bool) {
+	hold.mut.Lock()
+	defer hold.mut.Unlock()
+	if hold.released {
+		return nil, false
+	}
+	sub := make(chan error, 1)
+	hold.subscribers[sub] = struct{}{}
+	return sub, true
+}
+
+// broadcast sends the errors that occur while holding the stack to all
+// the subscribers.  A subscriber that has not consumed a previous error
+// is not sent the new one.
+func (hold *sharedHold) broadcast(errc <-chan error) {
+	for err := range errc {
+		hold.mut.Lock()
+		for sub := range hold.subscribers {
+			select {
+			case sub <- err:
+			default:
+			}
+		}
+		hold.mut.Unlock()
+	}
+}
+
+// release unsubscribes from a shared hold.  The stack is released when
+// there are no more subscribers.
+func (hold *sharedHold) release(sub chan error) error {
+	hold.mut.Lock()
+	delete(hold.subscribers, sub)
+	close(sub)
+	if len(hold.subscribers) > 0 {
+		hold.mut.Unlock()
+		return nil
+	}
+	hold.released = true
+	sharedHolds.Lock()
+	delete(sharedHolds.m, hold.key)
+	sharedHolds.Unlock()
+	hold.mut.Unlock()
+	return hold.releaseName()
+}
@@ -0,0 +1,143 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2019 Hadrien Chauvin
+
+package stacks
+
+import (
+	"context"
+	"github.com/hchauvin/name_manager/pkg/name_manager"
+	"github.com/hchauvin/warp/pkg/config"
+	"github.com/hchauvin/warp/pkg/pipelines"
+	"github.com/hchauvin/warp/pkg/stacks/names"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestHoldRequired(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stacks")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for path, content := range map[string]string{
+		"db/pipeline.yml":     "stack:\n  name: db\n",
+		"broker/pipeline.yml": "stack:\n  name: broker\n",
+		"family/pipeline.yml": "stack:\n  family: family\n",
+		"nested/pipeline.yml": "stack:\n  name: nested\nrequires:\n- pipeline: db\n  alias: db\n",
+	} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(path)), 0777))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, path), []byte(content), 0666))
+	}
+	cfg := &config.Config{
+		WorkspaceDir:   dir,
+		NameManagerURL: "local://" + filepath.Join(dir, "name_manager.db"),
+	}
+
+	pipeline := &pipelines.Pipeline{
+		Requires: []pipelines.Requirement{
+			{Pipeline: "db", Alias: "db"},
+			{Pipeline: "broker", Alias: "broker"},
+		},
+	}
+	required, _, release, err := HoldRequired(context.Background(), cfg, pipeline, nil)
+	assert.NoError(t, err)
+	defer release()
+	assert.Equal(t, map[string]names.Name{
+		"db":     {ShortName: "db"},
+		"broker": {ShortName: "broker"},
+	}, required)
+
+	pipeline.Requires = []pipelines.Requirement{{Pipeline: "nested", Alias: "nested"}}
+	_, _, _, err = HoldRequired(context.Background(), cfg, pipeline, nil)
+	assert.EqualError(t, err, "required pipeline 'nested': required pipelines cannot have requirements themselves")
+}
+
+func TestHoldRequiredFamily(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stacks")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "family"), 0777))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "family/pipeline.yml"), []byte("stack:\n  family: family\n"), 0666))
+	cfg := &config.Config{
+		WorkspaceDir:   dir,
+		NameManagerURL: "local://" + filepath.Join(dir, "name_manager.db"),
+	}
+	pipeline := &pipelines.Pipeline{
+		Requires: []pipelines.Requirement{{Pipeline: "family", Alias: "family"}},
+	}
+
+	// The stack of a family is shared between the requirers.
+	required1, errc1, release1, err := HoldRequired(context.Background(), cfg, pipeline, nil)
+	assert.NoError(t, err)
+	required2, errc2, release2, err := HoldRequired(context.Background(), cfg, pipeline, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]names.Name{"family": {Family: "family", ShortName: "0"}}, required1)
+	assert.Equal(t, required1, required2)
+
+	nm, err := name_manager.CreateFromURL(cfg.NameManagerURL)
+	assert.NoError(t, err)
+	assertFree := func(expected bool) {
+		list, err := nm.List()
+		assert.NoError(t, err)
+		assert.Len(t, list, 1)
+		assert.Equal(t, expected, list[0].Free)
+	}
+
+	// The stack is released with the last requirer.
+	release1()
+	_, ok := <-errc1
+	assert.False(t, ok)
+	assertFree(false)
+	release2()
+	_, ok = <-errc2
+	assert.False(t, ok)
+	assertFree(true)
+}
+
+func TestHoldRequiredConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stacks")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "family"), 0777))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "family/pipeline.yml"), []byte("stack:\n  family: family\n"), 0666))
+	cfg := &config.Config{
+		WorkspaceDir:   dir,
+		NameManagerURL: "local://" + filepath.Join(dir, "name_manager.db"),
+	}
+	pipeline := &pipelines.Pipeline{
+		Requires: []pipelines.Requirement{{Pipeline: "family", Alias: "family"}},
+	}
+
+	// The requirers that hold the stack concurrently share it.
+	const n = 4
+	var wg sync.WaitGroup
+	required := make([]map[string]names.Name, n)
+	releases := make([]func(), n)
+	for i := 0; i < n; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var err error
+			required[i], _, releases[i], err = HoldRequired(context.Background(), cfg, pipeline, nil)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	for i := 0; i < n; i++ {
+		assert.Equal(t, map[string]names.Name{"family": {Family: "family", ShortName: "0"}}, required[i])
+		releases[i]()
+	}
+
+	nm, err := name_manager.CreateFromURL(cfg.NameManagerURL)
+	assert.NoError(t, err)
+	list, err := nm.List()
+	assert.NoError(t, err)
+	assert.Len(t, list, 1)
+	assert.True(t, list[0].Free)
+}
@@ -102,6 +102,33 @@ func Exec(
 		}
 	}
 
+	required, requiredErrc, releaseRequired, err := HoldRequired(ctx, cfg, pipeline, k8sClient)
+	if err != nil {
+		return err
+	}
+	defer releaseRequired()
+	// An error while holding the required stacks cancels the execution,
+	// and is returned instead of the cancellation error.
+	ctx, cancelRequired := context.WithCancel(env.WithRequiredStacks(ctx, required))
+	defer cancelRequired()
+	requiredErr := make(chan error, 1)
+	go func() {
+		for err := range requiredErrc {
+			select {
+			case requiredErr <- err:
+			default:
+			}
+			cancelRequired()
+		}
+	}()
+	defer func() {
+		select {
+		case rerr := <-requiredErr:
+			err = rerr
+		default:
+		}
+	}()
+
 	if err := deploy.Exec(ctx, cfg, pipeline, execCfg.Name, k8sClient); err != nil {
 		return fmt.Errorf("deploy step failed: %v", err)
 	}
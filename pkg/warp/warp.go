@@ -17,14 +17,20 @@ import (
 	"github.com/hchauvin/warp/pkg/pipelines"
 	run_batch "github.com/hchauvin/warp/pkg/run/batch"
 	"github.com/hchauvin/warp/pkg/run/batch/fsreporter"
+	// Registers the JUnit report format
+	_ "github.com/hchauvin/warp/pkg/run/batch/junitreporter"
+	"github.com/hchauvin/warp/pkg/run/env"
 	"github.com/hchauvin/warp/pkg/stacks"
 	"github.com/hchauvin/warp/pkg/stacks/names"
 	"golang.org/x/sync/errgroup"
 	"golang.org/x/sync/semaphore"
+	"io/ioutil"
+	"net"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"strings"
+	"sync"
 )
 
 const logDomain = "warp"
@@ -67,6 +73,7 @@ type HoldConfig struct {
 	DumpEnv      string
 	PersistEnv   bool
 	Wait         bool
+	ForceDeploy  bool
 }
 
 // Hold deploy a stacks, then hold it until either 1) the run specifications
@@ -88,6 +95,7 @@ func doHold(holdCfg *HoldConfig, exec execStacks) error {
 	if err != nil {
 		return err
 	}
+	cfg.ForceDeploy = holdCfg.ForceDeploy
 
 	pipeline, err := pipelines.Read(cfg, holdCfg.PipelinePath)
 	if err != nil {
@@ -161,6 +169,7 @@ type DeployCfg struct {
 	WorkingDir   string
 	ConfigPath   string
 	PipelinePath string
+	ForceDeploy  bool
 }
 
 // Deploy implements the "deploy" command.
@@ -175,6 +184,7 @@ func doDeploy(ctx context.Context, deployCfg *DeployCfg, exec execDeploy) error
 	if err != nil {
 		return err
 	}
+	cfg.ForceDeploy = deployCfg.ForceDeploy
 
 	pipeline, err := pipelines.Read(cfg, deployCfg.PipelinePath)
 	if err != nil {
@@ -195,13 +205,165 @@ func doDeploy(ctx context.Context, deployCfg *DeployCfg, exec execDeploy) error
 	}
 	defer k8sClient.Ports.CancelForwarding()
 
-	if err := exec(ctx, cfg, pipeline, names.Name{ShortName: pipeline.Stack.Name}, k8sClient); err != nil {
-		return fmt.Errorf("deploy step failed: %v", err)
+	required, requiredErrc, releaseRequired, err := stacks.HoldRequired(ctx, cfg, pipeline, k8sClient)
+	if err != nil {
+		return err
+	}
+	defer releaseRequired()
+
+	g, gctx := errgroup.WithContext(env.WithRequiredStacks(ctx, required))
+	deployed := make(chan struct{})
+	g.Go(func() error {
+		defer close(deployed)
+		if err := exec(gctx, cfg, pipeline, names.Name{ShortName: pipeline.Stack.Name}, k8sClient); err != nil {
+			return fmt.Errorf("deploy step failed: %v", err)
+		}
+		return nil
+	})
+	g.Go(func() error {
+		select {
+		case <-deployed:
+			return nil
+		case err := <-requiredErrc:
+			return err
+		}
+	})
+	return g.Wait()
+}
+
+// RenderCfg configures the "render" command.
+type RenderCfg struct {
+	WorkingDir   string
+	ConfigPath   string
+	PipelinePath string
+	// Stack is the name of the stack to render the resources for.  It
+	// is required for the pipelines with a stack family, for which it is
+	// the short name.
+	Stack string
+	// OutputDir is the folder to write the resources to, one YAML file per
+	// deploy step.  When empty, the resources are written to the standard
+	// output.
+	OutputDir string
+	// Push pushes the images, loads them into a local cluster, and
+	// resolves them to digests, as a deployment would (see deploy.Render).
+	// By default, the images are only built.
+	Push bool
+}
+
+// Render implements the "render" command.
+func Render(ctx context.Context, renderCfg *RenderCfg) error {
+	cfg, err := readConfig(renderCfg.WorkingDir, renderCfg.ConfigPath)
+	if err != nil {
+		return err
+	}
+	// The standard output is reserved for the resources.
+	cfg.Logger().Writer = os.Stderr
+
+	pipeline, err := pipelines.Read(cfg, renderCfg.PipelinePath)
+	if err != nil {
+		return err
+	}
+
+	if err := pipeline.Expand(cfg); err != nil {
+		return err
+	}
+
+	name, err := stackName(pipeline, renderCfg.Stack)
+	if err != nil {
+		return err
+	}
+
+	rendered, err := deploy.Render(ctx, cfg, pipeline, name, renderCfg.Push)
+	if err != nil {
+		return err
+	}
+
+	if renderCfg.OutputDir == "" {
+		return deploy.ConcatResources(os.Stdout, rendered)
 	}
 
+	if err := os.MkdirAll(renderCfg.OutputDir, 0777); err != nil {
+		return err
+	}
+	for _, r := range rendered {
+		b, err := ioutil.ReadFile(r.ResourcesPath)
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(renderCfg.OutputDir, r.Step+".yml")
+		if err := ioutil.WriteFile(path, b, 0666); err != nil {
+			return fmt.Errorf("cannot write '%s': %v", path, err)
+		}
+	}
 	return nil
 }
 
+// DiffCfg configures the "diff" command.
+type DiffCfg struct {
+	WorkingDir   string
+	ConfigPath   string
+	PipelinePath string
+	// Stack is the name of the stack to compare the rendered resources
+	// with (see RenderCfg).
+	Stack string
+	// Push pushes the images (see RenderCfg).
+	Push bool
+}
+
+// Diff implements the "diff" command.
+func Diff(ctx context.Context, diffCfg *DiffCfg) error {
+	cfg, err := readConfig(diffCfg.WorkingDir, diffCfg.ConfigPath)
+	if err != nil {
+		return err
+	}
+	cfg.Logger().Writer = os.Stderr
+
+	pipeline, err := pipelines.Read(cfg, diffCfg.PipelinePath)
+	if err != nil {
+		return err
+	}
+
+	if err := pipeline.Expand(cfg); err != nil {
+		return err
+	}
+
+	name, err := stackName(pipeline, diffCfg.Stack)
+	if err != nil {
+		return err
+	}
+
+	rendered, err := deploy.Render(ctx, cfg, pipeline, name, diffCfg.Push)
+	if err != nil {
+		return err
+	}
+
+	k8sClient, err := k8s.New(cfg)
+	if err != nil {
+		return err
+	}
+
+	diffs, err := deploy.Diff(k8sClient, name, rendered)
+	if err != nil {
+		return err
+	}
+	return deploy.FormatDiff(os.Stdout, diffs)
+}
+
+// stackName gives the name of a stack created from a pipeline.  For
+// pipelines with a stack family, the short name must be given.
+func stackName(pipeline *pipelines.Pipeline, shortName string) (names.Name, error) {
+	if pipeline.Stack.Family != "" {
+		if shortName == "" {
+			return names.Name{}, fmt.Errorf("the stack name must be given for the stacks of family '%s'", pipeline.Stack.Family)
+		}
+		return names.Name{Family: pipeline.Stack.Family, ShortName: shortName}, nil
+	}
+	if shortName == "" {
+		shortName = pipeline.Stack.Name
+	}
+	return names.Name{ShortName: shortName}, nil
+}
+
 // BatchCfg configures the Batch function.
 type BatchCfg struct {
 	WorkingDir           string
@@ -211,10 +373,16 @@ type BatchCfg struct {
 	MaxStacksPerPipeline int
 	Tags                 string
 	Focus                string
+	ChangedSince         string
 	Bail                 bool
 	Advisory             bool
 	Report               string
+	ReportFormats        []string
+	Reporters            []run_batch.Reporter
 	Stream               bool
+	Serve                string
+	Watch                bool
+	ForceDeploy          bool
 }
 
 // Batch executes a batch.
@@ -223,6 +391,7 @@ func Batch(ctx context.Context, batchCfg *BatchCfg) error {
 	if err != nil {
 		return err
 	}
+	cfg.ForceDeploy = batchCfg.ForceDeploy
 
 	batch, err := batches.Read(cfg, batchCfg.BatchPath)
 	if err != nil {
@@ -234,52 +403,184 @@ func Batch(ctx context.Context, batchCfg *BatchCfg) error {
 		return err
 	}
 
+	if batchCfg.ChangedSince != "" {
+		filteredBatch, err = filterChanged(ctx, cfg, filteredBatch, batchCfg.ChangedSince)
+		if err != nil {
+			return err
+		}
+	}
+
 	k8sClient, err := k8s.New(cfg)
 	if err != nil {
 		return err
 	}
 	defer k8sClient.Ports.CancelForwarding()
 
-	var reporter run_batch.Reporter
-	if batchCfg.Report == "" {
-		reporter = &run_batch.NoopReporter{}
-	} else {
-		reporter, err = fsreporter.New(batchCfg.Report)
+	reporters, err := batchReporters(batchCfg)
+	if err != nil {
+		return err
+	}
+
+	// Listening fails before the batch runs, as events could not be
+	// served.
+	var listener net.Listener
+	if batchCfg.Serve != "" {
+		listener, err = net.Listen("tcp", batchCfg.Serve)
 		if err != nil {
-			return err
+			return fmt.Errorf("cannot listen on '%s': %v", batchCfg.Serve, err)
 		}
 	}
 
-	var events chan interface{}
+	var sinks []chan interface{}
 	runBatchDone := make(chan struct{})
-	var interactiveReportDone chan struct{}
+	var sinksDone sync.WaitGroup
 	if !batchCfg.Stream {
-		events = make(chan interface{})
-		interactiveReportDone = make(chan struct{})
+		eventc := make(chan interface{})
+		sinks = append(sinks, eventc)
+		sinksDone.Add(1)
 		go func() {
-			defer func() {
-				interactiveReportDone <- struct{}{}
-			}()
-			if err := interactive.Report(cfg.Logger(), events, runBatchDone); err != nil {
+			defer sinksDone.Done()
+			if err := interactive.Report(cfg.Logger(), eventc, runBatchDone); err != nil {
 				cfg.Logger().Error("interactive", "%v", err)
 			}
+			drainSink(eventc, runBatchDone)
+		}()
+	}
+	if batchCfg.Serve != "" {
+		eventc := make(chan interface{})
+		sinks = append(sinks, eventc)
+		sinksDone.Add(1)
+		go func() {
+			defer sinksDone.Done()
+			if err := interactive.Serve(cfg.Logger(), listener, eventc, runBatchDone); err != nil {
+				cfg.Logger().Error("interactive", "%v", err)
+			}
+			drainSink(eventc, runBatchDone)
+		}()
+	}
+
+	if batchCfg.Watch {
+		// In watch mode, the batch runs until interrupted.  The stacks
+		// are released on interrupt.
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		signalc := make(chan os.Signal, 1)
+		signal.Notify(signalc, os.Interrupt)
+		defer signal.Stop(signalc)
+		go func() {
+			select {
+			case <-ctx.Done():
+			case <-signalc:
+				cfg.Logger().Info(logDomain, "cleaning up...")
+				cancel()
+			}
 		}()
 	}
+
+	var events chan interface{}
+	fanOutDone := make(chan struct{})
+	if len(sinks) > 0 {
+		events = make(chan interface{})
+		go func() {
+			defer close(fanOutDone)
+			for e := range events {
+				for _, sink := range sinks {
+					sink <- e
+				}
+			}
+		}()
+	} else {
+		close(fanOutDone)
+	}
+
 	err = run_batch.RunBatch(ctx, cfg, filteredBatch, &run_batch.RunBatchOptions{
 		Parallelism:          batchCfg.Parallelism,
 		MaxStacksPerPipeline: batchCfg.MaxStacksPerPipeline,
 		Bail:                 batchCfg.Bail,
 		Advisory:             batchCfg.Advisory,
-		Reporter:             reporter,
+		Reporter:             &run_batch.NoopReporter{},
+		Reporters:            reporters,
+		ReportDir:            batchCfg.Report,
 		Events:               events,
+		Watch:                batchCfg.Watch,
 	}, k8sClient)
-	close(runBatchDone)
-	if interactiveReportDone != nil {
-		<-interactiveReportDone
+	if events != nil {
+		close(events)
 	}
+	<-fanOutDone
+	close(runBatchDone)
+	sinksDone.Wait()
 	return err
 }
 
+// drainSink consumes the events sent to a sink until done is closed, so
+// that a sink that stopped early does not block the fan-out of events.
+func drainSink(eventc <-chan interface{}, done <-chan struct{}) {
+	for {
+		select {
+		case <-eventc:
+		case <-done:
+			return
+		}
+	}
+}
+
+// filterChanged filters a batch to only keep the commands affected by the
+// changes since a git ref.
+func filterChanged(ctx context.Context, cfg *config.Config, batch *batches.Batch, ref string) (*batches.Batch, error) {
+	changedFiles, err := batches.ChangedFiles(ctx, cfg, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	pipelineSources := make(map[string][]string, len(batch.Pipelines))
+	for _, p := range batch.Pipelines {
+		pipeline, err := pipelines.Read(cfg, p.Path)
+		if err != nil {
+			return nil, err
+		}
+		pipelineSources[p.Name] = pipeline.Sources(cfg)
+	}
+
+	filteredBatch, err := batch.FilterChanged(changedFiles, pipelineSources)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Logger().Info(
+		logDomain,
+		"%d files changed since %s; %d/%d commands selected",
+		len(changedFiles),
+		ref,
+		len(filteredBatch.Commands),
+		len(batch.Commands))
+	return filteredBatch, nil
+}
+
+// batchReporters creates the reporters for the Batch function.  When a
+// report path is given without any format, the "fs" format is used.
+func batchReporters(batchCfg *BatchCfg) ([]run_batch.Reporter, error) {
+	reporters := append([]run_batch.Reporter(nil), batchCfg.Reporters...)
+	formats := batchCfg.ReportFormats
+	if batchCfg.Report == "" {
+		if len(formats) > 0 {
+			return nil, errors.New("a report format is given, but no report path")
+		}
+		return reporters, nil
+	}
+	if len(formats) == 0 {
+		formats = []string{fsreporter.Format}
+	}
+	for _, format := range formats {
+		reporter, err := run_batch.NewReporter(format, batchCfg.Report)
+		if err != nil {
+			return nil, err
+		}
+		reporters = append(reporters, reporter)
+	}
+	return reporters, nil
+}
+
 // GcCfg configures the "gc" command.
 type GcCfg struct {
 	WorkingDir                     string
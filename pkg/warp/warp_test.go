@@ -11,6 +11,9 @@ import (
 	"github.com/hchauvin/warp/pkg/config"
 	"github.com/hchauvin/warp/pkg/k8s"
 	"github.com/hchauvin/warp/pkg/pipelines"
+	run_batch "github.com/hchauvin/warp/pkg/run/batch"
+	"github.com/hchauvin/warp/pkg/run/batch/fsreporter"
+	"github.com/hchauvin/warp/pkg/run/batch/junitreporter"
 	"github.com/hchauvin/warp/pkg/stacks"
 	"github.com/hchauvin/warp/pkg/stacks/names"
 	"github.com/pelletier/go-toml"
@@ -278,3 +281,72 @@ func (gcClient *mockGcClient) Gc(ctx context.Context, cfg *config.Config, name n
 	})
 	return nil
 }
+
+func TestBatchReporters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "warp_batch")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	report := filepath.Join(dir, "report")
+
+	reporters, err := batchReporters(&BatchCfg{})
+	assert.NoError(t, err)
+	assert.Len(t, reporters, 0)
+
+	reporters, err = batchReporters(&BatchCfg{Report: report})
+	assert.NoError(t, err)
+	assert.Len(t, reporters, 1)
+	assert.IsType(t, &fsreporter.FsReporter{}, reporters[0])
+
+	custom := &run_batch.NoopReporter{}
+	reporters, err = batchReporters(&BatchCfg{
+		Report:        report,
+		ReportFormats: []string{"fs", "junit"},
+		Reporters:     []run_batch.Reporter{custom},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, reporters, 3)
+	assert.Equal(t, custom, reporters[0])
+	assert.IsType(t, &junitreporter.JUnitReporter{}, reporters[2])
+
+	_, err = batchReporters(&BatchCfg{ReportFormats: []string{"fs"}})
+	assert.Error(t, err)
+
+	_, err = batchReporters(&BatchCfg{Report: report, ReportFormats: []string{"unknown"}})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown report format")
+}
+
+func TestStackName(t *testing.T) {
+	pipeline := &pipelines.Pipeline{Stack: pipelines.Stack{Name: "staging"}}
+	name, err := stackName(pipeline, "")
+	assert.NoError(t, err)
+	assert.Equal(t, names.Name{ShortName: "staging"}, name)
+
+	name, err = stackName(pipeline, "other")
+	assert.NoError(t, err)
+	assert.Equal(t, names.Name{ShortName: "other"}, name)
+
+	pipeline = &pipelines.Pipeline{Stack: pipelines.Stack{Family: "api"}}
+	_, err = stackName(pipeline, "")
+	assert.Error(t, err)
+
+	name, err = stackName(pipeline, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, names.Name{Family: "api", ShortName: "a"}, name)
+}
+
+func TestDrainSink(t *testing.T) {
+	eventc := make(chan interface{})
+	done := make(chan struct{})
+	drained := make(chan struct{})
+	go func() {
+		drainSink(eventc, done)
+		close(drained)
+	}()
+
+	// Sending to a drained sink does not block.
+	eventc <- "event"
+	eventc <- "event"
+	close(done)
+	<-drained
+}